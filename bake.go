@@ -0,0 +1,307 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
+	"github.com/drone-plugins/drone-plugin-lib/drone"
+)
+
+// Bake defines docker buildx bake parameters.
+type Bake struct {
+	Files   []string // Bake definition files, docker-bake.hcl by default
+	Targets []string // Targets and groups to build, the default group by default
+	Set     []string // Additional target overrides (target.key=value)
+}
+
+// enabled reports whether the step builds with docker buildx bake.
+func (b Bake) enabled() bool {
+	return len(b.Files) > 0 || len(b.Targets) > 0
+}
+
+// bakeDefinition is the part of the resolved bake definition, as printed by
+// docker buildx bake --print, the plugin overrides.
+type bakeDefinition struct {
+	Target map[string]struct {
+		Tags []string `json:"tags"`
+	} `json:"target"`
+}
+
+// targets returns the names of the resolved targets, sorted.
+func (d *bakeDefinition) targets() []string {
+	if d == nil {
+		return nil
+	}
+	names := make([]string, 0, len(d.Target))
+	for name := range d.Target {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bakeMetadata is the build result of a target, as written to the bake
+// metadata file.
+type bakeMetadata struct {
+	Digest    string `json:"containerimage.digest"`
+	ImageName string `json:"image.name"`
+}
+
+// bake builds and pushes the bake targets, then signs the pushed images and
+// writes the outputs and the artifact file listing the digest of every
+// target.
+func (p Plugin) bake() error {
+	definition, err := resolveBake(p.Bake, p.Build.Builder)
+	if err != nil {
+		return err
+	}
+	// the file docker buildx bake writes the build results to
+	metadataFile, err := os.CreateTemp("", "drone-docker-bake-metadata-*.json")
+	if err != nil {
+		return fmt.Errorf("unable to create the bake metadata file: %w", err)
+	}
+	metadataFile.Close()
+	defer os.Remove(metadataFile.Name())
+
+	start := time.Now()
+	stats := &buildStats{}
+	err = runCommand(commandBake(p.Bake, p.Build, definition, metadataFile.Name(), !p.Dryrun), stats)
+	p.report.recordBuild("", start, stats, "", err)
+	p.recordSpan("docker.bake", start, err, telemetry.AttrRepo.String(p.Build.Repo))
+	if err != nil {
+		return err
+	}
+	if p.Dryrun {
+		return nil
+	}
+
+	metadata, err := readBakeMetadata(metadataFile.Name())
+	if err != nil {
+		return err
+	}
+	var artifacts []drone.Image
+	for _, target := range definition.targets() {
+		result, ok := metadata[target]
+		if !ok || result.ImageName == "" {
+			continue
+		}
+		images := strings.Split(result.ImageName, ",")
+		for _, image := range images {
+			p.report.recordBakePush(image, result.Digest)
+			artifacts = append(artifacts, drone.Image{Image: image, Digest: result.Digest})
+		}
+
+		// the outputs of a target are prefixed with its name
+		repo, _ := splitImageTag(images[0])
+		tp := p
+		tp.imageName = target
+		tp.Build.Repo = repo
+		tp.exportOutputs(imageTags(repo, images), result.Digest)
+
+		if p.shouldSignWithCosign() && result.Digest != "" {
+			for _, repo := range imageRepos(images) {
+				p.sign(repo + "@" + result.Digest)
+			}
+		}
+	}
+
+	if p.ArtifactFile != "" && len(artifacts) > 0 {
+		if err := writeArtifactFile(p.Daemon.RegistryType, p.ArtifactFile, p.Daemon.Registry, artifacts); err != nil {
+			fmt.Printf("failed to write plugin artifact file at path: %s with error: %s\n", p.ArtifactFile, err)
+		}
+	}
+	return nil
+}
+
+// resolveBake returns the definition of the targets selected by bake.
+func resolveBake(bake Bake, builder string) (*bakeDefinition, error) {
+	args := append([]string{"buildx", "bake"}, bakeFileArgs(bake.Files)...)
+	if builder != "" {
+		args = append(args, "--builder", builder)
+	}
+	args = append(args, "--print")
+	args = append(args, bake.Targets...)
+
+	output, err := exec.Command(dockerExe, args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("unable to resolve the bake targets: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("unable to resolve the bake targets: %w", err)
+	}
+	return parseBakeDefinition(output)
+}
+
+func parseBakeDefinition(data []byte) (*bakeDefinition, error) {
+	var definition bakeDefinition
+	if err := json.Unmarshal(data, &definition); err != nil {
+		return nil, fmt.Errorf("invalid bake definition: %w", err)
+	}
+	if len(definition.Target) == 0 {
+		return nil, fmt.Errorf("no bake target to build")
+	}
+	return &definition, nil
+}
+
+// helper function to create the docker buildx bake command. The build args,
+// labels, secrets, pull and cache settings of the step apply to every
+// target, and the step tags replace the tags of each target, keeping the
+// repositories of the target or using the step repo when it has none.
+func commandBake(bake Bake, build Build, definition *bakeDefinition, metadataFile string, push bool) *exec.Cmd {
+	args := append([]string{"buildx", "bake"}, bakeFileArgs(bake.Files)...)
+	if build.Builder != "" {
+		args = append(args, "--builder", build.Builder)
+	}
+	for _, override := range bakeOverrides(build, definition) {
+		args = append(args, "--set", override)
+	}
+	for _, override := range bake.Set {
+		args = append(args, "--set", override)
+	}
+	if metadataFile != "" {
+		args = append(args, "--metadata-file", metadataFile)
+	}
+	if push {
+		args = append(args, "--push")
+	}
+	args = append(args, bake.Targets...)
+	return exec.Command(dockerExe, args...)
+}
+
+func bakeFileArgs(files []string) []string {
+	var args []string
+	for _, file := range files {
+		args = append(args, "-f", file)
+	}
+	return args
+}
+
+// bakeOverrides returns the --set values applying the step settings to the
+// bake targets.
+func bakeOverrides(build Build, definition *bakeDefinition) []string {
+	var overrides []string
+	for _, arg := range build.ArgsEnv {
+		addProxyValue(&build, arg)
+	}
+	args := build.Args
+	if build.IsMultipleBuildArgs {
+		args = build.ArgsNew
+	}
+	for _, arg := range args {
+		key, value, _ := strings.Cut(arg, "=")
+		overrides = append(overrides, fmt.Sprintf("*.args.%s=%s", key, value))
+	}
+	for _, label := range buildLabels(build) {
+		key, value, _ := strings.Cut(label, "=")
+		overrides = append(overrides, fmt.Sprintf("*.labels.%s=%s", key, value))
+	}
+	if build.Pull {
+		overrides = append(overrides, "*.pull=true")
+	}
+	if build.NoCache {
+		overrides = append(overrides, "*.no-cache=true")
+	}
+	for _, cache := range build.CacheFrom {
+		overrides = append(overrides, "*.cache-from="+cache)
+	}
+	if build.Platform != "" {
+		overrides = append(overrides, "*.platform="+build.Platform)
+	}
+	if build.Secret != "" {
+		overrides = append(overrides, "*.secrets="+build.Secret)
+	}
+	for _, secret := range build.SecretEnvs {
+		if arg, err := getSecretStringCmdArg(secret); err == nil {
+			overrides = append(overrides, "*.secrets="+arg)
+		}
+	}
+	for _, secret := range build.SecretFiles {
+		if arg, err := getSecretFileCmdArg(secret); err == nil {
+			overrides = append(overrides, "*.secrets="+arg)
+		}
+	}
+	if build.SSHKeyPath != "" {
+		overrides = append(overrides, "*.ssh="+build.SSHKeyPath)
+	}
+
+	for _, target := range definition.targets() {
+		for _, image := range bakeTargetImages(build, definition, target) {
+			overrides = append(overrides, target+".tags="+image)
+		}
+	}
+	return overrides
+}
+
+// bakeTargetImages returns the step tags of the repositories of target, or
+// of the step repo when the target has none. No image is returned when the
+// step has no tags, the target keeps its own.
+func bakeTargetImages(build Build, definition *bakeDefinition, target string) []string {
+	if len(build.Tags) == 0 {
+		return nil
+	}
+	repos := imageRepos(definition.Target[target].Tags)
+	if len(repos) == 0 && build.Repo != "" {
+		repos = []string{build.Repo}
+	}
+	var images []string
+	for _, repo := range repos {
+		for _, tag := range build.Tags {
+			images = append(images, fmt.Sprintf("%s:%s", repo, tag))
+		}
+	}
+	return images
+}
+
+// imageRepos returns the distinct repositories of the image references, in
+// order.
+func imageRepos(images []string) []string {
+	var repos []string
+	seen := map[string]bool{}
+	for _, image := range images {
+		repo, _ := splitImageTag(image)
+		if !seen[repo] {
+			seen[repo] = true
+			repos = append(repos, repo)
+		}
+	}
+	return repos
+}
+
+// imageTags returns the tags of the image references of repo.
+func imageTags(repo string, images []string) []string {
+	var tags []string
+	for _, image := range images {
+		if r, tag := splitImageTag(image); r == repo && tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// readBakeMetadata reads the build result of every target from the bake
+// metadata file.
+func readBakeMetadata(path string) (map[string]bakeMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the bake metadata: %w", err)
+	}
+	// the file also holds non-target entries, such as buildx.build.warnings
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid bake metadata: %w", err)
+	}
+	metadata := map[string]bakeMetadata{}
+	for name, value := range raw {
+		var result bakeMetadata
+		if json.Unmarshal(value, &result) == nil {
+			metadata[name] = result
+		}
+	}
+	return metadata, nil
+}
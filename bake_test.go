@@ -0,0 +1,175 @@
+package docker
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testBakeDefinition = `{
+  "group": {"default": {"targets": ["api", "worker"]}},
+  "target": {
+    "api": {"context": ".", "dockerfile": "api.Dockerfile", "tags": ["quay.io/octocat/api:dev", "quay.io/octocat/api:edge", "ghcr.io/octocat/api:dev"]},
+    "worker": {"context": ".", "dockerfile": "worker.Dockerfile"}
+  }
+}`
+
+func TestParseBakeDefinition(t *testing.T) {
+	definition, err := parseBakeDefinition([]byte(testBakeDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"api", "worker"}; !reflect.DeepEqual(definition.targets(), want) {
+		t.Errorf("Got targets %v, want %v", definition.targets(), want)
+	}
+	if _, err := parseBakeDefinition([]byte(`{"group": {}}`)); err == nil {
+		t.Errorf("Expected an error for a definition without targets")
+	}
+	if _, err := parseBakeDefinition([]byte(`not json`)); err == nil {
+		t.Errorf("Expected an error for an invalid definition")
+	}
+}
+
+func TestCommandBake(t *testing.T) {
+	definition, err := parseBakeDefinition([]byte(testBakeDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcs := []struct {
+		name       string
+		bake       Bake
+		build      Build
+		definition *bakeDefinition
+		push       bool
+		want       *exec.Cmd
+	}{
+		{
+			name:  "tags of the target repos",
+			bake:  Bake{Files: []string{"docker-bake.hcl"}, Targets: []string{"default"}},
+			build: Build{Repo: "octocat/app", Tags: []string{"1.0"}},
+			push:  true,
+			want: exec.Command(dockerExe, "buildx", "bake", "-f", "docker-bake.hcl",
+				"--set", "api.tags=quay.io/octocat/api:1.0",
+				"--set", "api.tags=ghcr.io/octocat/api:1.0",
+				"--set", "worker.tags=octocat/app:1.0",
+				"--metadata-file", "metadata.json",
+				"--push",
+				"default",
+			),
+		},
+		{
+			name: "step settings",
+			bake: Bake{Set: []string{"*.cache-to=type=inline"}},
+			build: Build{
+				Builder:     "drone-remote",
+				Args:        []string{"VERSION=1.0"},
+				Labels:      []string{"team=core", "beta"},
+				Pull:        true,
+				NoCache:     true,
+				CacheFrom:   []string{"octocat/app:cache"},
+				Platform:    "linux/arm64",
+				SecretEnvs:  []string{"npm=NPM_TOKEN"},
+				SecretFiles: []string{"netrc=/root/.netrc"},
+			},
+			definition: definition,
+			want: exec.Command(dockerExe, "buildx", "bake", "--builder", "drone-remote",
+				"--set", "*.args.VERSION=1.0",
+				"--set", "*.labels.team=core",
+				"--set", "*.labels.beta=",
+				"--set", "*.pull=true",
+				"--set", "*.no-cache=true",
+				"--set", "*.cache-from=octocat/app:cache",
+				"--set", "*.platform=linux/arm64",
+				"--set", "*.secrets=id=npm,env=NPM_TOKEN",
+				"--set", "*.secrets=id=netrc,src=/root/.netrc",
+				"--set", "*.cache-to=type=inline",
+				"--metadata-file", "metadata.json",
+			),
+		},
+		{
+			name: "multiple build args",
+			build: Build{
+				Args:                []string{"IGNORED=1"},
+				ArgsNew:             []string{"LIST=a,b"},
+				IsMultipleBuildArgs: true,
+			},
+			want: exec.Command(dockerExe, "buildx", "bake",
+				"--set", "*.args.LIST=a,b",
+				"--metadata-file", "metadata.json",
+			),
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.definition == nil {
+				tc.definition = definition
+			}
+			cmd := commandBake(tc.bake, tc.build, tc.definition, "metadata.json", tc.push)
+			if !reflect.DeepEqual(cmd.Args, tc.want.Args) {
+				t.Errorf("Got cmd %v, want %v", cmd.Args, tc.want.Args)
+			}
+		})
+	}
+}
+
+func TestReadBakeMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.json")
+	data := `{
+  "api": {"containerimage.digest": "sha256:abc", "image.name": "quay.io/octocat/api:1.0,ghcr.io/octocat/api:1.0"},
+  "buildx.build.warnings": [{"vertex": "sha256:def", "level": 1}]
+}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := readBakeMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bakeMetadata{
+		"api": {Digest: "sha256:abc", ImageName: "quay.io/octocat/api:1.0,ghcr.io/octocat/api:1.0"},
+	}
+	if !reflect.DeepEqual(metadata, want) {
+		t.Errorf("Got metadata %+v, want %+v", metadata, want)
+	}
+	if _, err := readBakeMetadata(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("Expected an error for a missing metadata file")
+	}
+}
+
+func TestImageRepos(t *testing.T) {
+	images := []string{"quay.io/octocat/api:1.0", "localhost:5000/api:1.0", "quay.io/octocat/api:latest", "octocat/api"}
+	if want := []string{"quay.io/octocat/api", "localhost:5000/api", "octocat/api"}; !reflect.DeepEqual(imageRepos(images), want) {
+		t.Errorf("Got repos %v, want %v", imageRepos(images), want)
+	}
+	if want := []string{"1.0", "latest"}; !reflect.DeepEqual(imageTags("quay.io/octocat/api", images), want) {
+		t.Errorf("Got tags %v, want %v", imageTags("quay.io/octocat/api", images), want)
+	}
+}
+
+func TestValidateBake(t *testing.T) {
+	p := Plugin{
+		PushOnly: true,
+		Images:   []Image{{Name: "api", Repo: "octocat/api"}},
+		Bake: Bake{
+			Files: []string{filepath.Join(t.TempDir(), "docker-bake.hcl")},
+			Set:   []string{"*.platform=linux/amd64", "platform=linux/amd64"},
+		},
+		Build: Build{Tags: []string{"latest"}},
+	}
+	err := p.validate().Err()
+	if err == nil {
+		t.Fatal("Expected errors")
+	}
+	for _, want := range []string{"cannot be combined with push_only", "cannot be combined with images", "docker-bake.hcl not found", `invalid entry "platform=linux/amd64"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected an error mentioning %q, got %s", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "PLUGIN_REPO") {
+		t.Errorf("The step repo should not be required with bake, got %s", err)
+	}
+}
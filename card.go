@@ -15,6 +15,8 @@ import (
 	"github.com/drone/drone-go/drone"
 
 	"github.com/inhies/go-bytesize"
+
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
 )
 
 // writeCard maintains backward compatibility by using TempTag
@@ -23,7 +25,11 @@ func (p Plugin) writeCard() error {
 }
 
 // writeCardForImage generates card for any image reference
-func (p Plugin) writeCardForImage(imageRef string) error {
+func (p Plugin) writeCardForImage(imageRef string) (err error) {
+	defer func(start time.Time) {
+		p.recordSpan("drone.card", start, err, telemetry.AttrImage.String(imageRef))
+	}(time.Now())
+
 	cmd := exec.Command(dockerExe, "inspect", imageRef)
 	data, err := cmd.CombinedOutput()
 	if err != nil {
@@ -51,7 +57,9 @@ func (p Plugin) writeCardForImage(imageRef string) error {
 	}
 	// create the url from repo and registry
 	inspect.URL = mapRegistryToURL(p.Daemon.Registry, p.Build.Repo)
+	inspect.SmokeTests = p.smokeResults
 	cardData, _ := json.Marshal(inspect)
+	cardData = []byte(secrets.Redact(string(cardData)))
 
 	card := drone.CardInput{
 		Schema: "https://drone-plugins.github.io/drone-docker/card.json",
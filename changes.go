@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ChangeFilter defines the files whose changes trigger the build. The step
+// is skipped when the commit changes none of them.
+type ChangeFilter struct {
+	SkipUnchanged bool     // Build skipped when the watched paths did not change
+	Paths         []string // Patterns of the watched paths, the contexts and Dockerfiles by default
+	PathsIgnore   []string // Patterns of the paths whose changes are ignored
+	Commit        string   // Commit built, HEAD by default
+	Event         string   // Build event, the changes of a pull request are compared to its base
+	TargetBranch  string   // Branch a pull request targets
+}
+
+// enabled reports whether the build depends on the changed files.
+func (c ChangeFilter) enabled() bool {
+	return c.SkipUnchanged || len(c.Paths) > 0 || len(c.PathsIgnore) > 0
+}
+
+// watchedPaths returns the patterns of the paths whose changes trigger the
+// build: the configured paths, or the contexts and Dockerfiles of the step.
+func (p Plugin) watchedPaths() []string {
+	if len(p.Changes.Paths) > 0 {
+		return p.Changes.Paths
+	}
+	if p.Bake.enabled() {
+		// the contexts of the bake targets are not known before the build
+		return []string{"**"}
+	}
+	var paths []string
+	add := func(name string) {
+		if name == "" {
+			return
+		}
+		if filepath.IsAbs(name) {
+			wd, _ := os.Getwd()
+			if rel, err := filepath.Rel(wd, name); err == nil {
+				name = rel
+			}
+		}
+		name = path.Clean(filepath.ToSlash(name))
+		if name == "." {
+			name = "**"
+		}
+		for _, existing := range paths {
+			if existing == name {
+				return
+			}
+		}
+		paths = append(paths, name)
+	}
+	if len(p.Images) > 0 {
+		add(p.Compose.File)
+		for _, image := range p.Images {
+			add(image.Context)
+			add(image.Dockerfile)
+		}
+		return paths
+	}
+	if contextKind(p.Build.Context) != contextLocal {
+		// the changes of a remote context are not known
+		return []string{"**"}
+	}
+	add(p.Build.Context)
+	add(p.Build.Dockerfile)
+	return paths
+}
+
+// changeBase returns the commit the changes are compared to: the merge base
+// with the target branch for a pull request, the parent commit otherwise.
+func (c ChangeFilter) changeBase() (string, error) {
+	commit := c.commit()
+	if c.Event != "pull_request" || c.TargetBranch == "" {
+		return commit + "^", nil
+	}
+	remote := "refs/remotes/origin/" + c.TargetBranch
+	fetch := exec.Command("git", "fetch", "--no-tags", "origin", "+refs/heads/"+c.TargetBranch+":"+remote)
+	trace(fetch)
+	if output, err := fetch.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("unable to fetch the target branch %s: %s", c.TargetBranch, strings.TrimSpace(string(output)))
+	}
+	output, err := exec.Command("git", "merge-base", remote, commit).Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to find the merge base with %s: %w", c.TargetBranch, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (c ChangeFilter) commit() string {
+	if c.Commit == "" {
+		return "HEAD"
+	}
+	return c.Commit
+}
+
+// changedFiles returns the files changed between the base and the commit.
+func (c ChangeFilter) changedFiles() ([]string, error) {
+	base, err := c.changeBase()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("git", "diff", "--name-only", base, c.commit())
+	trace(cmd)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the changed files: %w", err)
+	}
+	var files []string
+	for _, file := range strings.Split(string(output), "\n") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// relevantChanges returns the changed files matching a watched path and no
+// ignored path. Patterns follow the .dockerignore syntax and match the
+// files of the directories they name.
+func relevantChanges(files, paths, ignore []string) ([]string, error) {
+	watched, err := parseDockerignore(strings.Join(paths, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	ignored, err := parseDockerignore(strings.Join(ignore, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	var relevant []string
+	for _, file := range files {
+		if watched.ignored(file) && !ignored.ignored(file) {
+			relevant = append(relevant, file)
+		}
+	}
+	return relevant, nil
+}
+
+// changed reports whether the commit changes a watched path. The step is
+// built when the changes cannot be listed, such as for the first commit.
+func (p Plugin) changed() (bool, error) {
+	paths := p.watchedPaths()
+	files, err := p.Changes.changedFiles()
+	if err != nil {
+		fmt.Printf("Could not list the changed files, building. %s\n", err)
+		return true, nil
+	}
+	relevant, err := relevantChanges(files, paths, p.Changes.PathsIgnore)
+	if err != nil {
+		return false, err
+	}
+	if len(relevant) == 0 {
+		fmt.Printf("No change to %s, skipping the build\n", strings.Join(paths, ", "))
+		return false, nil
+	}
+	fmt.Printf("%d changed file(s) in %s\n", len(relevant), strings.Join(paths, ", "))
+	return true, nil
+}
@@ -0,0 +1,150 @@
+package docker
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRelevantChanges(t *testing.T) {
+	files := []string{
+		"README.md",
+		"services/api/main.go",
+		"services/api/main_test.go",
+		"services/api/docs/index.md",
+		"services/web/index.js",
+		"docker/api.Dockerfile",
+	}
+	tcs := []struct {
+		name   string
+		paths  []string
+		ignore []string
+		want   []string
+	}{
+		{
+			name:  "directory",
+			paths: []string{"services/api"},
+			want:  []string{"services/api/main.go", "services/api/main_test.go", "services/api/docs/index.md"},
+		},
+		{
+			name:   "ignored",
+			paths:  []string{"services/api", "docker/api.Dockerfile"},
+			ignore: []string{"**/*_test.go", "**/*.md"},
+			want:   []string{"services/api/main.go", "docker/api.Dockerfile"},
+		},
+		{
+			name:  "everything",
+			paths: []string{"**"},
+			want:  files,
+		},
+		{
+			name:  "unchanged",
+			paths: []string{"services/worker"},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := relevantChanges(files, tc.paths, tc.ignore)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Expected changes %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWatchedPaths(t *testing.T) {
+	wd, _ := os.Getwd()
+	tcs := []struct {
+		name   string
+		plugin Plugin
+		want   []string
+	}{
+		{
+			name:   "configured",
+			plugin: Plugin{Changes: ChangeFilter{Paths: []string{"api"}}, Build: Build{Context: "."}},
+			want:   []string{"api"},
+		},
+		{
+			name:   "context and dockerfile",
+			plugin: Plugin{Build: Build{Context: "./services/api/", Dockerfile: "docker/api.Dockerfile"}},
+			want:   []string{"services/api", "docker/api.Dockerfile"},
+		},
+		{
+			name:   "absolute context",
+			plugin: Plugin{Build: Build{Context: filepath.Join(wd, "services/api"), Dockerfile: "services/api/Dockerfile"}},
+			want:   []string{"services/api", "services/api/Dockerfile"},
+		},
+		{
+			name:   "workspace context",
+			plugin: Plugin{Build: Build{Context: ".", Dockerfile: "Dockerfile"}},
+			want:   []string{"**", "Dockerfile"},
+		},
+		{
+			name: "images",
+			plugin: Plugin{Images: []Image{
+				{Context: "api", Dockerfile: "api/Dockerfile"},
+				{Context: "web", Dockerfile: "api/Dockerfile"},
+			}},
+			want: []string{"api", "api/Dockerfile", "web"},
+		},
+		{
+			name:   "bake",
+			plugin: Plugin{Bake: Bake{Targets: []string{"default"}}},
+			want:   []string{"**"},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.plugin.watchedPaths(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Expected watched paths %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestChanged(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	t.Chdir(dir)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, output)
+		}
+	}
+	commit := func(name string) {
+		t.Helper()
+		os.MkdirAll(filepath.Dir(name), 0755)
+		os.WriteFile(name, []byte(name), 0644)
+		git("add", "-A")
+		git("commit", "-q", "-m", name)
+	}
+	git("init", "-q")
+	commit("api/main.go")
+
+	p := Plugin{
+		Changes: ChangeFilter{SkipUnchanged: true},
+		Build:   Build{Context: "api", Dockerfile: "api/Dockerfile"},
+	}
+	if changed, err := p.changed(); err != nil || !changed {
+		t.Errorf("Expected the first commit to be built, got %v, %v", changed, err)
+	}
+
+	commit("web/index.js")
+	if changed, err := p.changed(); err != nil || changed {
+		t.Errorf("Expected the build to be skipped, got %v, %v", changed, err)
+	}
+
+	commit("api/Dockerfile")
+	if changed, err := p.changed(); err != nil || !changed {
+		t.Errorf("Expected the build to run, got %v, %v", changed, err)
+	}
+}
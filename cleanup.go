@@ -0,0 +1,129 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// cleanupResult records the outcome of the cleanup phase. Cleanup failures
+// never change the result of the build; they are reported on their own.
+type cleanupResult struct {
+	Removed []string // Local image references that were removed
+	Pruned  []string // Prune operations that completed
+	Errors  []error  // Failures encountered while cleaning up
+}
+
+// Err returns the combined cleanup error, or nil when cleanup succeeded.
+func (r cleanupResult) Err() error {
+	return errors.Join(r.Errors...)
+}
+
+// cleanupImages returns the local image references created by the plugin
+// that should be removed during cleanup: the temporary build tags and every
+// tag pushed to the target repositories, for each image of a multi-image
+// step.
+func (p Plugin) cleanupImages() []string {
+	if len(p.Images) > 0 {
+		var images []string
+		for i := range p.Images {
+			images = append(images, p.imagePlugin(i).cleanupImages()...)
+		}
+		return images
+	}
+
+	var images []string
+	if !p.PushOnly && p.Build.TempTag != "" {
+		images = append(images, p.Build.TempTag)
+		if p.Test.enabled() {
+			images = append(images, p.testBuild().TempTag)
+		}
+	}
+	if p.PushOnly && p.SourceImage == "" {
+		// the pushed tags are the user's own source images, keep them
+		return images
+	}
+	for _, tag := range p.Build.Tags {
+		image := fmt.Sprintf("%s:%s", p.Build.Repo, tag)
+		if p.PushOnly && (image == p.SourceImage || image == p.SourceImage+":latest") {
+			// never remove the image the user asked us to push
+			continue
+		}
+		images = append(images, image)
+	}
+	return images
+}
+
+// cleanup removes the images created by the plugin and prunes the daemon.
+// It runs every step even when an earlier one fails. A daemon the plugin did
+// not start is never pruned.
+func (p Plugin) cleanup() cleanupResult {
+	var result cleanupResult
+
+	for _, image := range p.cleanupImages() {
+		if !imageExists(image) {
+			// the image was never built, or already removed
+			continue
+		}
+		cmd := commandRmi(image)
+		if err := runCommand(cmd); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("could not remove image %s: %w", image, err))
+			continue
+		}
+		result.Removed = append(result.Removed, image)
+	}
+
+	if p.sharedDaemon() {
+		// the daemon is shared with other builds, only remove our images
+		return result
+	}
+
+	if err := runCommand(commandPrune()); err != nil {
+		result.Errors = append(result.Errors, fmt.Errorf("could not prune system containers: %w", err))
+	} else {
+		result.Pruned = append(result.Pruned, "system")
+	}
+
+	if p.PruneCache {
+		if err := runCommand(commandBuilderPrune(p.PruneFilters)); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("could not prune build cache: %w", err))
+		} else {
+			result.Pruned = append(result.Pruned, "build-cache")
+		}
+	}
+
+	return result
+}
+
+// sharedDaemon reports whether the daemon is not started by the plugin, a
+// remote Docker host or one reached through a mounted socket, and so may be
+// used by other builds.
+func (p Plugin) sharedDaemon() bool {
+	return p.Daemon.Disabled || p.Daemon.usesRemoteDaemon()
+}
+
+// reportCleanup writes a summary of the cleanup phase to stdout.
+func reportCleanup(result cleanupResult) {
+	if err := result.Err(); err != nil {
+		fmt.Printf("Cleanup completed with errors (the build result is not affected):\n")
+		for _, e := range result.Errors {
+			fmt.Printf("  - %s\n", e)
+		}
+		return
+	}
+	fmt.Printf("Cleanup completed: removed %d image(s)", len(result.Removed))
+	if len(result.Pruned) > 0 {
+		fmt.Printf(", pruned %s", strings.Join(result.Pruned, ", "))
+	}
+	fmt.Println()
+}
+
+// helper function to create the docker builder prune command.
+func commandBuilderPrune(filters []string) *exec.Cmd {
+	args := []string{"builder", "prune", "-f"}
+	for _, filter := range filters {
+		args = append(args, "--filter", filter)
+	}
+	return exec.Command(dockerExe, args...)
+}
@@ -0,0 +1,107 @@
+package docker
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestCleanupImages(t *testing.T) {
+	tcs := []struct {
+		name   string
+		plugin Plugin
+		want   []string
+	}{
+		{
+			name: "temp tag and pushed tags",
+			plugin: Plugin{
+				Build: Build{TempTag: "abc123", Repo: "octocat/hello", Tags: []string{"latest", "1.0"}},
+			},
+			want: []string{"abc123", "octocat/hello:latest", "octocat/hello:1.0"},
+		},
+		{
+			name: "push only without source image keeps user images",
+			plugin: Plugin{
+				PushOnly: true,
+				Build:    Build{TempTag: "abc123", Repo: "octocat/hello", Tags: []string{"latest"}},
+			},
+			want: nil,
+		},
+		{
+			name: "push only skips the source image",
+			plugin: Plugin{
+				PushOnly:    true,
+				SourceImage: "octocat/hello",
+				Build:       Build{TempTag: "abc123", Repo: "octocat/hello", Tags: []string{"latest", "1.0"}},
+			},
+			want: []string{"octocat/hello:1.0"},
+		},
+		{
+			name: "test stage",
+			plugin: Plugin{
+				Test:  TestStage{Target: "test"},
+				Build: Build{TempTag: "abc123", Repo: "octocat/hello", Tags: []string{"latest"}},
+			},
+			want: []string{"abc123", "abc123-test", "octocat/hello:latest"},
+		},
+		{
+			name: "multiple images",
+			plugin: Plugin{
+				Build: Build{TempTag: "abc123", Repo: "octocat/hello", Tags: []string{"latest"}},
+				Images: []Image{
+					{Repo: "octocat/api", Tags: []string{"1.0"}},
+					{Repo: "octocat/web"},
+				},
+			},
+			want: []string{"abc123-0", "octocat/api:1.0", "abc123-1", "octocat/web:latest"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.plugin.cleanupImages()
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Got images %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCommandBuilderPrune(t *testing.T) {
+	got := commandBuilderPrune([]string{"until=24h", "type=regular"})
+	want := exec.Command(dockerExe, "builder", "prune", "-f", "--filter", "until=24h", "--filter", "type=regular")
+	if got.String() != want.String() {
+		t.Errorf("Got cmd %v, want %v", got, want)
+	}
+}
+
+func TestCleanupResultErr(t *testing.T) {
+	if err := (cleanupResult{}).Err(); err != nil {
+		t.Errorf("expected no error for empty result, got %s", err)
+	}
+	r := cleanupResult{Errors: []error{errors.New("a"), errors.New("b")}}
+	if err := r.Err(); err == nil || err.Error() != "a\nb" {
+		t.Errorf("unexpected combined error %v", err)
+	}
+}
+
+func TestCleanupSharedDaemon(t *testing.T) {
+	tcs := map[string]Daemon{
+		"remote":   {Host: "tcp://docker.build-farm.internal:2376"},
+		"disabled": {Disabled: true},
+	}
+	for name, daemon := range tcs {
+		t.Run(name, func(t *testing.T) {
+			p := Plugin{
+				Daemon:     daemon,
+				PruneCache: true,
+				Build:      Build{TempTag: "abc123", Repo: "octocat/hello", Tags: []string{"latest"}},
+			}
+			result := p.cleanup()
+			if len(result.Pruned) != 0 || result.Err() != nil {
+				t.Errorf("Expected the shared daemon not to be pruned, got %v, %v", result.Pruned, result.Err())
+			}
+		})
+	}
+}
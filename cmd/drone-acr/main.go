@@ -12,7 +12,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
@@ -20,6 +22,8 @@ import (
 
 	docker "github.com/drone-plugins/drone-docker"
 	azureutil "github.com/drone-plugins/drone-docker/internal/azure"
+	dockerconfig "github.com/drone-plugins/drone-docker/internal/docker"
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
 )
 
 type subscriptionUrlResponse struct {
@@ -52,28 +56,33 @@ func main() {
 		godotenv.Load(env)
 	}
 
+	// invoked by docker as docker-credential-drone-acr to mint fresh
+	// credentials. Nothing may be written to stdout before the helper replies.
+	if dockerconfig.IsCredentialHelper(os.Args) {
+		err := dockerconfig.RunCredentialHelper(os.Args, func(serverURL string) (string, string, error) {
+			registry := getenv("PLUGIN_REGISTRY")
+			if registry == "" {
+				registry = serverURL
+			}
+			username, password, _, err := getCredentials(registry, false)
+			return username, password, err
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Must run before Azure / ACR HTTPS auth: this binary does auth before
 	// spawning drone-docker, which is where TrustHarnessCA also runs for the daemon.
 	docker.TrustHarnessCA()
 
+	tracing := telemetry.StartWrapper("drone-acr", "ACR")
+
 	var (
 		repo     = getenv("PLUGIN_REPO")
 		registry = getenv("PLUGIN_REGISTRY")
-
-		// If these credentials are provided, they will be directly used
-		// for docker login
-		username = getenv("SERVICE_PRINCIPAL_CLIENT_ID")
-		password = getenv("SERVICE_PRINCIPAL_CLIENT_SECRET")
-
-		// Service principal credentials
-		clientId       = getenv("CLIENT_ID", "AZURE_CLIENT_ID", "AZURE_APP_ID", "PLUGIN_CLIENT_ID")
-		clientSecret   = getenv("CLIENT_SECRET", "PLUGIN_CLIENT_SECRET")
-		clientCert     = getenv("CLIENT_CERTIFICATE", "PLUGIN_CLIENT_CERTIFICATE")
-		tenantId       = getenv("TENANT_ID", "AZURE_TENANT_ID", "PLUGIN_TENANT_ID")
-		subscriptionId = getenv("SUBSCRIPTION_ID", "PLUGIN_SUBSCRIPTION_ID")
-		publicUrl      = getenv("DAEMON_REGISTRY", "PLUGIN_DAEMON_REGISTRY")
-		authorityHost  = getenv("AZURE_AUTHORITY_HOST", "PLUGIN_AZURE_AUTHORITY_HOST")
-		idToken        = getenv("PLUGIN_OIDC_TOKEN_ID")
 	)
 
 	// default registry value
@@ -81,38 +90,13 @@ func main() {
 		registry = "azurecr.io"
 	}
 
-	// Get auth if username and password is not specified
-	if username == "" && password == "" {
-		// docker login credentials are not provided
-		var err error
-	username = defaultUsername
-	if idToken != "" && clientId != "" && tenantId != "" {
-		slog.Debug("using OIDC authentication flow")
-		var aadToken string
-		aadToken, err = azureutil.GetAADAccessTokenViaClientAssertion(context.Background(), tenantId, clientId, idToken, authorityHost)
-		if err != nil {
-			slog.Error("failed to get AAD access token", "error", err)
-			os.Exit(1)
-		}
-			var p string
-			p, err = getPublicUrl(aadToken, registry, subscriptionId)
-			if err == nil {
-				publicUrl = p
-			} else {
-				fmt.Fprintf(os.Stderr, "failed to get public url with error: %s\n", err)
-			}
-		password, err = fetchACRToken(tenantId, aadToken, registry)
-		if err != nil {
-			slog.Error("failed to fetch ACR token", "error", err)
-			os.Exit(1)
-		}
-	} else {
-		password, publicUrl, err = getAuth(clientId, clientSecret, clientCert, tenantId, subscriptionId, registry)
-		if err != nil {
-			slog.Error("failed to get auth", "error", err)
-			os.Exit(1)
-		}
-	}
+	start := time.Now()
+	username, password, publicUrl, err := getCredentials(registry, true)
+	tracing.Credentials(registry, start, err)
+	if err != nil {
+		tracing.Finish(err)
+		slog.Error("failed to get credentials", "error", err)
+		os.Exit(1)
 	}
 
 	// must use the fully qualified repo name. If the
@@ -121,12 +105,19 @@ func main() {
 	if !strings.HasPrefix(repo, registry) {
 		repo = fmt.Sprintf("%s/%s", registry, repo)
 	}
+	tracing.SetAttributes(telemetry.AttrRepo.String(repo), telemetry.AttrRegistry.String(registry))
 
 	os.Setenv("PLUGIN_REPO", repo)
 	os.Setenv("PLUGIN_REGISTRY", registry)
 	os.Setenv("DOCKER_USERNAME", username)
 	os.Setenv("DOCKER_PASSWORD", password)
 	os.Setenv("PLUGIN_REGISTRY_TYPE", "ACR")
+
+	helper, _ := strconv.ParseBool(getenv("PLUGIN_CREDENTIAL_HELPER"))
+	if err := dockerconfig.SetupCredentialHelper("drone-acr", registry, helper); err != nil {
+		slog.Error("failed to install credential helper", "error", err)
+		os.Exit(1)
+	}
 	if publicUrl != "" {
 		// Set this env variable if public URL for artifact is available
 		// If not, we will fall back to registry url
@@ -137,14 +128,70 @@ func main() {
 	cmd := exec.Command(docker.GetDroneDockerExecCmd())
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+	tracing.Command(cmd)
+	err = cmd.Run()
+	tracing.Finish(err)
 	if err != nil {
 		slog.Error("command execution failed", "error", err)
 		os.Exit(1)
 	}
 }
 
-func getAuth(clientId, clientSecret, clientCert, tenantId, subscriptionId, registry string) (string, string, error) {
+// getCredentials returns the docker login credentials for the registry and,
+// when available and requested, the public URL of the registry in the Azure
+// portal.
+func getCredentials(registry string, lookupPublicUrl bool) (username, password, publicUrl string, err error) {
+	var (
+		// If these credentials are provided, they will be directly used
+		// for docker login
+		spUsername = getenv("SERVICE_PRINCIPAL_CLIENT_ID")
+		spPassword = getenv("SERVICE_PRINCIPAL_CLIENT_SECRET")
+
+		// Service principal credentials
+		clientId       = getenv("CLIENT_ID", "AZURE_CLIENT_ID", "AZURE_APP_ID", "PLUGIN_CLIENT_ID")
+		clientSecret   = getenv("CLIENT_SECRET", "PLUGIN_CLIENT_SECRET")
+		clientCert     = getenv("CLIENT_CERTIFICATE", "PLUGIN_CLIENT_CERTIFICATE")
+		tenantId       = getenv("TENANT_ID", "AZURE_TENANT_ID", "PLUGIN_TENANT_ID")
+		subscriptionId = getenv("SUBSCRIPTION_ID", "PLUGIN_SUBSCRIPTION_ID")
+		authorityHost  = getenv("AZURE_AUTHORITY_HOST", "PLUGIN_AZURE_AUTHORITY_HOST")
+		idToken        = getenv("PLUGIN_OIDC_TOKEN_ID")
+	)
+	publicUrl = getenv("DAEMON_REGISTRY", "PLUGIN_DAEMON_REGISTRY")
+
+	if spUsername != "" || spPassword != "" {
+		// docker login credentials are provided
+		return spUsername, spPassword, publicUrl, nil
+	}
+
+	username = defaultUsername
+	if idToken != "" && clientId != "" && tenantId != "" {
+		slog.Debug("using OIDC authentication flow")
+		aadToken, err := azureutil.GetAADAccessTokenViaClientAssertion(context.Background(), tenantId, clientId, idToken, authorityHost)
+		if err != nil {
+			return "", "", "", fmt.Errorf("failed to get AAD access token: %w", err)
+		}
+		if lookupPublicUrl {
+			if p, err := getPublicUrl(aadToken, registry, subscriptionId); err == nil {
+				publicUrl = p
+			} else {
+				fmt.Fprintf(os.Stderr, "failed to get public url with error: %s\n", err)
+			}
+		}
+		password, err = fetchACRToken(tenantId, aadToken, registry)
+		if err != nil {
+			return "", "", "", fmt.Errorf("failed to fetch ACR token: %w", err)
+		}
+		return username, password, publicUrl, nil
+	}
+
+	password, publicUrl, err = getAuth(clientId, clientSecret, clientCert, tenantId, subscriptionId, registry, lookupPublicUrl)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to get auth: %w", err)
+	}
+	return username, password, publicUrl, nil
+}
+
+func getAuth(clientId, clientSecret, clientCert, tenantId, subscriptionId, registry string, lookupPublicUrl bool) (string, string, error) {
 	// Verify inputs
 	if tenantId == "" {
 		return "", "", fmt.Errorf("tenantId cannot be empty for AAD authentication")
@@ -196,10 +243,13 @@ func getAuth(clientId, clientSecret, clientCert, tenantId, subscriptionId, regis
 	}
 
 	// Get public URL for artifacts
-	publicUrl, err := getPublicUrl(aadToken.Token, registry, subscriptionId)
-	if err != nil {
-		// execution should not fail because of this error
-		fmt.Fprintf(os.Stderr, "failed to get public url with error: %s\n", err)
+	var publicUrl string
+	if lookupPublicUrl {
+		publicUrl, err = getPublicUrl(aadToken.Token, registry, subscriptionId)
+		if err != nil {
+			// execution should not fail because of this error
+			fmt.Fprintf(os.Stderr, "failed to get public url with error: %s\n", err)
+		}
 	}
 
 	// Fetch token
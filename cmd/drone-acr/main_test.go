@@ -7,15 +7,15 @@ import (
 
 func TestGetAuthInputValidation(t *testing.T) {
     // missing tenant
-    if _, _, err := getAuth("client", "secret", "", "", "sub", "registry.azurecr.io"); err == nil {
+    if _, _, err := getAuth("client", "secret", "", "", "sub", "registry.azurecr.io", true); err == nil {
         t.Fatalf("expected error for missing tenantId")
     }
     // missing clientId
-    if _, _, err := getAuth("", "secret", "", "tenant", "sub", "registry.azurecr.io"); err == nil {
+    if _, _, err := getAuth("", "secret", "", "tenant", "sub", "registry.azurecr.io", true); err == nil {
         t.Fatalf("expected error for missing clientId")
     }
     // missing both secret and cert
-    if _, _, err := getAuth("client", "", "", "tenant", "sub", "registry.azurecr.io"); err == nil {
+    if _, _, err := getAuth("client", "", "", "tenant", "sub", "registry.azurecr.io", true); err == nil {
         t.Fatalf("expected error for missing credentials")
     }
 }
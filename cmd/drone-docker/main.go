@@ -5,12 +5,14 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/dchest/uniuri"
 	"github.com/joho/godotenv"
 	"github.com/urfave/cli"
 
 	docker "github.com/drone-plugins/drone-docker"
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
 	"github.com/drone-plugins/drone-plugin-lib/drone"
 )
 
@@ -24,10 +26,21 @@ func main() {
 		godotenv.Load(env)
 	}
 
+	// Apply the declarative configuration file, settings given through the
+	// environment take precedence over it.
+	if err := docker.ApplyConfigFile(os.Getenv(docker.ConfigFileEnv)); err != nil {
+		slog.Error("failed to load configuration file", "error", err)
+		os.Exit(1)
+	}
+
 	// Install egress CA before any TLS in this process (including the Docker
 	// daemon started from Plugin.Exec).
 	docker.TrustHarnessCA()
 
+	// export the spans of the step to the OTLP endpoint configured through
+	// the OTEL_* environment variables
+	shutdown := telemetry.Setup("drone-docker")
+
 	app := cli.NewApp()
 	app.Name = "docker plugin"
 	app.Usage = "docker plugin"
@@ -55,11 +68,31 @@ func main() {
 			Usage:  "git commit ref",
 			EnvVar: "DRONE_COMMIT_REF",
 		},
+		cli.StringFlag{
+			Name:   "build.event",
+			Usage:  "build event",
+			EnvVar: "DRONE_BUILD_EVENT",
+		},
+		cli.StringFlag{
+			Name:   "commit.target-branch",
+			Usage:  "branch a pull request targets",
+			EnvVar: "DRONE_TARGET_BRANCH",
+		},
 		cli.StringFlag{
 			Name:   "daemon.mirror",
 			Usage:  "docker daemon registry mirror",
 			EnvVar: "PLUGIN_MIRROR,DOCKER_PLUGIN_MIRROR",
 		},
+		cli.StringFlag{
+			Name:   "daemon.pull-through-cache",
+			Usage:  "registry mirror or pull-through cache used to retry pulls rate limited by docker hub",
+			EnvVar: "PLUGIN_PULL_THROUGH_CACHE",
+		},
+		cli.BoolTFlag{
+			Name:   "dockerhub.rate-limit-check",
+			Usage:  "report the remaining docker hub pull quota before the build",
+			EnvVar: "PLUGIN_DOCKERHUB_RATE_LIMIT_CHECK",
+		},
 		cli.StringFlag{
 			Name:   "daemon.storage-driver",
 			Usage:  "docker daemon storage driver",
@@ -122,6 +155,31 @@ func main() {
 			Value:  15,
 			EnvVar: "PLUGIN_DAEMON_RETRY_COUNT",
 		},
+		cli.StringFlag{
+			Name:   "daemon.host",
+			Usage:  "remote docker host (e.g. tcp://docker.example.com:2376), disables the local daemon",
+			EnvVar: "PLUGIN_DAEMON_HOST,PLUGIN_DOCKER_HOST",
+		},
+		cli.StringFlag{
+			Name:   "daemon.buildkit-host",
+			Usage:  "remote buildkitd address (e.g. tcp://buildkitd.example.com:1234)",
+			EnvVar: "PLUGIN_BUILDKIT_HOST",
+		},
+		cli.StringFlag{
+			Name:   "daemon.tls-cacert",
+			Usage:  "CA certificate content for the remote docker or buildkit host",
+			EnvVar: "PLUGIN_TLS_CACERT",
+		},
+		cli.StringFlag{
+			Name:   "daemon.tls-cert",
+			Usage:  "client certificate content for the remote docker or buildkit host",
+			EnvVar: "PLUGIN_TLS_CERT",
+		},
+		cli.StringFlag{
+			Name:   "daemon.tls-key",
+			Usage:  "client key content for the remote docker or buildkit host",
+			EnvVar: "PLUGIN_TLS_KEY",
+		},
 		cli.StringFlag{
 			Name:   "dockerfile",
 			Usage:  "build dockerfile",
@@ -130,7 +188,7 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:   "context",
-			Usage:  "build context",
+			Usage:  "build context: a directory, a git URL (url#ref:subdir), a tarball URL or a tarball",
 			Value:  ".",
 			EnvVar: "PLUGIN_CONTEXT",
 		},
@@ -258,6 +316,11 @@ func main() {
 			Usage:  "Docker registry for base image registry",
 			EnvVar: "PLUGIN_DOCKER_REGISTRY,PLUGIN_BASE_IMAGE_REGISTRY,DOCKER_BASE_IMAGE_REGISTRY",
 		},
+		cli.StringFlag{
+			Name:   "docker.baseimageconnectors",
+			Usage:  "JSON list of base image registry connectors, each with registry, username and password or a docker config",
+			EnvVar: "PLUGIN_BASE_IMAGE_CONNECTORS",
+		},
 		cli.StringFlag{
 			Name:   "docker.email",
 			Usage:  "docker email",
@@ -268,11 +331,31 @@ func main() {
 			Usage:  "docker json dockerconfig content",
 			EnvVar: "PLUGIN_CONFIG,DOCKER_PLUGIN_CONFIG",
 		},
+		cli.StringSliceFlag{
+			Name:   "docker.cred-helpers",
+			Usage:  "docker credential helpers as registry=helper pairs",
+			EnvVar: "PLUGIN_CRED_HELPERS",
+		},
+		cli.StringFlag{
+			Name:   "docker.creds-store",
+			Usage:  "docker default credential store",
+			EnvVar: "PLUGIN_CREDS_STORE",
+		},
 		cli.BoolTFlag{
 			Name:   "docker.purge",
 			Usage:  "docker should cleanup images",
 			EnvVar: "PLUGIN_PURGE",
 		},
+		cli.BoolFlag{
+			Name:   "docker.prune-cache",
+			Usage:  "docker should prune the build cache during cleanup",
+			EnvVar: "PLUGIN_PRUNE_CACHE",
+		},
+		cli.StringSliceFlag{
+			Name:   "docker.prune-filter",
+			Usage:  "filters applied when pruning the build cache (e.g. until=24h)",
+			EnvVar: "PLUGIN_PRUNE_FILTER,PLUGIN_PRUNE_FILTERS",
+		},
 		cli.StringFlag{
 			Name:   "repo.branch",
 			Usage:  "repository default branch",
@@ -283,6 +366,31 @@ func main() {
 			Usage:  "do not use cached intermediate containers",
 			EnvVar: "PLUGIN_NO_CACHE",
 		},
+		cli.StringFlag{
+			Name:   "context-checksum",
+			Usage:  "expected sha256 of a tarball build context, such as sha256:<hex digest>",
+			EnvVar: "PLUGIN_CONTEXT_CHECKSUM",
+		},
+		cli.StringFlag{
+			Name:   "context-git-token",
+			Usage:  "token fetching a git build context",
+			EnvVar: "PLUGIN_CONTEXT_GIT_TOKEN",
+		},
+		cli.BoolFlag{
+			Name:   "context-analysis",
+			Usage:  "report the size of the build context, its largest files and common mistakes before the build",
+			EnvVar: "PLUGIN_CONTEXT_ANALYSIS",
+		},
+		cli.StringFlag{
+			Name:   "context-size-limit",
+			Usage:  "maximum build context size, such as 500MB, the build fails above it",
+			EnvVar: "PLUGIN_CONTEXT_SIZE_LIMIT",
+		},
+		cli.BoolFlag{
+			Name:   "content-hash",
+			Usage:  "skip the build and push the image built from the same content when the registry has it",
+			EnvVar: "PLUGIN_CONTENT_HASH",
+		},
 		cli.StringSliceFlag{
 			Name:   "add-host",
 			Usage:  "additional host:IP mapping",
@@ -323,6 +431,115 @@ func main() {
 			Usage:  "Artifact file location that will be generated by the plugin. This file will include information of docker images that are uploaded by the plugin.",
 			EnvVar: "PLUGIN_ARTIFACT_FILE",
 		},
+		cli.StringFlag{
+			Name:   "images",
+			Usage:  "JSON list of images built by the step, each with its own name, dockerfile, context, target, args, repo and tags",
+			EnvVar: "PLUGIN_IMAGES",
+		},
+		cli.IntFlag{
+			Name:   "concurrency",
+			Usage:  "number of images built at once",
+			Value:  1,
+			EnvVar: "PLUGIN_CONCURRENCY",
+		},
+		cli.StringSliceFlag{
+			Name:   "bake-files",
+			Usage:  "docker buildx bake definition files, builds with bake when set",
+			EnvVar: "PLUGIN_BAKE_FILES,PLUGIN_BAKE_FILE",
+		},
+		cli.StringSliceFlag{
+			Name:   "bake-targets",
+			Usage:  "docker buildx bake targets or groups to build, builds with bake when set",
+			EnvVar: "PLUGIN_BAKE_TARGETS",
+		},
+		cli.StringFlag{
+			Name:   "test-target",
+			Usage:  "build stage running the tests before the image is built",
+			EnvVar: "PLUGIN_TEST_TARGET",
+		},
+		cli.StringSliceFlag{
+			Name:   "test-reports",
+			Usage:  "JUnit reports of the test stage, glob patterns such as /reports/*.xml",
+			EnvVar: "PLUGIN_TEST_REPORTS",
+		},
+		cli.StringFlag{
+			Name:   "test-reports-dest",
+			Usage:  "workspace directory the test reports are copied to",
+			Value:  "test-reports",
+			EnvVar: "PLUGIN_TEST_REPORTS_DEST",
+		},
+		cli.BoolFlag{
+			Name:   "skip-unchanged",
+			Usage:  "skip the build when the commit changes none of the watched paths",
+			EnvVar: "PLUGIN_SKIP_UNCHANGED",
+		},
+		cli.StringSliceFlag{
+			Name:   "paths",
+			Usage:  "paths whose changes trigger the build, the context and Dockerfile by default",
+			EnvVar: "PLUGIN_PATHS",
+		},
+		cli.StringSliceFlag{
+			Name:   "paths-ignore",
+			Usage:  "paths whose changes do not trigger the build",
+			EnvVar: "PLUGIN_PATHS_IGNORE",
+		},
+		cli.StringFlag{
+			Name:   "smoke-tests",
+			Usage:  "JSON smoke tests run against the built image before the push: commands, files and healthcheck",
+			EnvVar: "PLUGIN_SMOKE_TESTS",
+		},
+		cli.StringFlag{
+			Name:   "output-dest",
+			Usage:  "directory (local) or archive (tar) the files of the output stage are exported to",
+			EnvVar: "PLUGIN_OUTPUT_DEST",
+		},
+		cli.StringFlag{
+			Name:   "output-type",
+			Usage:  "build output exporter, local or tar",
+			Value:  "local",
+			EnvVar: "PLUGIN_OUTPUT_TYPE",
+		},
+		cli.StringFlag{
+			Name:   "output-target",
+			Usage:  "build stage whose files are exported, the build target by default",
+			EnvVar: "PLUGIN_OUTPUT_TARGET",
+		},
+		cli.BoolFlag{
+			Name:   "output-only",
+			Usage:  "export the build outputs without building and pushing the image",
+			EnvVar: "PLUGIN_OUTPUT_ONLY",
+		},
+		cli.StringFlag{
+			Name:   "compose-file",
+			Usage:  "compose file whose services with a build section are built",
+			EnvVar: "PLUGIN_COMPOSE_FILE",
+		},
+		cli.StringFlag{
+			Name:   "compose-repo",
+			Usage:  "template naming the repository of each compose service, e.g. {{ .Repo }}/{{ .Service }}",
+			EnvVar: "PLUGIN_COMPOSE_REPO",
+		},
+		cli.GenericFlag{
+			Name:   "bake-set",
+			Usage:  "docker buildx bake target overrides (target.key=value), separated by semicolons",
+			EnvVar: "PLUGIN_BAKE_SET",
+			Value:  new(CustomStringSliceFlag),
+		},
+		cli.StringFlag{
+			Name:   "output-file",
+			Usage:  "dotenv file the step outputs (digest, image references and tags) are written to",
+			EnvVar: "DRONE_OUTPUT",
+		},
+		cli.StringFlag{
+			Name:   "secret-output-file",
+			Usage:  "dotenv file the secret step outputs are written to",
+			EnvVar: "HARNESS_OUTPUT_SECRET_FILE",
+		},
+		cli.BoolFlag{
+			Name:   "secret-outputs",
+			Usage:  "write the step outputs to the secret output file so they are masked",
+			EnvVar: "PLUGIN_SECRET_OUTPUTS",
+		},
 		cli.StringFlag{
 			Name:   "registry-type",
 			Usage:  "registry type",
@@ -359,9 +576,44 @@ func main() {
 			Usage:  "source image to tag and push (format: repo:tag)",
 			EnvVar: "PLUGIN_SOURCE_IMAGE",
 		},
+		cli.BoolFlag{
+			Name:   "plan",
+			Usage:  "report the execution plan without building or pushing",
+			EnvVar: "PLUGIN_PLAN",
+		},
+		cli.StringFlag{
+			Name:   "plan-file",
+			Usage:  "path the execution plan is written to as json",
+			Value:  "docker-plan.json",
+			EnvVar: "PLUGIN_PLAN_FILE",
+		},
+		cli.StringFlag{
+			Name:   "report-file",
+			Usage:  "path the json run report is written to",
+			EnvVar: "PLUGIN_REPORT_FILE",
+		},
+		cli.IntFlag{
+			Name:   "push.retries",
+			Usage:  "number of times a failed push is retried",
+			Value:  3,
+			EnvVar: "PLUGIN_PUSH_RETRIES",
+		},
+		cli.DurationFlag{
+			Name:   "push.backoff",
+			Usage:  "initial delay between push retries, doubled on every attempt",
+			Value:  2 * time.Second,
+			EnvVar: "PLUGIN_PUSH_BACKOFF",
+		},
+		cli.StringFlag{
+			Name:   "docker.credential-provider",
+			Usage:  "credential helper used to refresh expired registry credentials",
+			EnvVar: "PLUGIN_CREDENTIAL_PROVIDER",
+		},
 	}
 
-	if err := app.Run(os.Args); err != nil {
+	err := app.Run(os.Args)
+	shutdown()
+	if err != nil {
 		slog.Error("application error", "error", err)
 		os.Exit(1)
 	}
@@ -373,19 +625,80 @@ func run(c *cli.Context) error {
 		registryType = drone.RegistryType(c.String("registry-type"))
 	}
 
+	baseImageConnectors, err := docker.ParseBaseImageConnectors(c.String("docker.baseimageconnectors"))
+	if err != nil {
+		return err
+	}
+
+	images, err := docker.ParseImages(c.String("images"))
+	if err != nil {
+		return err
+	}
+
+	smokeTests, err := docker.ParseSmokeTests(c.String("smoke-tests"))
+	if err != nil {
+		return err
+	}
+
 	plugin := docker.Plugin{
-		Dryrun:  c.Bool("dry-run"),
-		Cleanup: c.BoolT("docker.purge"),
+		Dryrun:       c.Bool("dry-run"),
+		Plan:         c.Bool("plan"),
+		PlanFile:     c.String("plan-file"),
+		ReportFile:   c.String("report-file"),
+		Cleanup:      c.BoolT("docker.purge"),
+		PruneCache:   c.Bool("docker.prune-cache"),
+		PruneFilters: c.StringSlice("docker.prune-filter"),
 		Login: docker.Login{
-			Registry:    c.String("docker.registry"),
-			Username:    c.String("docker.username"),
-			Password:    c.String("docker.password"),
-			Email:       c.String("docker.email"),
-			Config:      c.String("docker.config"),
-			AccessToken: c.String("access-token"),
-		},
-		CardPath:     c.String("drone-card-path"),
-		ArtifactFile: c.String("artifact-file"),
+			Registry:           c.String("docker.registry"),
+			Username:           c.String("docker.username"),
+			Password:           c.String("docker.password"),
+			Email:              c.String("docker.email"),
+			Config:             c.String("docker.config"),
+			AccessToken:        c.String("access-token"),
+			CredHelpers:        c.StringSlice("docker.cred-helpers"),
+			CredsStore:         c.String("docker.creds-store"),
+			CredentialProvider: c.String("docker.credential-provider"),
+		},
+		CardPath:         c.String("drone-card-path"),
+		ArtifactFile:     c.String("artifact-file"),
+		OutputFile:       c.String("output-file"),
+		SecretOutputFile: c.String("secret-output-file"),
+		SecretOutputs:    c.Bool("secret-outputs"),
+		Images:           images,
+		Concurrency:      c.Int("concurrency"),
+		Bake: docker.Bake{
+			Files:   c.StringSlice("bake-files"),
+			Targets: c.StringSlice("bake-targets"),
+			Set:     c.Generic("bake-set").(*CustomStringSliceFlag).GetValue(),
+		},
+		Test: docker.TestStage{
+			Target:  c.String("test-target"),
+			Reports: c.StringSlice("test-reports"),
+			Dest:    c.String("test-reports-dest"),
+		},
+		Export: docker.BuildOutput{
+			Type:   c.String("output-type"),
+			Dest:   c.String("output-dest"),
+			Target: c.String("output-target"),
+			Only:   c.Bool("output-only"),
+		},
+		Smoke: smokeTests,
+		ContextCheck: docker.ContextCheck{
+			Enabled:   c.Bool("context-analysis"),
+			SizeLimit: c.String("context-size-limit"),
+		},
+		Changes: docker.ChangeFilter{
+			SkipUnchanged: c.Bool("skip-unchanged"),
+			Paths:         c.StringSlice("paths"),
+			PathsIgnore:   c.StringSlice("paths-ignore"),
+			Commit:        c.String("commit.sha"),
+			Event:         c.String("build.event"),
+			TargetBranch:  c.String("commit.target-branch"),
+		},
+		Compose: docker.Compose{
+			File: c.String("compose-file"),
+			Repo: c.String("compose-repo"),
+		},
 		Build: docker.Build{
 			Remote:              c.String("remote.url"),
 			Name:                c.String("commit.sha"),
@@ -408,6 +721,9 @@ func run(c *cli.Context) error {
 			AutoLabel:           c.BoolT("auto-label"),
 			Link:                c.String("link"),
 			NoCache:             c.Bool("no-cache"),
+			ContentHash:         c.Bool("content-hash"),
+			ContextChecksum:     c.String("context-checksum"),
+			ContextGitToken:     c.String("context-git-token"),
 			Secret:              c.String("secret"),
 			SecretEnvs:          c.StringSlice("secrets-from-env"),
 			SecretFiles:         c.StringSlice("secrets-from-file"),
@@ -417,32 +733,42 @@ func run(c *cli.Context) error {
 			SSHAgentKey:         c.String("ssh-agent-key"),
 		},
 		Daemon: docker.Daemon{
-			Registry:      c.String("docker.registry"),
-			Mirror:        c.String("daemon.mirror"),
-			StorageDriver: c.String("daemon.storage-driver"),
-			StoragePath:   c.String("daemon.storage-path"),
-			Insecure:      c.Bool("daemon.insecure"),
-			Disabled:      c.Bool("daemon.off"),
-			IPv6:          c.Bool("daemon.ipv6"),
-			Debug:         c.Bool("daemon.debug"),
-			Bip:           c.String("daemon.bip"),
-			DNS:           c.StringSlice("daemon.dns"),
-			DNSSearch:     c.StringSlice("daemon.dns-search"),
-			MTU:           c.String("daemon.mtu"),
-			Experimental:  c.Bool("daemon.experimental"),
-			RetryCount:    c.Int("daemon.retry-count"),
-			RegistryType:  registryType,
-		},
-		BaseImageRegistry: c.String("docker.baseimageregistry"),
-		BaseImageUsername: c.String("docker.baseimageusername"),
-		BaseImagePassword: c.String("docker.baseimagepassword"),
+			Registry:         c.String("docker.registry"),
+			Mirror:           c.String("daemon.mirror"),
+			StorageDriver:    c.String("daemon.storage-driver"),
+			StoragePath:      c.String("daemon.storage-path"),
+			Insecure:         c.Bool("daemon.insecure"),
+			Disabled:         c.Bool("daemon.off"),
+			IPv6:             c.Bool("daemon.ipv6"),
+			Debug:            c.Bool("daemon.debug"),
+			Bip:              c.String("daemon.bip"),
+			DNS:              c.StringSlice("daemon.dns"),
+			DNSSearch:        c.StringSlice("daemon.dns-search"),
+			MTU:              c.String("daemon.mtu"),
+			Experimental:     c.Bool("daemon.experimental"),
+			RetryCount:       c.Int("daemon.retry-count"),
+			RegistryType:     registryType,
+			Host:             c.String("daemon.host"),
+			BuildkitHost:     c.String("daemon.buildkit-host"),
+			TLSCACert:        c.String("daemon.tls-cacert"),
+			TLSCert:          c.String("daemon.tls-cert"),
+			TLSKey:           c.String("daemon.tls-key"),
+			PullThroughCache: c.String("daemon.pull-through-cache"),
+		},
+		BaseImageRegistry:   c.String("docker.baseimageregistry"),
+		BaseImageUsername:   c.String("docker.baseimageusername"),
+		BaseImagePassword:   c.String("docker.baseimagepassword"),
+		BaseImageConnectors: baseImageConnectors,
 		Cosign: docker.CosignConfig{
 			PrivateKey: c.String("cosign.private-key"),
 			Password:   c.String("cosign.password"),
 			Params:     c.String("cosign.params"),
 		},
-		PushOnly:    c.Bool("push-only"),
-		SourceImage: c.String("source-image"),
+		RateLimitCheck: c.BoolT("dockerhub.rate-limit-check"),
+		PushRetries:    c.Int("push.retries"),
+		PushBackoff:    c.Duration("push.backoff"),
+		PushOnly:       c.Bool("push-only"),
+		SourceImage:    c.String("source-image"),
 	}
 
 	if c.Bool("tags.auto") {
@@ -11,6 +11,7 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -21,6 +22,8 @@ import (
 	"github.com/joho/godotenv"
 
 	docker "github.com/drone-plugins/drone-docker"
+	dockerconfig "github.com/drone-plugins/drone-docker/internal/docker"
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
 )
 
 const defaultRegion = "us-east-1"
@@ -30,10 +33,21 @@ func main() {
 		godotenv.Load(env)
 	}
 
+	// invoked by docker as docker-credential-drone-ecr to mint fresh credentials
+	if dockerconfig.IsCredentialHelper(os.Args) {
+		if err := dockerconfig.RunCredentialHelper(os.Args, getCredentials); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Must run before AWS / ECR HTTPS auth: this binary does auth before
 	// spawning drone-docker, which is where TrustHarnessCA also runs for the daemon.
 	docker.TrustHarnessCA()
 
+	tracing := telemetry.StartWrapper("drone-ecr", "ECR")
+
 	var (
 		repo                = getenv("PLUGIN_REPO")
 		registry            = getenv("PLUGIN_REGISTRY")
@@ -48,6 +62,7 @@ func main() {
 		scanOnPush          = parseBoolOrDefault(false, getenv("PLUGIN_SCAN_ON_PUSH"))
 		idToken             = os.Getenv("PLUGIN_OIDC_TOKEN_ID")
 		skipPushIfTagExists = parseBoolOrDefault(false, getenv("PLUGIN_SKIP_PUSH_IF_TAG_EXISTS"))
+		credentialHelper    = parseBoolOrDefault(false, getenv("PLUGIN_CREDENTIAL_HELPER"))
 	)
 
 	if region == "" {
@@ -69,19 +84,23 @@ func main() {
 	}
 
 	svc := getECRClient(cfg, assumeRole, externalId, idToken)
+	start := time.Now()
 	username, password, defaultRegistry, err := getAuthInfo(ctx, svc)
 
 	if registry == "" {
 		registry = defaultRegistry
 	}
 
+	tracing.Credentials(registry, start, err)
 	if err != nil {
+		tracing.Finish(err)
 		log.Fatal(fmt.Sprintf("error getting ECR auth: %v", err))
 	}
 
 	if !strings.HasPrefix(repo, registry) {
 		repo = fmt.Sprintf("%s/%s", registry, repo)
 	}
+	tracing.SetAttributes(telemetry.AttrRepo.String(repo), telemetry.AttrRegistry.String(registry))
 
 	if create {
 		err = ensureRepoExists(ctx, svc, trimHostname(repo, registry), scanOnPush)
@@ -120,6 +139,10 @@ func main() {
 	os.Setenv("DOCKER_PASSWORD", password)
 	os.Setenv("PLUGIN_REGISTRY_TYPE", "ECR")
 
+	if err := dockerconfig.SetupCredentialHelper("drone-ecr", registry, credentialHelper); err != nil {
+		log.Fatal(fmt.Sprintf("error installing credential helper: %v", err))
+	}
+
 	if skipPushIfTagExists {
 		tagInput := getenv("PLUGIN_TAG", "PLUGIN_TAGS")
 		var tags []string
@@ -143,6 +166,7 @@ func main() {
 		}
 		if exists {
 			slog.Info("image tag exists, skipping push", "repo", repo, "tag", t)
+			tracing.Finish(nil)
 			os.Exit(0)
 		}
 	}
@@ -151,7 +175,10 @@ func main() {
 	cmd := exec.Command(docker.GetDroneDockerExecCmd())
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if err = cmd.Run(); err != nil {
+	tracing.Command(cmd)
+	err = cmd.Run()
+	tracing.Finish(err)
+	if err != nil {
 		slog.Error("command execution failed", "error", err)
 		os.Exit(1)
 	}
@@ -238,6 +265,24 @@ func getAuthInfo(ctx context.Context, svc *ecr.Client) (username, password, regi
 	return
 }
 
+// getCredentials mints fresh ECR credentials from the environment prepared by
+// main. It backs the credential helper mode.
+func getCredentials(serverURL string) (string, string, error) {
+	ctx := context.Background()
+
+	region := getenv("PLUGIN_REGION", "ECR_REGION", "AWS_REGION")
+	if region == "" {
+		region = defaultRegion
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return "", "", fmt.Errorf("error creating aws config: %w", err)
+	}
+	svc := getECRClient(cfg, getenv("PLUGIN_ASSUME_ROLE"), getenv("PLUGIN_EXTERNAL_ID"), os.Getenv("PLUGIN_OIDC_TOKEN_ID"))
+	username, password, _, err := getAuthInfo(ctx, svc)
+	return username, password, err
+}
+
 func parseBoolOrDefault(defaultValue bool, s string) (result bool) {
 	var err error
 	result, err = strconv.ParseBool(s)
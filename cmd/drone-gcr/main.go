@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"log"
 	"log/slog"
 	"os"
@@ -10,9 +11,12 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	docker "github.com/drone-plugins/drone-docker"
+	dockerconfig "github.com/drone-plugins/drone-docker/internal/docker"
 	"github.com/drone-plugins/drone-docker/internal/gcp"
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
 
 	"github.com/joho/godotenv"
 	"golang.org/x/oauth2/google"
@@ -28,8 +32,6 @@ type Config struct {
 }
 
 func loadConfig() Config {
-	// Default username
-	username := "_json_key"
 	var config Config
 
 	// Load env-file if it exists
@@ -43,6 +45,28 @@ func loadConfig() Config {
 	// drone-docker, which is where TrustHarnessCA also runs for the daemon.
 	docker.TrustHarnessCA()
 
+	repo := getenv("PLUGIN_REPO")
+	registryType := getenv("PLUGIN_REGISTRY_TYPE")
+	if registryType == "" {
+		registryType = "GCR"
+	}
+
+	registry := getenv("PLUGIN_REGISTRY")
+	if registry == "" {
+		registry = "gcr.io"
+	}
+
+	if !strings.HasPrefix(repo, registry) {
+		repo = path.Join(registry, repo)
+	}
+	config.Repo = repo
+	config.Registry = registry
+	return config
+}
+
+// loadCredentials resolves the registry credentials from the environment,
+// minting a fresh access token when workload identity federation is used.
+func loadCredentials(config *Config, username string) error {
 	idToken := getenv("PLUGIN_OIDC_TOKEN_ID")
 	projectId := getenv("PLUGIN_PROJECT_NUMBER")
 	poolId := getenv("PLUGIN_POOL_ID")
@@ -52,13 +76,11 @@ func loadConfig() Config {
 	if idToken != "" && projectId != "" && poolId != "" && providerId != "" && serviceAccountEmail != "" {
 		federalToken, err := gcp.GetFederalToken(idToken, projectId, poolId, providerId)
 		if err != nil {
-			slog.Error("getFederalToken error", "error", err)
-			os.Exit(1)
+			return fmt.Errorf("getFederalToken error: %w", err)
 		}
 		accessToken, err := gcp.GetGoogleCloudAccessToken(federalToken, serviceAccountEmail)
 		if err != nil {
-			slog.Error("getGoogleCloudAccessToken error", "error", err)
-			os.Exit(1)
+			return fmt.Errorf("getGoogleCloudAccessToken error: %w", err)
 		}
 		config.AccessToken = accessToken
 	} else {
@@ -71,28 +93,46 @@ func loadConfig() Config {
 		config.WorkloadIdentity = parseBoolOrDefault(false, getenv("PLUGIN_WORKLOAD_IDENTITY"))
 		config.Username, config.Password = setUsernameAndPassword(username, password, config.WorkloadIdentity)
 	}
+	return nil
+}
 
-	repo := getenv("PLUGIN_REPO")
-	registryType := getenv("PLUGIN_REGISTRY_TYPE")
-	if registryType == "" {
-		registryType = "GCR"
-	}
-
-	registry := getenv("PLUGIN_REGISTRY")
-	if registry == "" {
-		registry = "gcr.io"
+// getCredentials mints fresh registry credentials. It backs the credential
+// helper mode.
+func getCredentials(serverURL string) (string, string, error) {
+	var config Config
+	if err := loadCredentials(&config, "_json_key"); err != nil {
+		return "", "", err
 	}
-
-	if !strings.HasPrefix(repo, registry) {
-		repo = path.Join(registry, repo)
+	if config.AccessToken != "" {
+		return "oauth2accesstoken", config.AccessToken, nil
 	}
-	config.Repo = repo
-	config.Registry = registry
-	return config
+	return config.Username, config.Password, nil
 }
 
 func main() {
+	// invoked by docker as docker-credential-drone-gcr to mint fresh credentials
+	if dockerconfig.IsCredentialHelper(os.Args) {
+		if err := dockerconfig.RunCredentialHelper(os.Args, getCredentials); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	config := loadConfig()
+
+	tracing := telemetry.StartWrapper("drone-gcr", "GCR")
+	tracing.SetAttributes(telemetry.AttrRepo.String(config.Repo), telemetry.AttrRegistry.String(config.Registry))
+
+	start := time.Now()
+	err := loadCredentials(&config, "_json_key")
+	tracing.Credentials(config.Registry, start, err)
+	if err != nil {
+		tracing.Finish(err)
+		slog.Error("failed to load credentials", "error", err)
+		os.Exit(1)
+	}
+
 	if config.AccessToken != "" {
 		os.Setenv("ACCESS_TOKEN", config.AccessToken)
 	} else if config.Username != "" && config.Password != "" {
@@ -103,11 +143,19 @@ func main() {
 	os.Setenv("PLUGIN_REPO", config.Repo)
 	os.Setenv("PLUGIN_REGISTRY", config.Registry)
 
+	helper := parseBoolOrDefault(false, getenv("PLUGIN_CREDENTIAL_HELPER"))
+	if err := dockerconfig.SetupCredentialHelper("drone-gcr", config.Registry, helper); err != nil {
+		slog.Error("failed to install credential helper", "error", err)
+		os.Exit(1)
+	}
+
 	// invoke the base docker plugin binary
 	cmd := exec.Command(docker.GetDroneDockerExecCmd())
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+	tracing.Command(cmd)
+	err = cmd.Run()
+	tracing.Finish(err)
 	if err != nil {
 		slog.Error("command execution failed", "error", err)
 		os.Exit(1)
@@ -6,6 +6,8 @@ import (
 	"path"
 
 	"github.com/joho/godotenv"
+
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
 )
 
 func main() {
@@ -33,10 +35,15 @@ func main() {
 	os.Setenv("DOCKER_USERNAME", email)
 	os.Setenv("DOCKER_EMAIL", email)
 
+	tracing := telemetry.StartWrapper("drone-heroku", "Heroku")
+	tracing.SetAttributes(telemetry.AttrRepo.String(path.Join(registry, app, process)), telemetry.AttrRegistry.String(registry))
+
 	cmd := exec.Command("drone-docker")
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	tracing.Command(cmd)
 	err := cmd.Run()
+	tracing.Finish(err)
 	if err != nil {
 		os.Exit(1)
 	}
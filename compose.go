@@ -0,0 +1,196 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultComposeRepo names the repository of a compose service: the
+// repository of its image, or the service name under the step repo.
+const defaultComposeRepo = "{{ if .Image }}{{ .Image }}{{ else if .Repo }}{{ .Repo }}/{{ .Service }}{{ end }}"
+
+// Compose defines the compose file whose services are built.
+type Compose struct {
+	File string // Compose file path
+	Repo string // Template naming the repository of each service
+}
+
+// composeRepoData is the data the repository template of a service is
+// executed with.
+type composeRepoData struct {
+	Service string // Service name
+	Image   string // Repository of the service image, without the tag
+	Repo    string // Step repository
+}
+
+type (
+	// composeFile is the part of a compose file describing the builds.
+	composeFile struct {
+		Services map[string]composeService `yaml:"services"`
+	}
+
+	composeService struct {
+		Image string        `yaml:"image"`
+		Build *composeBuild `yaml:"build"`
+	}
+
+	// composeBuild is the build section of a service, either a context path
+	// or a mapping.
+	composeBuild struct {
+		Context          string    `yaml:"context"`
+		Dockerfile       string    `yaml:"dockerfile"`
+		DockerfileInline string    `yaml:"dockerfile_inline"`
+		Target           string    `yaml:"target"`
+		Args             yaml.Node `yaml:"args"`
+	}
+)
+
+// UnmarshalYAML accepts the short build syntax, a context path.
+func (b *composeBuild) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&b.Context)
+	}
+	type plain composeBuild
+	return node.Decode((*plain)(b))
+}
+
+// composeImages returns the images of the compose services having a build
+// section, sorted by service name. Paths are relative to the directory of
+// the compose file and ${VAR} references are expanded from the environment.
+func (p Plugin) composeImages() ([]Image, error) {
+	data, err := os.ReadFile(p.Compose.File)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the compose file: %w", err)
+	}
+	var compose composeFile
+	if err := yaml.Unmarshal([]byte(expandCompose(string(data))), &compose); err != nil {
+		return nil, fmt.Errorf("invalid compose file %s: %w", p.Compose.File, err)
+	}
+
+	repoTemplate := p.Compose.Repo
+	if repoTemplate == "" {
+		repoTemplate = defaultComposeRepo
+	}
+	tmpl, err := template.New("compose_repo").Option("missingkey=error").Parse(repoTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compose repo template: %w", err)
+	}
+
+	names := make([]string, 0, len(compose.Services))
+	for name := range compose.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dir := filepath.Dir(p.Compose.File)
+	var images []Image
+	for _, name := range names {
+		service := compose.Services[name]
+		if service.Build == nil {
+			fmt.Printf("Skipping compose service %s without a build section\n", name)
+			continue
+		}
+		build := service.Build
+		if build.DockerfileInline != "" {
+			return nil, fmt.Errorf("compose service %s: dockerfile_inline is not supported", name)
+		}
+
+		image := Image{Name: name, Target: build.Target}
+		image.Context = build.Context
+		if image.Context == "" {
+			image.Context = "."
+		}
+		if !filepath.IsAbs(image.Context) {
+			image.Context = filepath.Join(dir, image.Context)
+		}
+		image.Dockerfile = build.Dockerfile
+		if image.Dockerfile == "" {
+			image.Dockerfile = "Dockerfile"
+		}
+		if !filepath.IsAbs(image.Dockerfile) {
+			image.Dockerfile = filepath.Join(image.Context, image.Dockerfile)
+		}
+		if image.Args, err = composeArgs(build.Args); err != nil {
+			return nil, fmt.Errorf("compose service %s: %w", name, err)
+		}
+
+		imageRepo, _ := splitImageTag(service.Image)
+		var repo bytes.Buffer
+		if err := tmpl.Execute(&repo, composeRepoData{Service: name, Image: imageRepo, Repo: p.Build.Repo}); err != nil {
+			return nil, fmt.Errorf("compose service %s: invalid repo template: %w", name, err)
+		}
+		image.Repo = strings.TrimSpace(repo.String())
+		images = append(images, image)
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no service of compose file %s has a build section", p.Compose.File)
+	}
+	return images, nil
+}
+
+// composeArgs decodes the build args of a service, given as a mapping or as
+// a list of KEY=VALUE entries. An entry without a value takes it from the
+// environment and is left out when the variable is not set.
+func composeArgs(node yaml.Node) (map[string]string, error) {
+	args := map[string]string{}
+	switch node.Kind {
+	case 0:
+		return nil, nil
+	case yaml.MappingNode:
+		var values map[string]*string
+		if err := node.Decode(&values); err != nil {
+			return nil, fmt.Errorf("invalid build args: %w", err)
+		}
+		for key, value := range values {
+			if value != nil {
+				args[key] = *value
+			} else if env, ok := os.LookupEnv(key); ok {
+				args[key] = env
+			}
+		}
+	case yaml.SequenceNode:
+		var entries []string
+		if err := node.Decode(&entries); err != nil {
+			return nil, fmt.Errorf("invalid build args: %w", err)
+		}
+		for _, entry := range entries {
+			if key, value, ok := strings.Cut(entry, "="); ok {
+				args[key] = value
+			} else if env, ok := os.LookupEnv(entry); ok {
+				args[entry] = env
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid build args, expected a mapping or a list")
+	}
+	return args, nil
+}
+
+// expandCompose expands the ${VAR} and ${VAR:-default} references of a
+// compose file, $$ standing for a literal dollar sign.
+func expandCompose(s string) string {
+	s = strings.ReplaceAll(s, "$$", "\x00")
+	s = os.Expand(s, func(ref string) string {
+		if name, def, ok := strings.Cut(ref, ":-"); ok {
+			if value := os.Getenv(name); value != "" {
+				return value
+			}
+			return def
+		}
+		if name, def, ok := strings.Cut(ref, "-"); ok {
+			if value, set := os.LookupEnv(name); set {
+				return value
+			}
+			return def
+		}
+		return os.Getenv(ref)
+	})
+	return strings.ReplaceAll(s, "\x00", "$")
+}
@@ -0,0 +1,161 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testComposeFile = `
+services:
+  api:
+    image: quay.io/octocat/api:${API_VERSION:-dev}
+    build:
+      context: ./api
+      dockerfile: docker/Dockerfile
+      target: prod
+      args:
+        VERSION: "1.0"
+        NPM_TOKEN:
+  web:
+    build: ./web
+  worker:
+    build:
+      context: .
+      args:
+        - QUEUE=default
+        - GOFLAGS
+        - UNSET_ARG
+  db:
+    image: postgres:16
+`
+
+func writeComposeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "docker-compose.yml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestComposeImages(t *testing.T) {
+	path := writeComposeFile(t, testComposeFile)
+	dir := filepath.Dir(path)
+	t.Setenv("NPM_TOKEN", "secret")
+	t.Setenv("GOFLAGS", "-mod=vendor")
+
+	p := Plugin{Compose: Compose{File: path}, Build: Build{Repo: "quay.io/octocat"}}
+	images, err := p.composeImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Image{
+		{
+			Name:       "api",
+			Context:    filepath.Join(dir, "api"),
+			Dockerfile: filepath.Join(dir, "api", "docker", "Dockerfile"),
+			Target:     "prod",
+			Args:       map[string]string{"VERSION": "1.0", "NPM_TOKEN": "secret"},
+			Repo:       "quay.io/octocat/api",
+		},
+		{
+			Name:       "web",
+			Context:    filepath.Join(dir, "web"),
+			Dockerfile: filepath.Join(dir, "web", "Dockerfile"),
+			Repo:       "quay.io/octocat/web",
+		},
+		{
+			Name:       "worker",
+			Context:    dir,
+			Dockerfile: filepath.Join(dir, "Dockerfile"),
+			Args:       map[string]string{"QUEUE": "default", "GOFLAGS": "-mod=vendor"},
+			Repo:       "quay.io/octocat/worker",
+		},
+	}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("Got images %+v, want %+v", images, want)
+	}
+}
+
+func TestComposeImagesRepoTemplate(t *testing.T) {
+	path := writeComposeFile(t, testComposeFile)
+	tcs := []struct {
+		name     string
+		template string
+		repo     string
+		want     []string
+		err      string
+	}{
+		{name: "default without step repo", want: []string{"quay.io/octocat/api", "", ""}},
+		{name: "template", template: "{{ .Repo }}-{{ .Service }}", repo: "ghcr.io/octocat/app", want: []string{"ghcr.io/octocat/app-api", "ghcr.io/octocat/app-web", "ghcr.io/octocat/app-worker"}},
+		{name: "invalid template", template: "{{ .Repo", err: "invalid compose repo template"},
+		{name: "unknown field", template: "{{ .Project }}", err: "compose service api: invalid repo template"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			p := Plugin{Compose: Compose{File: path, Repo: tc.template}, Build: Build{Repo: tc.repo}}
+			images, err := p.composeImages()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected an error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var repos []string
+			for _, image := range images {
+				repos = append(repos, image.Repo)
+			}
+			if !reflect.DeepEqual(repos, tc.want) {
+				t.Errorf("Got repos %q, want %q", repos, tc.want)
+			}
+		})
+	}
+}
+
+func TestComposeImagesErrors(t *testing.T) {
+	tcs := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "no build", content: "services:\n  db:\n    image: postgres\n", err: "no service of compose file"},
+		{name: "inline dockerfile", content: "services:\n  api:\n    build:\n      dockerfile_inline: FROM alpine\n", err: "dockerfile_inline is not supported"},
+		{name: "invalid args", content: "services:\n  api:\n    build:\n      args: 1\n", err: "invalid build args"},
+		{name: "invalid yaml", content: "services: [", err: "invalid compose file"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			p := Plugin{Compose: Compose{File: writeComposeFile(t, tc.content)}}
+			if _, err := p.composeImages(); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Expected an error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestExpandCompose(t *testing.T) {
+	t.Setenv("SET", "value")
+	t.Setenv("EMPTY", "")
+	tcs := []struct {
+		in, want string
+	}{
+		{"${SET}", "value"},
+		{"$SET", "value"},
+		{"${UNSET:-default}", "default"},
+		{"${EMPTY:-default}", "default"},
+		{"${EMPTY-default}", ""},
+		{"${UNSET-default}", "default"},
+		{"$${SET}", "${SET}"},
+	}
+	for _, tc := range tcs {
+		if got := expandCompose(tc.in); got != tc.want {
+			t.Errorf("expandCompose(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFile is the declarative configuration file read from the
+// working directory when PLUGIN_CONFIG_FILE is not set.
+const DefaultConfigFile = ".drone-docker.yml"
+
+// ConfigFileEnv names the environment variable holding the configuration
+// file path.
+const ConfigFileEnv = "PLUGIN_CONFIG_FILE"
+
+type (
+	// FileConfig is the declarative plugin configuration. Its JSON Schema is
+	// published at docs/config.schema.json.
+	FileConfig struct {
+		Destination   FileDestination `yaml:"destination"`
+		Tags          []string        `yaml:"tags"`
+		AutoTag       *bool           `yaml:"auto_tag"`
+		AutoTagSuffix string          `yaml:"auto_tag_suffix"`
+		Build         FileBuild       `yaml:"build"`
+		Images        []Image         `yaml:"images"`
+		Concurrency   *int            `yaml:"concurrency"`
+		Bake          FileBake        `yaml:"bake"`
+		Compose       FileCompose     `yaml:"compose"`
+		Test          FileTest        `yaml:"test"`
+		Output        FileOutput      `yaml:"output"`
+		SmokeTests    SmokeTests      `yaml:"smoke_tests"`
+		Changes       FileChanges     `yaml:"changes"`
+		Push          FilePush        `yaml:"push"`
+		Signing       FileSigning     `yaml:"signing"`
+		Cleanup       FileCleanup     `yaml:"cleanup"`
+	}
+
+	// FileDestination defines where images are pushed.
+	FileDestination struct {
+		Registry string `yaml:"registry"`
+		Repo     string `yaml:"repo"`
+	}
+
+	// FileBuild defines the image build.
+	FileBuild struct {
+		Dockerfile       string            `yaml:"dockerfile"`
+		Context          string            `yaml:"context"`
+		Target           string            `yaml:"target"`
+		Platform         string            `yaml:"platform"`
+		Args             map[string]string `yaml:"args"`
+		ArgsFromEnv      []string          `yaml:"args_from_env"`
+		Labels           map[string]string `yaml:"labels"`
+		AutoLabel        *bool             `yaml:"auto_label"`
+		CacheFrom        []string          `yaml:"cache_from"`
+		SecretsFromEnv   map[string]string `yaml:"secrets_from_env"`
+		SecretsFromFile  map[string]string `yaml:"secrets_from_file"`
+		AddHost          []string          `yaml:"add_host"`
+		Pull             *bool             `yaml:"pull"`
+		NoCache          *bool             `yaml:"no_cache"`
+		ContentHash      *bool             `yaml:"content_hash"`
+		ContextChecksum  string            `yaml:"context_checksum"`
+		ContextAnalysis  *bool             `yaml:"context_analysis"`
+		ContextSizeLimit string            `yaml:"context_size_limit"`
+		Squash           *bool             `yaml:"squash"`
+		Quiet            *bool             `yaml:"quiet"`
+	}
+
+	// FileBake defines a docker buildx bake build.
+	FileBake struct {
+		Files   []string `yaml:"files"`
+		Targets []string `yaml:"targets"`
+		Set     []string `yaml:"set"`
+	}
+
+	// FileCompose defines the compose file whose services are built.
+	FileCompose struct {
+		File string `yaml:"file"`
+		Repo string `yaml:"repo"`
+	}
+
+	// FileTest defines the build stage running the tests.
+	FileTest struct {
+		Target      string   `yaml:"target"`
+		Reports     []string `yaml:"reports"`
+		ReportsDest string   `yaml:"reports_dest"`
+	}
+
+	// FileChanges defines the files whose changes trigger the build.
+	FileChanges struct {
+		SkipUnchanged *bool    `yaml:"skip_unchanged"`
+		Paths         []string `yaml:"paths"`
+		PathsIgnore   []string `yaml:"paths_ignore"`
+	}
+
+	// FileOutput defines the files exported from a build stage.
+	FileOutput struct {
+		Dest   string `yaml:"dest"`
+		Type   string `yaml:"type"`
+		Target string `yaml:"target"`
+		Only   *bool  `yaml:"only"`
+	}
+
+	// FilePush defines how images are pushed.
+	FilePush struct {
+		DryRun      *bool  `yaml:"dry_run"`
+		PushOnly    *bool  `yaml:"push_only"`
+		SourceImage string `yaml:"source_image"`
+		Retries     *int   `yaml:"retries"`
+		Backoff     string `yaml:"backoff"`
+	}
+
+	// FileSigning defines how pushed images are signed.
+	FileSigning struct {
+		Cosign FileCosign `yaml:"cosign"`
+	}
+
+	// FileCosign defines cosign signing. Only a key file path is accepted,
+	// key content and passwords belong in secrets.
+	FileCosign struct {
+		Key    string `yaml:"key"`
+		Params string `yaml:"params"`
+	}
+
+	// FileCleanup defines what is removed after the step.
+	FileCleanup struct {
+		Purge        *bool    `yaml:"purge"`
+		PruneCache   *bool    `yaml:"prune_cache"`
+		PruneFilters []string `yaml:"prune_filters"`
+	}
+)
+
+// fileSetting is a configuration file value and the environment variables
+// that take precedence over it. The value is exported as the first variable.
+type fileSetting struct {
+	Key   string
+	Envs  []string
+	Value string
+	Extra map[string]string // additional variables exported with the value
+}
+
+// LoadConfigFile reads and parses a configuration file. Unknown keys are
+// rejected so typos do not go unnoticed.
+func LoadConfigFile(path string) (*FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config FileConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// ApplyConfigFile exports the settings of the configuration file as plugin
+// environment variables. Settings already given through the environment take
+// precedence over the file, which takes precedence over the flag defaults.
+// A missing default configuration file is ignored.
+func ApplyConfigFile(path string) error {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultConfigFile
+	}
+	config, err := LoadConfigFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var applied, overridden []string
+	for _, setting := range config.settings() {
+		if env, ok := lookupAnyEnv(setting.Envs); ok {
+			overridden = append(overridden, fmt.Sprintf("%s (%s)", setting.Key, env))
+			continue
+		}
+		os.Setenv(setting.Envs[0], setting.Value)
+		for env, value := range setting.Extra {
+			os.Setenv(env, value)
+		}
+		applied = append(applied, setting.Key)
+	}
+
+	fmt.Printf("Loaded %d setting(s) from %s\n", len(applied), path)
+	if len(overridden) > 0 {
+		fmt.Printf("Environment overrides configuration file settings: %s\n", strings.Join(overridden, ", "))
+	}
+	return nil
+}
+
+// settings flattens the configuration into plugin environment variables.
+func (c *FileConfig) settings() []fileSetting {
+	var settings []fileSetting
+	str := func(key, value string, envs ...string) {
+		if value != "" {
+			settings = append(settings, fileSetting{Key: key, Envs: envs, Value: value})
+		}
+	}
+	list := func(key string, values []string, envs ...string) {
+		if len(values) > 0 {
+			settings = append(settings, fileSetting{Key: key, Envs: envs, Value: strings.Join(values, ",")})
+		}
+	}
+	boolean := func(key string, value *bool, envs ...string) {
+		if value != nil {
+			settings = append(settings, fileSetting{Key: key, Envs: envs, Value: strconv.FormatBool(*value)})
+		}
+	}
+
+	str("destination.registry", c.Destination.Registry, "PLUGIN_REGISTRY", "DOCKER_REGISTRY")
+	str("destination.repo", c.Destination.Repo, "PLUGIN_REPO")
+	list("tags", c.Tags, "PLUGIN_TAGS", "PLUGIN_TAG")
+	boolean("auto_tag", c.AutoTag, "PLUGIN_AUTO_TAG", "PLUGIN_DEFAULT_TAGS")
+	str("auto_tag_suffix", c.AutoTagSuffix, "PLUGIN_AUTO_TAG_SUFFIX", "PLUGIN_DEFAULT_SUFFIX")
+
+	str("build.dockerfile", c.Build.Dockerfile, "PLUGIN_DOCKERFILE")
+	str("build.context", c.Build.Context, "PLUGIN_CONTEXT")
+	str("build.target", c.Build.Target, "PLUGIN_TARGET")
+	str("build.platform", c.Build.Platform, "PLUGIN_PLATFORM")
+	if len(c.Build.Args) > 0 {
+		// semicolon separated build args keep commas inside values intact
+		settings = append(settings, fileSetting{
+			Key:   "build.args",
+			Envs:  []string{"PLUGIN_BUILD_ARGS_NEW", "PLUGIN_BUILD_ARGS"},
+			Value: strings.Join(pairs(c.Build.Args), ";"),
+			Extra: map[string]string{"PLUGIN_MULTIPLE_BUILD_ARGS": "true"},
+		})
+	}
+	list("build.args_from_env", c.Build.ArgsFromEnv, "PLUGIN_BUILD_ARGS_FROM_ENV")
+	list("build.labels", pairs(c.Build.Labels), "PLUGIN_CUSTOM_LABELS")
+	boolean("build.auto_label", c.Build.AutoLabel, "PLUGIN_AUTO_LABEL")
+	list("build.cache_from", c.Build.CacheFrom, "PLUGIN_CACHE_FROM")
+	list("build.secrets_from_env", pairs(c.Build.SecretsFromEnv), "PLUGIN_SECRETS_FROM_ENV")
+	list("build.secrets_from_file", pairs(c.Build.SecretsFromFile), "PLUGIN_SECRETS_FROM_FILE")
+	list("build.add_host", c.Build.AddHost, "PLUGIN_ADD_HOST")
+	boolean("build.pull", c.Build.Pull, "PLUGIN_PULL_IMAGE")
+	boolean("build.no_cache", c.Build.NoCache, "PLUGIN_NO_CACHE")
+	boolean("build.content_hash", c.Build.ContentHash, "PLUGIN_CONTENT_HASH")
+	str("build.context_checksum", c.Build.ContextChecksum, "PLUGIN_CONTEXT_CHECKSUM")
+	boolean("build.context_analysis", c.Build.ContextAnalysis, "PLUGIN_CONTEXT_ANALYSIS")
+	str("build.context_size_limit", c.Build.ContextSizeLimit, "PLUGIN_CONTEXT_SIZE_LIMIT")
+	boolean("build.squash", c.Build.Squash, "PLUGIN_SQUASH")
+	boolean("build.quiet", c.Build.Quiet, "PLUGIN_QUIET")
+
+	if len(c.Images) > 0 {
+		data, _ := json.Marshal(c.Images)
+		settings = append(settings, fileSetting{Key: "images", Envs: []string{"PLUGIN_IMAGES"}, Value: string(data)})
+	}
+	if c.Concurrency != nil {
+		settings = append(settings, fileSetting{Key: "concurrency", Envs: []string{"PLUGIN_CONCURRENCY"}, Value: strconv.Itoa(*c.Concurrency)})
+	}
+
+	list("bake.files", c.Bake.Files, "PLUGIN_BAKE_FILES", "PLUGIN_BAKE_FILE")
+	list("bake.targets", c.Bake.Targets, "PLUGIN_BAKE_TARGETS")
+	if len(c.Bake.Set) > 0 {
+		// semicolon separated overrides keep commas inside values intact
+		settings = append(settings, fileSetting{Key: "bake.set", Envs: []string{"PLUGIN_BAKE_SET"}, Value: strings.Join(c.Bake.Set, ";")})
+	}
+
+	str("compose.file", c.Compose.File, "PLUGIN_COMPOSE_FILE")
+	str("compose.repo", c.Compose.Repo, "PLUGIN_COMPOSE_REPO")
+
+	str("test.target", c.Test.Target, "PLUGIN_TEST_TARGET")
+	list("test.reports", c.Test.Reports, "PLUGIN_TEST_REPORTS")
+	str("test.reports_dest", c.Test.ReportsDest, "PLUGIN_TEST_REPORTS_DEST")
+
+	str("output.dest", c.Output.Dest, "PLUGIN_OUTPUT_DEST")
+	str("output.type", c.Output.Type, "PLUGIN_OUTPUT_TYPE")
+	str("output.target", c.Output.Target, "PLUGIN_OUTPUT_TARGET")
+	boolean("output.only", c.Output.Only, "PLUGIN_OUTPUT_ONLY")
+
+	boolean("changes.skip_unchanged", c.Changes.SkipUnchanged, "PLUGIN_SKIP_UNCHANGED")
+	list("changes.paths", c.Changes.Paths, "PLUGIN_PATHS")
+	list("changes.paths_ignore", c.Changes.PathsIgnore, "PLUGIN_PATHS_IGNORE")
+
+	if c.SmokeTests.enabled() {
+		data, _ := json.Marshal(c.SmokeTests)
+		settings = append(settings, fileSetting{Key: "smoke_tests", Envs: []string{"PLUGIN_SMOKE_TESTS"}, Value: string(data)})
+	}
+
+	boolean("push.dry_run", c.Push.DryRun, "PLUGIN_DRY_RUN", "PLUGIN_NO_PUSH")
+	boolean("push.push_only", c.Push.PushOnly, "PLUGIN_PUSH_ONLY")
+	str("push.source_image", c.Push.SourceImage, "PLUGIN_SOURCE_IMAGE")
+	if c.Push.Retries != nil {
+		settings = append(settings, fileSetting{Key: "push.retries", Envs: []string{"PLUGIN_PUSH_RETRIES"}, Value: strconv.Itoa(*c.Push.Retries)})
+	}
+	str("push.backoff", c.Push.Backoff, "PLUGIN_PUSH_BACKOFF")
+
+	str("signing.cosign.key", c.Signing.Cosign.Key, "PLUGIN_COSIGN_PRIVATE_KEY")
+	str("signing.cosign.params", c.Signing.Cosign.Params, "PLUGIN_COSIGN_PARAMS")
+
+	boolean("cleanup.purge", c.Cleanup.Purge, "PLUGIN_PURGE")
+	boolean("cleanup.prune_cache", c.Cleanup.PruneCache, "PLUGIN_PRUNE_CACHE")
+	list("cleanup.prune_filters", c.Cleanup.PruneFilters, "PLUGIN_PRUNE_FILTERS", "PLUGIN_PRUNE_FILTER")
+	return settings
+}
+
+// validate rejects values that cannot be represented as plugin settings.
+func (c *FileConfig) validate() error {
+	var errs []error
+	lists := map[string][]string{
+		"tags":                    c.Tags,
+		"build.args_from_env":     c.Build.ArgsFromEnv,
+		"build.labels":            pairs(c.Build.Labels),
+		"build.cache_from":        c.Build.CacheFrom,
+		"build.secrets_from_env":  pairs(c.Build.SecretsFromEnv),
+		"build.secrets_from_file": pairs(c.Build.SecretsFromFile),
+		"build.add_host":          c.Build.AddHost,
+		"bake.files":              c.Bake.Files,
+		"bake.targets":            c.Bake.Targets,
+		"test.reports":            c.Test.Reports,
+		"changes.paths":           c.Changes.Paths,
+		"changes.paths_ignore":    c.Changes.PathsIgnore,
+		"cleanup.prune_filters":   c.Cleanup.PruneFilters,
+	}
+	keys := make([]string, 0, len(lists))
+	for key := range lists {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range lists[key] {
+			if strings.Contains(value, ",") {
+				errs = append(errs, fmt.Errorf("%s: %q cannot contain a comma", key, value))
+			}
+		}
+	}
+	for key, value := range c.Build.Args {
+		if strings.Contains(key+value, ";") {
+			errs = append(errs, fmt.Errorf("build.args: %s cannot contain a semicolon", key))
+		}
+	}
+	for _, override := range c.Bake.Set {
+		if strings.Contains(override, ";") {
+			errs = append(errs, fmt.Errorf("bake.set: %q cannot contain a semicolon", override))
+		}
+	}
+	if c.Push.Backoff != "" {
+		if _, err := time.ParseDuration(c.Push.Backoff); err != nil {
+			errs = append(errs, fmt.Errorf("push.backoff: %w", err))
+		}
+	}
+	if strings.HasPrefix(c.Signing.Cosign.Key, "-----BEGIN") {
+		errs = append(errs, errors.New("signing.cosign.key: must be a file path, pass key content as a secret"))
+	}
+	return errors.Join(errs...)
+}
+
+// pairs returns the key=value pairs of a map sorted by key.
+func pairs(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for key, value := range m {
+		out = append(out, key+"="+value)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// lookupAnyEnv returns the first of envs set in the environment.
+func lookupAnyEnv(envs []string) (string, bool) {
+	for _, env := range envs {
+		if _, ok := os.LookupEnv(env); ok {
+			return env, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BaseImageConnector defines the credentials of a registry base images are
+// pulled from. Either a username and password or a docker config JSON must be
+// provided.
+type BaseImageConnector struct {
+	Registry string `json:"registry"` // Docker registry to pull base images from
+	Username string `json:"username"` // Docker registry username
+	Password string `json:"password"` // Docker registry password
+	Config   string `json:"config"`   // Docker config JSON holding the credentials
+}
+
+// ParseBaseImageConnectors parses a JSON list of base image connectors.
+func ParseBaseImageConnectors(data string) ([]BaseImageConnector, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var connectors []BaseImageConnector
+	if err := json.Unmarshal([]byte(data), &connectors); err != nil {
+		return nil, fmt.Errorf("invalid base image connectors: %w", err)
+	}
+	return connectors, nil
+}
+
+// name returns a human readable name for the connector used in logs.
+func (c BaseImageConnector) name() string {
+	if c.Registry != "" {
+		return c.Registry
+	}
+	return "docker config"
+}
+
+// validate checks that the connector carries usable credentials.
+func (c BaseImageConnector) validate() error {
+	if c.Config != "" {
+		if !json.Valid([]byte(c.Config)) {
+			return errors.New("docker config is not valid JSON")
+		}
+		return nil
+	}
+	if c.Registry == "" {
+		return errors.New("registry cannot be empty")
+	}
+	if c.Username == "" {
+		return errors.New("username cannot be empty. The base image connector requires authenticated access. Please either use an authenticated connector, or remove the base image connector")
+	}
+	if c.Password == "" {
+		return errors.New("password cannot be empty. The base image connector requires authenticated access. Please either use an authenticated connector, or remove the base image connector")
+	}
+	return nil
+}
+
+// baseImageConnectors returns every configured base image connector,
+// including the legacy single connector settings.
+func (p Plugin) baseImageConnectors() []BaseImageConnector {
+	var connectors []BaseImageConnector
+	if p.BaseImageRegistry != "" {
+		connectors = append(connectors, BaseImageConnector{
+			Registry: p.BaseImageRegistry,
+			Username: p.BaseImageUsername,
+			Password: p.BaseImagePassword,
+		})
+	}
+	return append(connectors, p.BaseImageConnectors...)
+}
+
+// loginBaseImageConnectors logs in to every base image connector registry.
+// Connectors supplied as docker config JSON are already merged into
+// config.json and need no login. Every connector is attempted and each
+// failure is reported with its registry.
+func (p Plugin) loginBaseImageConnectors() error {
+	var errs []error
+	for _, connector := range p.baseImageConnectors() {
+		if err := connector.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("base image connector %s: %w", connector.name(), err))
+			continue
+		}
+		if connector.Config != "" {
+			fmt.Printf("Base image connector credentials for %s added from docker config\n", connector.name())
+			continue
+		}
+
+		start := time.Now()
+		cmd := commandLogin(Login{
+			Registry: connector.Registry,
+			Username: connector.Username,
+			Password: connector.Password,
+		})
+		raw, err := cmd.CombinedOutput()
+		if err != nil {
+			err = fmt.Errorf("base image connector %s: %s", connector.name(), loginFailureReason(string(raw), err))
+			p.report.recordLogin(connector.Registry, "base-image", start, err)
+			p.recordLoginSpan(connector.Registry, "base-image", start, err)
+			errs = append(errs, err)
+			continue
+		}
+		p.report.recordLogin(connector.Registry, "base-image", start, nil)
+		p.recordLoginSpan(connector.Registry, "base-image", start, nil)
+		fmt.Printf("Logged in to base image registry %s\n", connector.name())
+	}
+
+	for _, err := range errs {
+		fmt.Printf("Error authenticating %s\n", secrets.Redact(err.Error()))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error authenticating base image connectors: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// loginFailureReason extracts the reason of a failed docker login from its
+// output, falling back to the command error.
+func loginFailureReason(output string, err error) string {
+	var reason string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			reason = line
+		}
+	}
+	if reason == "" {
+		return err.Error()
+	}
+	return reason
+}
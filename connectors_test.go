@@ -0,0 +1,82 @@
+package docker
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseBaseImageConnectors(t *testing.T) {
+	got, err := ParseBaseImageConnectors(`[
+		{"registry": "quay.io", "username": "bot", "password": "secret"},
+		{"config": "{\"auths\": {}}"}
+	]`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []BaseImageConnector{
+		{Registry: "quay.io", Username: "bot", Password: "secret"},
+		{Config: `{"auths": {}}`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got connectors %v, want %v", got, want)
+	}
+
+	if got, err := ParseBaseImageConnectors(""); err != nil || got != nil {
+		t.Errorf("expected no connectors for empty input, got %v, %v", got, err)
+	}
+	if _, err := ParseBaseImageConnectors("{"); err == nil {
+		t.Errorf("expected an error for invalid json")
+	}
+}
+
+func TestBaseImageConnectors(t *testing.T) {
+	p := Plugin{
+		BaseImageRegistry: "docker.io",
+		BaseImageUsername: "user",
+		BaseImagePassword: "pass",
+		BaseImageConnectors: []BaseImageConnector{
+			{Registry: "quay.io", Username: "bot", Password: "secret"},
+		},
+	}
+	got := p.baseImageConnectors()
+	want := []BaseImageConnector{
+		{Registry: "docker.io", Username: "user", Password: "pass"},
+		{Registry: "quay.io", Username: "bot", Password: "secret"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got connectors %v, want %v", got, want)
+	}
+}
+
+func TestBaseImageConnectorValidate(t *testing.T) {
+	tcs := []struct {
+		name      string
+		connector BaseImageConnector
+		valid     bool
+	}{
+		{"credentials", BaseImageConnector{Registry: "quay.io", Username: "u", Password: "p"}, true},
+		{"docker config", BaseImageConnector{Config: `{"auths":{}}`}, true},
+		{"invalid docker config", BaseImageConnector{Config: `{`}, false},
+		{"missing registry", BaseImageConnector{Username: "u", Password: "p"}, false},
+		{"missing username", BaseImageConnector{Registry: "quay.io", Password: "p"}, false},
+		{"missing password", BaseImageConnector{Registry: "quay.io", Username: "u"}, false},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.connector.validate(); (err == nil) != tc.valid {
+				t.Errorf("Got error %v, want valid=%t", err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestLoginFailureReason(t *testing.T) {
+	out := "Error response from daemon: Get \"https://quay.io/v2/\": unauthorized: incorrect username or password\n"
+	if got, want := loginFailureReason(out, errors.New("exit status 1")), `Error response from daemon: Get "https://quay.io/v2/": unauthorized: incorrect username or password`; got != want {
+		t.Errorf("Got reason %q, want %q", got, want)
+	}
+	if got := loginFailureReason("", errors.New("exit status 1")); got != "exit status 1" {
+		t.Errorf("Got reason %q, want the command error", got)
+	}
+}
@@ -0,0 +1,154 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// contentHashLabel is the image label holding the content hash the image was
+// built from.
+const contentHashLabel = "io.drone.docker.content-hash"
+
+// contentTagPrefix prefixes the tag an image is pushed with to be found by
+// its content hash.
+const contentTagPrefix = "content-"
+
+// contentHash returns a deterministic hash of everything the image is built
+// from: the Dockerfile, the files of the context surviving .dockerignore,
+// the effective build args, the target and the platform. Base images are not
+// part of the hash.
+func contentHash(build Build) (string, error) {
+	h := sha256.New()
+	data, err := os.ReadFile(build.Dockerfile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the Dockerfile: %w", err)
+	}
+	fmt.Fprintf(h, "dockerfile %d\n", len(data))
+	h.Write(data)
+
+	ignore, err := readDockerignore(build.Context, build.Dockerfile)
+	if err != nil {
+		return "", err
+	}
+	files, err := walkContext(build.Context, ignore)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		if err := hashContextFile(h, build.Context, file); err != nil {
+			return "", err
+		}
+	}
+
+	for _, arg := range effectiveBuildArgs(build) {
+		fmt.Fprintf(h, "arg %q\n", arg)
+	}
+	fmt.Fprintf(h, "target %q\nplatform %q\n", build.Target, build.Platform)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashContextFile writes the path, permissions and content of the file to
+// h, the link target for a symlink.
+func hashContextFile(h io.Writer, context string, file contextFile) error {
+	mode := file.Info.Mode()
+	path := filepath.Join(context, filepath.FromSlash(file.Path))
+	if mode&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "link %q %q\n", file.Path, target)
+		return nil
+	}
+	if !mode.IsRegular() {
+		fmt.Fprintf(h, "special %q %s\n", file.Path, mode)
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Fprintf(h, "file %q %o %d\n", file.Path, mode.Perm(), file.Info.Size())
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// effectiveBuildArgs returns the build args passed to the build, including
+// the args from the environment, sorted.
+func effectiveBuildArgs(build Build) []string {
+	for _, arg := range build.ArgsEnv {
+		addProxyValue(&build, arg)
+	}
+	args := build.Args
+	if build.IsMultipleBuildArgs {
+		args = build.ArgsNew
+	}
+	args = append([]string{}, args...)
+	sort.Strings(args)
+	return args
+}
+
+// contentTag returns the tag of the image built from the content hash.
+func contentTag(hash string) string {
+	return contentTagPrefix + hash[:32]
+}
+
+// findContentImage returns the reference of the image of the repo built
+// from the content hash, or an empty string when the registry has none. The
+// registry is probed without pulling, the image is only pulled on a match.
+func findContentImage(repo, hash string) string {
+	image := fmt.Sprintf("%s:%s", repo, contentTag(hash))
+	cmd := exec.Command(dockerExe, "buildx", "imagetools", "inspect", "--format", "{{json .Image}}", image)
+	trace(cmd)
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	// guard against a tag reused for an image of another content
+	if !hasContentHashLabel(output, hash) {
+		fmt.Printf("Image %s does not have the content hash label %s, ignoring it\n", image, hash)
+		return ""
+	}
+	cmd = exec.Command(dockerExe, "pull", "--quiet", image)
+	trace(cmd)
+	if err := cmd.Run(); err != nil {
+		fmt.Printf("Could not pull %s, building. %s\n", image, err)
+		return ""
+	}
+	return image
+}
+
+// imageConfig is the part of an OCI image config holding the labels.
+type imageConfig struct {
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+// hasContentHashLabel reports whether the image configs printed by docker
+// buildx imagetools inspect carry the content hash label. A multi-platform
+// image prints one config per platform, each of which must carry it.
+func hasContentHashLabel(output []byte, hash string) bool {
+	var config imageConfig
+	if err := json.Unmarshal(output, &config); err == nil && config.Config.Labels != nil {
+		return config.Config.Labels[contentHashLabel] == hash
+	}
+	var platforms map[string]imageConfig
+	if err := json.Unmarshal(output, &platforms); err != nil || len(platforms) == 0 {
+		return false
+	}
+	for _, config := range platforms {
+		if config.Config.Labels[contentHashLabel] != hash {
+			return false
+		}
+	}
+	return true
+}
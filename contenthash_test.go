@@ -0,0 +1,170 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContentHash(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".dockerignore", "*.log\n")
+	write("Dockerfile", "FROM scratch\nCOPY main.go /\n")
+	write("main.go", "package main\n")
+
+	build := Build{
+		Dockerfile: filepath.Join(dir, "Dockerfile"),
+		Context:    dir,
+		Args:       []string{"B=2", "A=1"},
+		Target:     "prod",
+	}
+	hash := func(build Build) string {
+		t.Helper()
+		h, err := contentHash(build)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	base := hash(build)
+	if len(base) != 64 || hash(build) != base {
+		t.Fatalf("Expected a stable sha256 hash, got %s", base)
+	}
+
+	reordered := build
+	reordered.Args = []string{"A=1", "B=2"}
+	if hash(reordered) != base {
+		t.Error("Expected the hash to ignore the order of the build args")
+	}
+
+	write("app.log", "ignored\n")
+	if hash(build) != base {
+		t.Error("Expected the hash to ignore files excluded by .dockerignore")
+	}
+
+	changed := []struct {
+		name  string
+		build func() Build
+	}{
+		{"args", func() Build { b := build; b.Args = []string{"A=1", "B=3"}; return b }},
+		{"target", func() Build { b := build; b.Target = "debug"; return b }},
+		{"platform", func() Build { b := build; b.Platform = "linux/arm64"; return b }},
+		{"context", func() Build { write("main.go", "package main\n\nfunc main() {}\n"); return build }},
+		{"dockerfile", func() Build { write("Dockerfile", "FROM alpine\n"); return build }},
+	}
+	for _, tc := range changed {
+		if hash(tc.build()) == base {
+			t.Errorf("Expected the hash to change with the %s", tc.name)
+		}
+	}
+
+	if _, err := contentHash(Build{Dockerfile: filepath.Join(dir, "missing"), Context: dir}); err == nil {
+		t.Error("Expected an error for a missing Dockerfile")
+	}
+}
+
+func TestContentTag(t *testing.T) {
+	tag := contentTag(strings.Repeat("ab", 32))
+	if tag != "content-"+strings.Repeat("ab", 16) {
+		t.Errorf("Unexpected content tag %s", tag)
+	}
+}
+
+func TestExecutionPlanContentHash(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0644)
+	p := Plugin{Build: Build{
+		Dockerfile:  filepath.Join(dir, "Dockerfile"),
+		Context:     dir,
+		TempTag:     "abc123",
+		Repo:        "octocat/app",
+		Tags:        []string{"latest"},
+		ContentHash: true,
+	}}
+	plan := p.executionPlan()
+	if plan.ContentHash == "" {
+		t.Fatal("Expected the content hash in the plan")
+	}
+	want := "octocat/app:" + contentTag(plan.ContentHash)
+	if len(plan.Destinations) != 2 || plan.Destinations[1] != want {
+		t.Errorf("Expected the content tag %s in the destinations, got %v", want, plan.Destinations)
+	}
+	if !strings.Contains(strings.Join(plan.Build.Labels, " "), contentHashLabel+"="+plan.ContentHash) {
+		t.Errorf("Expected the content hash label, got %v", plan.Build.Labels)
+	}
+}
+
+func TestHasContentHashLabel(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	tcs := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{
+			name:   "single platform",
+			output: `{"architecture":"amd64","os":"linux","config":{"Labels":{"io.drone.docker.content-hash":"` + hash + `"}}}`,
+			want:   true,
+		},
+		{
+			name:   "other content",
+			output: `{"architecture":"amd64","os":"linux","config":{"Labels":{"io.drone.docker.content-hash":"0123"}}}`,
+		},
+		{
+			name:   "no label",
+			output: `{"architecture":"amd64","os":"linux","config":{}}`,
+		},
+		{
+			name: "multiple platforms",
+			output: `{"linux/amd64":{"config":{"Labels":{"io.drone.docker.content-hash":"` + hash + `"}}},` +
+				`"linux/arm64":{"config":{"Labels":{"io.drone.docker.content-hash":"` + hash + `"}}}}`,
+			want: true,
+		},
+		{
+			name: "platform of other content",
+			output: `{"linux/amd64":{"config":{"Labels":{"io.drone.docker.content-hash":"` + hash + `"}}},` +
+				`"linux/arm64":{"config":{}}}`,
+		},
+		{
+			name:   "invalid",
+			output: `not json`,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := hasContentHashLabel([]byte(tc.output), hash); got != tc.want {
+				t.Errorf("Got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveBuildContentTag(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0644)
+	p := Plugin{Build: Build{
+		Context:     dir,
+		Dockerfile:  filepath.Join(dir, "Dockerfile"),
+		Repo:        "octocat/hello",
+		Tags:        []string{"latest"},
+		ContentHash: true,
+	}}
+	remove, err := p.resolveBuild()
+	defer remove()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the cleanup removes the content tag with the other tags
+	want := "octocat/hello:" + contentTag(p.buildHash)
+	images := p.cleanupImages()
+	if p.buildHash == "" || images[len(images)-1] != want {
+		t.Errorf("Expected %s to be cleaned up, got %v", want, images)
+	}
+}
@@ -0,0 +1,194 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/inhies/go-bytesize"
+)
+
+// contextTopEntries is the number of largest files and directories reported.
+const contextTopEntries = 5
+
+// ContextCheck defines the analysis of the build context run before the
+// build.
+type ContextCheck struct {
+	Enabled   bool   // Context size and common mistakes are reported
+	SizeLimit string // Maximum context size, such as 500MB, the build fails above it
+}
+
+// enabled reports whether the context is analyzed before the build.
+func (c ContextCheck) enabled() bool {
+	return c.Enabled || c.SizeLimit != ""
+}
+
+// limit returns the maximum context size in bytes, 0 when unlimited.
+func (c ContextCheck) limit() (int64, error) {
+	if c.SizeLimit == "" {
+		return 0, nil
+	}
+	size, err := bytesize.Parse(c.SizeLimit)
+	if err != nil {
+		return 0, err
+	}
+	return int64(size), nil
+}
+
+// contextEntry is a file or a directory of the build context and its size.
+type contextEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// contextAnalysis describes what a build sends as its context.
+type contextAnalysis struct {
+	Size         int64
+	Files        int
+	LargestFiles []contextEntry
+	LargestDirs  []contextEntry // Top-level directories
+	Warnings     []string
+}
+
+// secretFilePatterns match the base names of files that usually hold
+// credentials.
+var secretFilePatterns = []string{
+	".env", ".env.*", "*.pem", "*.key", "*.p12", "*.pfx", "*.kdbx",
+	"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519",
+	".npmrc", ".pypirc", ".netrc", ".git-credentials",
+	"credentials", "credentials.json", "*.tfstate",
+}
+
+// analyzeContext walks the files of the context surviving .dockerignore.
+func analyzeContext(context, dockerfile string) (*contextAnalysis, error) {
+	ignore, err := readDockerignore(context, dockerfile)
+	if err != nil {
+		return nil, err
+	}
+	files, err := walkContext(context, ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &contextAnalysis{Files: len(files)}
+	dirs := map[string]int64{}
+	var entries []contextEntry
+	var git, nodeModules bool
+	var secretFiles []string
+	for _, file := range files {
+		size := file.Info.Size()
+		analysis.Size += size
+		entries = append(entries, contextEntry{Path: file.Path, Size: size})
+		if dir, _, ok := strings.Cut(file.Path, "/"); ok {
+			dirs[dir] += size
+		}
+
+		segments := strings.Split(file.Path, "/")
+		for _, segment := range segments[:len(segments)-1] {
+			git = git || segment == ".git"
+			nodeModules = nodeModules || segment == "node_modules"
+		}
+		if isSecretFile(file.Path) {
+			secretFiles = append(secretFiles, file.Path)
+		}
+	}
+	analysis.LargestFiles = largestEntries(entries)
+	entries = nil
+	for dir, size := range dirs {
+		entries = append(entries, contextEntry{Path: dir + "/", Size: size})
+	}
+	analysis.LargestDirs = largestEntries(entries)
+
+	if git {
+		analysis.Warnings = append(analysis.Warnings, ".git is part of the build context, add it to .dockerignore")
+	}
+	if nodeModules {
+		analysis.Warnings = append(analysis.Warnings, "node_modules is part of the build context, add it to .dockerignore and install the dependencies in the image")
+	}
+	if len(secretFiles) > 0 {
+		listed := secretFiles
+		if len(listed) > contextTopEntries {
+			listed = listed[:contextTopEntries]
+		}
+		warning := "files that may hold secrets are part of the build context: " + strings.Join(listed, ", ")
+		if more := len(secretFiles) - len(listed); more > 0 {
+			warning += fmt.Sprintf(" and %d more", more)
+		}
+		analysis.Warnings = append(analysis.Warnings, warning)
+	}
+	return analysis, nil
+}
+
+// isSecretFile reports whether the base name of the context path matches
+// a secret file pattern, ignoring examples and templates.
+func isSecretFile(p string) bool {
+	name := path.Base(p)
+	for _, suffix := range []string{".example", ".sample", ".template", ".dist"} {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	for _, pattern := range secretFilePatterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// largestEntries returns the largest entries, by decreasing size.
+func largestEntries(entries []contextEntry) []contextEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Size != entries[j].Size {
+			return entries[i].Size > entries[j].Size
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	if len(entries) > contextTopEntries {
+		entries = entries[:contextTopEntries]
+	}
+	return entries
+}
+
+// checkContext analyzes the build context of p.Build, prints its size, its
+// largest entries and the mistakes found, and fails above the size limit.
+func (p Plugin) checkContext() error {
+	start := time.Now()
+	analysis, err := analyzeContext(p.Build.Context, p.Build.Dockerfile)
+	if err != nil {
+		p.report.recordContext(p.imageName, p.Build.Context, nil, start, err)
+		return err
+	}
+	limit, err := p.ContextCheck.limit()
+	if err != nil {
+		return fmt.Errorf("invalid context size limit %s: %w", p.ContextCheck.SizeLimit, err)
+	}
+
+	fmt.Printf("Build context %s: %s in %d file(s)\n", p.Build.Context, formatSize(analysis.Size), analysis.Files)
+	printEntries := func(title string, entries []contextEntry) {
+		if len(entries) == 0 {
+			return
+		}
+		fmt.Println(title)
+		for _, entry := range entries {
+			fmt.Printf("  %10s  %s\n", formatSize(entry.Size), entry.Path)
+		}
+	}
+	printEntries("Largest files:", analysis.LargestFiles)
+	printEntries("Largest directories:", analysis.LargestDirs)
+	for _, warning := range analysis.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+
+	if limit > 0 && analysis.Size > limit {
+		err = fmt.Errorf("build context %s is %s, over the %s limit", p.Build.Context, formatSize(analysis.Size), formatSize(limit))
+	}
+	p.report.recordContext(p.imageName, p.Build.Context, analysis, start, err)
+	return err
+}
+
+func formatSize(size int64) string {
+	return bytesize.New(float64(size)).String()
+}
@@ -0,0 +1,156 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeContext(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{
+		"Dockerfile":               20,
+		"main.go":                  100,
+		"assets/video.mp4":         5000,
+		"assets/logo.png":          300,
+		"web/node_modules/x/x.js":  700,
+		".git/objects/pack/p.pack": 2000,
+		".env":                     10,
+		".env.example":             10,
+		"certs/server.key":         50,
+		"build/debug.log":          4000,
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("build\n*.md\n"), 0644)
+
+	analysis, err := analyzeContext(dir, filepath.Join(dir, "Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Files != 10 || analysis.Size != 8201 {
+		t.Errorf("Expected 10 files of 8201 bytes, got %d files of %d bytes", analysis.Files, analysis.Size)
+	}
+	wantFiles := []contextEntry{
+		{"assets/video.mp4", 5000},
+		{".git/objects/pack/p.pack", 2000},
+		{"web/node_modules/x/x.js", 700},
+		{"assets/logo.png", 300},
+		{"main.go", 100},
+	}
+	if !reflect.DeepEqual(analysis.LargestFiles, wantFiles) {
+		t.Errorf("Expected largest files %v, got %v", wantFiles, analysis.LargestFiles)
+	}
+	wantDirs := []contextEntry{
+		{"assets/", 5300},
+		{".git/", 2000},
+		{"web/", 700},
+		{"certs/", 50},
+	}
+	if !reflect.DeepEqual(analysis.LargestDirs, wantDirs) {
+		t.Errorf("Expected largest directories %v, got %v", wantDirs, analysis.LargestDirs)
+	}
+
+	warnings := strings.Join(analysis.Warnings, "\n")
+	for _, want := range []string{".git is part", "node_modules is part", "secrets are part of the build context: .env, certs/server.key\n"} {
+		if !strings.Contains(warnings+"\n", want) {
+			t.Errorf("Expected a warning containing %q, got %v", want, analysis.Warnings)
+		}
+	}
+
+	os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte(".git\n**/node_modules\n.env\n**/*.key\nbuild\n"), 0644)
+	analysis, err = analyzeContext(dir, filepath.Join(dir, "Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(analysis.Warnings) != 0 {
+		t.Errorf("Expected no warning, got %v", analysis.Warnings)
+	}
+}
+
+func TestIsSecretFile(t *testing.T) {
+	tcs := []struct {
+		path string
+		want bool
+	}{
+		{".env", true},
+		{"config/.env.production", true},
+		{".env.example", false},
+		{"home/.ssh/id_ed25519", true},
+		{"home/.ssh/id_ed25519.pub", false},
+		{"tls/server.pem", true},
+		{".npmrc", true},
+		{"terraform.tfstate", true},
+		{"main.go", false},
+		{"keys.go", false},
+	}
+	for _, tc := range tcs {
+		if got := isSecretFile(tc.path); got != tc.want {
+			t.Errorf("isSecretFile(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestCheckContextLimit(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "data.bin"), make([]byte, 2048), 0644)
+
+	p := Plugin{
+		Build:        Build{Context: dir, Dockerfile: filepath.Join(dir, "Dockerfile")},
+		ContextCheck: ContextCheck{SizeLimit: "1KB"},
+	}
+	if err := p.checkContext(); err == nil || !strings.Contains(err.Error(), "over the 1.00KB limit") {
+		t.Errorf("Expected the context to be over the limit, got %v", err)
+	}
+	p.ContextCheck.SizeLimit = "1MB"
+	if err := p.checkContext(); err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+}
+
+func TestCheckContextReport(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "assets"), 0755)
+	os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "assets", "data.bin"), make([]byte, 2048), 0644)
+
+	p := Plugin{
+		ReportFile:   "report.json",
+		Build:        Build{Context: dir, Dockerfile: filepath.Join(dir, "Dockerfile")},
+		ContextCheck: ContextCheck{SizeLimit: "1MB"},
+	}
+	p.report = p.newRunReport()
+	if err := p.checkContext(); err != nil {
+		t.Fatal(err)
+	}
+	p.Build.Context = filepath.Join(dir, "missing")
+	if err := p.checkContext(); err == nil {
+		t.Fatal("Expected an error for a missing context")
+	}
+
+	contexts := p.report.Contexts
+	if len(contexts) != 2 {
+		t.Fatalf("Expected both checks in the report, got %v", contexts)
+	}
+	if len(contexts[0].LargestDirs) == 0 || contexts[0].LargestDirs[0].Path != "assets/" {
+		t.Errorf("Expected the largest directories in the report, got %v", contexts[0].LargestDirs)
+	}
+	if contexts[1].Error == "" {
+		t.Errorf("Expected the failed check in the report, got %v", contexts[1])
+	}
+}
+
+func TestValidateContextCheck(t *testing.T) {
+	p := Plugin{Build: Build{Repo: "octocat/app"}, ContextCheck: ContextCheck{SizeLimit: "lots"}}
+	err := p.validate().Err()
+	if err == nil || !strings.Contains(err.Error(), "context_size_limit (PLUGIN_CONTEXT_SIZE_LIMIT): invalid size lots") {
+		t.Errorf("Expected an invalid size error, got %v", err)
+	}
+}
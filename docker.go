@@ -12,37 +12,48 @@ import (
 	"time"
 
 	"github.com/drone-plugins/drone-docker/internal/docker"
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
 	"github.com/drone-plugins/drone-plugin-lib/drone"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 type (
 	// Daemon defines Docker daemon parameters.
 	Daemon struct {
-		Registry      string             // Docker registry
-		Mirror        string             // Docker registry mirror
-		Insecure      bool               // Docker daemon enable insecure registries
-		StorageDriver string             // Docker daemon storage driver
-		StoragePath   string             // Docker daemon storage path
-		Disabled      bool               // DOcker daemon is disabled (already running)
-		Debug         bool               // Docker daemon started in debug mode
-		Bip           string             // Docker daemon network bridge IP address
-		DNS           []string           // Docker daemon dns server
-		DNSSearch     []string           // Docker daemon dns search domain
-		MTU           string             // Docker daemon mtu setting
-		IPv6          bool               // Docker daemon IPv6 networking
-		Experimental  bool               // Docker daemon enable experimental mode
-		RetryCount    int                // Number of retry attempts to reach Docker daemon
-		RegistryType  drone.RegistryType // Docker registry type
+		Registry         string             // Docker registry
+		Mirror           string             // Docker registry mirror
+		Insecure         bool               // Docker daemon enable insecure registries
+		StorageDriver    string             // Docker daemon storage driver
+		StoragePath      string             // Docker daemon storage path
+		Disabled         bool               // DOcker daemon is disabled (already running)
+		Debug            bool               // Docker daemon started in debug mode
+		Bip              string             // Docker daemon network bridge IP address
+		DNS              []string           // Docker daemon dns server
+		DNSSearch        []string           // Docker daemon dns search domain
+		MTU              string             // Docker daemon mtu setting
+		IPv6             bool               // Docker daemon IPv6 networking
+		Experimental     bool               // Docker daemon enable experimental mode
+		RetryCount       int                // Number of retry attempts to reach Docker daemon
+		RegistryType     drone.RegistryType // Docker registry type
+		Host             string             // Remote Docker host (disables the local daemon)
+		BuildkitHost     string             // Remote buildkitd address used for builds
+		TLSCACert        string             // CA certificate content for the remote endpoints
+		TLSCert          string             // Client certificate content for the remote endpoints
+		TLSKey           string             // Client key content for the remote endpoints
+		PullThroughCache string             // Registry used to retry pulls rate limited by Docker Hub
 	}
 
 	// Login defines Docker login parameters.
 	Login struct {
-		Registry    string // Docker registry address
-		Username    string // Docker registry username
-		Password    string // Docker registry password
-		Email       string // Docker registry email
-		Config      string // Docker Auth Config
-		AccessToken string // External Access Token
+		Registry           string   // Docker registry address
+		Username           string   // Docker registry username
+		Password           string   // Docker registry password
+		Email              string   // Docker registry email
+		Config             string   // Docker Auth Config
+		AccessToken        string   // External Access Token
+		CredHelpers        []string // Docker credential helpers (registry=helper)
+		CredsStore         string   // Docker default credential store
+		CredentialProvider string   // Credential helper that refreshes the registry credentials
 	}
 
 	// Build defines Docker build parameters.
@@ -76,6 +87,10 @@ type (
 		Platform            string   // Docker build platform
 		SSHAgentKey         string   // Docker build ssh agent key
 		SSHKeyPath          string   // Docker build ssh key path
+		Builder             string   // Docker buildx builder instance
+		ContentHash         bool     // Build skipped when an image of the same content was pushed
+		ContextChecksum     string   // Expected sha256 of a tarball context
+		ContextGitToken     string   // Token fetching a git context
 	}
 
 	// CosignConfig defines Cosign signing parameters.
@@ -87,19 +102,49 @@ type (
 
 	// Plugin defines the Docker plugin parameters.
 	Plugin struct {
-		Login             Login        // Docker login configuration
-		Build             Build        // Docker build configuration
-		Daemon            Daemon       // Docker daemon configuration
-		Cosign            CosignConfig // Cosign signing configuration
-		Dryrun            bool         // Docker push is skipped
-		Cleanup           bool         // Docker purge is enabled
-		CardPath          string       // Card path to write file to
-		ArtifactFile      string       // Artifact path to write file to
-		BaseImageRegistry string       // Docker registry to pull base image
-		BaseImageUsername string       // Docker registry username to pull base image
-		BaseImagePassword string       // Docker registry password to pull base image
-		PushOnly          bool         // Push only mode, skips build process
-		SourceImage       string       // Source image to push (optional)
+		Login               Login                // Docker login configuration
+		Build               Build                // Docker build configuration
+		Daemon              Daemon               // Docker daemon configuration
+		Cosign              CosignConfig         // Cosign signing configuration
+		Dryrun              bool                 // Docker push is skipped
+		Plan                bool                 // Execution plan is reported, nothing is run
+		PlanFile            string               // Execution plan JSON path
+		ReportFile          string               // Run report JSON path
+		Cleanup             bool                 // Docker purge is enabled
+		PruneCache          bool                 // Docker build cache is pruned during purge
+		PruneFilters        []string             // Docker build cache prune filters
+		CardPath            string               // Card path to write file to
+		ArtifactFile        string               // Artifact path to write file to
+		OutputFile          string               // Dotenv file the step outputs are written to
+		SecretOutputFile    string               // Dotenv file the secret step outputs are written to
+		SecretOutputs       bool                 // Step outputs are written as secrets
+		BaseImageRegistry   string               // Docker registry to pull base image
+		BaseImageUsername   string               // Docker registry username to pull base image
+		BaseImagePassword   string               // Docker registry password to pull base image
+		BaseImageConnectors []BaseImageConnector // Docker registries to pull base images from
+		RateLimitCheck      bool                 // Docker Hub pull quota is reported before the build
+		PushRetries         int                  // Number of times a failed push is retried
+		PushBackoff         time.Duration        // Initial delay between push retries
+		PushOnly            bool                 // Push only mode, skips build process
+		SourceImage         string               // Source image to push (optional)
+		Images              []Image              // Images built by a multi-image step
+		Concurrency         int                  // Number of images built at once
+		Bake                Bake                 // Docker buildx bake configuration
+		Compose             Compose              // Compose file whose services are built
+		Export              BuildOutput          // Files exported from a build stage
+		Test                TestStage            // Build stage running the tests
+		Smoke               SmokeTests           // Checks run against the image before the push
+		Changes             ChangeFilter         // Files whose changes trigger the build
+		ContextCheck        ContextCheck         // Analysis of the build context before the build
+
+		report *runReport     // Run report, nil unless ReportFile is set
+		span   oteltrace.Span // Span of the step, parent of the phase spans
+
+		imageName    string // Image built by this copy of a multi-image step
+		outputPrefix string // Prefix of the command output of a concurrent image build
+
+		smokeResults []smokeResult // Smoke test results shown on the card
+		buildHash    string        // Content hash of the build, set with Build.ContentHash
 	}
 
 	Card []struct {
@@ -123,7 +168,8 @@ type (
 		SizeString        string
 		VirtualSizeString string
 		Time              string
-		URL               string `json:"URL"`
+		URL               string        `json:"URL"`
+		SmokeTests        []smokeResult `json:"SmokeTests,omitempty"`
 	}
 	TagStruct struct {
 		Tag string `json:"Tag"`
@@ -132,8 +178,69 @@ type (
 
 // Exec executes the plugin step
 func (p Plugin) Exec() error {
-	// start the Docker daemon server
-	if !p.Daemon.Disabled {
+	// mask every secret value in traced commands, relayed output and errors
+	p.registerSecrets()
+
+	span := p.startStepSpan()
+	err := secrets.RedactError(p.exec())
+	telemetry.End(span, err)
+	return err
+}
+
+// exec validates the configuration, then plans or runs the step.
+func (p Plugin) exec() error {
+	// the services of a compose file are built as the images of a
+	// multi-image step
+	if p.Compose.File != "" {
+		if len(p.Images) > 0 {
+			return fmt.Errorf("compose_file cannot be combined with images")
+		}
+		images, err := p.composeImages()
+		if err != nil {
+			return err
+		}
+		p.Images = images
+	}
+	p.registerImageSecrets()
+
+	// validate the whole configuration before any side effect
+	if err := reportConfigIssues(p.validate()); err != nil {
+		return err
+	}
+
+	// report what the step would do without starting the daemon
+	if p.Plan {
+		return p.plan()
+	}
+
+	// skip the step when the commit changes none of the watched paths
+	if p.Changes.enabled() {
+		changed, err := p.changed()
+		if err != nil || !changed {
+			return err
+		}
+	}
+
+	p.report = p.newRunReport()
+	err := secrets.RedactError(p.run())
+	if reportErr := p.report.finish(p.ReportFile, err); reportErr != nil {
+		fmt.Printf("Could not write the run report. %s\n", reportErr)
+	}
+	return err
+}
+
+// run starts the daemon and executes the step, cleaning up afterwards.
+func (p Plugin) run() error {
+	daemonStart := time.Now()
+
+	// start the Docker daemon server, unless a remote Docker host is used
+	if p.Daemon.usesRemoteDaemon() {
+		if err := setupRemoteDaemon(p.Daemon); err != nil {
+			p.report.recordDaemon(daemonStart, err)
+			p.recordSpan("docker.daemon", daemonStart, err)
+			return err
+		}
+	} else if !p.Daemon.Disabled {
 		p.startDaemon()
 	}
 
@@ -143,6 +250,7 @@ func (p Plugin) Exec() error {
 	if maxRetries <= 0 {
 		maxRetries = 15 // default value
 	}
+	var daemonErr error
 	for i := 0; ; i++ {
 		cmd := commandInfo()
 		err := cmd.Run()
@@ -151,11 +259,84 @@ func (p Plugin) Exec() error {
 		}
 		if i == maxRetries {
 			fmt.Printf("Unable to reach Docker Daemon after %d attempts.\n", maxRetries)
+			daemonErr = fmt.Errorf("unable to reach docker daemon after %d attempts", maxRetries)
 			break
 		}
 		time.Sleep(time.Second * 1)
 	}
+	p.report.recordDaemon(daemonStart, daemonErr)
+	p.recordSpan("docker.daemon", daemonStart, daemonErr)
+
+	// register the remote buildkitd endpoint used for builds
+	if p.Daemon.BuildkitHost != "" {
+		builder, err := setupRemoteBuilder(p.Daemon)
+		if err != nil {
+			return err
+		}
+		p.Build.Builder = builder
+	}
+
+	// resolve the build context and its content hash before registering the
+	// cleanup, so that the content tag is removed with the other tags
+	removeContext, err := p.resolveBuild()
+	defer removeContext()
+	if err != nil {
+		return err
+	}
 
+	// always run the cleanup routines once the daemon is reachable, even when
+	// the build or push fails. Cleanup failures are reported on their own and
+	// never change the result of the step.
+	if p.Cleanup {
+		defer func() {
+			result := p.cleanup()
+			p.report.recordCleanup(result)
+			reportCleanup(result)
+		}()
+	}
+
+	return p.execute()
+}
+
+// resolveBuild fetches a git, tarball or archive context into a local
+// directory, the Dockerfile being relative to it, and computes the content
+// hash of the build. It returns a function removing the fetched context.
+func (p *Plugin) resolveBuild() (func(), error) {
+	remove := func() {}
+	if p.PushOnly {
+		return remove, nil
+	}
+	if contextKind(p.Build.Context) != contextLocal {
+		dir, err := os.MkdirTemp("", "drone-docker-context")
+		if err != nil {
+			return remove, err
+		}
+		remove = func() { os.RemoveAll(dir) }
+		context, err := p.fetchContext(dir)
+		if err != nil {
+			return remove, err
+		}
+		if !filepath.IsAbs(p.Build.Dockerfile) {
+			p.Build.Dockerfile = filepath.Join(context, p.Build.Dockerfile)
+		}
+		p.Build.Context = context
+	}
+
+	if p.Build.ContentHash {
+		hash, err := contentHash(p.Build)
+		if err != nil {
+			return remove, err
+		}
+		fmt.Printf("Content hash: %s\n", hash)
+		p.buildHash = hash
+		p.Build.Labels = append(p.Build.Labels, contentHashLabel+"="+hash)
+		p.Build.Tags = append(p.Build.Tags, contentTag(hash))
+	}
+	return remove, nil
+}
+
+// execute runs the login, build, tag and push phases of the plugin step.
+func (p Plugin) execute() error {
 	// for debugging purposes, log the type of authentication
 	// credentials that have been provided.
 	switch {
@@ -171,15 +352,10 @@ func (p Plugin) Exec() error {
 		fmt.Println("Registry credentials or Docker config not provided. Guest mode enabled.")
 	}
 
-	// create Auth Config File
-	if p.Login.Config != "" {
-		os.MkdirAll(dockerHome, 0600)
-
-		path := filepath.Join(dockerHome, "config.json")
-		err := os.WriteFile(path, []byte(p.Login.Config), 0600)
-		if err != nil {
-			return fmt.Errorf("Error writing config.json: %s", err)
-		}
+	// create Auth Config File, merging every source of credentials into a
+	// single config.json instead of overwriting it
+	if _, err := p.writeDockerConfig(filepath.Join(dockerHome, "config.json")); err != nil {
+		return fmt.Errorf("Error writing config.json: %s", err)
 	}
 
 	// instead of writing to config file directly, using docker's login func
@@ -188,26 +364,9 @@ func (p Plugin) Exec() error {
 	//	as opposed to config write where different registries need to be addressed differently.
 	//	It handles any changes in the authentication process across different Docker versions.
 
-	if p.BaseImageRegistry != "" {
-		if p.BaseImageUsername == "" {
-			fmt.Printf("Username cannot be empty. The base image connector requires authenticated access. Please either use an authenticated connector, or remove the base image connector.")
-		}
-		if p.BaseImagePassword == "" {
-			fmt.Printf("Password cannot be empty. The base image connector requires authenticated access. Please either use an authenticated connector, or remove the base image connector.")
-		}
-		var baseConnectorLogin Login
-		baseConnectorLogin.Registry = p.BaseImageRegistry
-		baseConnectorLogin.Username = p.BaseImageUsername
-		baseConnectorLogin.Password = p.BaseImagePassword
-
-		cmd := commandLogin(baseConnectorLogin)
-
-		raw, err := cmd.CombinedOutput()
-		if err != nil {
-			out := string(raw)
-			out = strings.Replace(out, "WARNING! Using --password via the CLI is insecure. Use --password-stdin.", "", -1)
-			fmt.Println(out)
-			return fmt.Errorf("Error authenticating base connector: exit status 1")
+	if len(p.baseImageConnectors()) > 0 {
+		if err := p.loginBaseImageConnectors(); err != nil {
+			return err
 		}
 	} else if !p.PushOnly {
 		// Skip base image connector warning in push-only mode (not pulling anything)
@@ -217,30 +376,33 @@ func (p Plugin) Exec() error {
 
 	// login to the Docker registry
 	if p.Login.Password != "" {
+		loginStart := time.Now()
 		cmd := commandLogin(p.Login)
 		raw, err := cmd.CombinedOutput()
 		if err != nil {
-			out := string(raw)
-			out = strings.Replace(out, "WARNING! Using --password via the CLI is insecure. Use --password-stdin.", "", -1)
-			fmt.Println(out)
-			return fmt.Errorf("error authenticating: exit status 1")
+			err = fmt.Errorf("error authenticating to %s: %s", p.Login.Registry, loginFailureReason(string(raw), err))
+		}
+		p.report.recordLogin(p.Login.Registry, "push", loginStart, err)
+		p.recordLoginSpan(p.Login.Registry, "push", loginStart, err)
+		if err != nil {
+			return err
 		}
 	} else if p.Login.AccessToken != "" {
+		loginStart := time.Now()
 		cmd := commandLoginAccessToken(p.Login, p.Login.AccessToken)
 		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("error logging in to Docker registry: %s", err)
+		switch {
+		case err != nil:
+			err = fmt.Errorf("error logging in to %s: %s", p.Login.Registry, loginFailureReason(string(output), err))
+		case !strings.Contains(string(output), "Login Succeeded"):
+			err = fmt.Errorf("login did not succeed")
 		}
-		if strings.Contains(string(output), "Login Succeeded") {
-			fmt.Println("Login successful")
-		} else {
-			return fmt.Errorf("login did not succeed")
+		p.report.recordLogin(p.Login.Registry, "push", loginStart, err)
+		p.recordLoginSpan(p.Login.Registry, "push", loginStart, err)
+		if err != nil {
+			return err
 		}
-	}
-
-	// Enforce mutual exclusivity: push-only and dry-run cannot be used together
-	if p.PushOnly && p.Dryrun {
-		return fmt.Errorf("conflict: push-only and dry-run cannot be used together")
+		fmt.Println("Login successful")
 	}
 
 	// Handle push-only mode if requested
@@ -248,14 +410,39 @@ func (p Plugin) Exec() error {
 		return p.pushOnly()
 	}
 
+	// reuse the image pushed for the same content instead of rebuilding it
+	if p.buildHash != "" {
+		if image := findContentImage(p.Build.Repo, p.buildHash); image != "" {
+			fmt.Printf("Found %s built from the same content, skipping the build\n", image)
+			if p.Dryrun {
+				return nil
+			}
+			p.SourceImage = image
+			return p.pushOnly()
+		}
+		fmt.Println("No image built from the same content, building")
+	}
+
+	// report the size of the build context and fail above its limit
+	if p.ContextCheck.enabled() && len(p.Images) == 0 && !p.Bake.enabled() {
+		if err := p.checkContext(); err != nil {
+			return err
+		}
+	}
+
+	// squash requires the experimental daemon, reported during validation
 	if p.Build.Squash && !p.Daemon.Experimental {
-		fmt.Println("Squash build flag is only available when Docker deamon is started with experimental flag. Ignoring...")
 		p.Build.Squash = false
 	}
 
 	// add proxy build args
 	addProxyBuildArgs(&p.Build)
 
+	// report the remaining Docker Hub pull quota before building
+	if p.RateLimitCheck {
+		p.reportDockerHubQuota()
+	}
+
 	var cmds []*exec.Cmd
 	cmds = append(cmds, commandVersion()) // docker version
 	cmds = append(cmds, commandInfo())    // docker info
@@ -274,8 +461,6 @@ func (p Plugin) Exec() error {
 		}
 	}
 
-	cmds = append(cmds, commandBuild(p.Build)) // docker build
-
 	// Validate cosign configuration if present
 	if p.shouldSignWithCosign() {
 		if err := validateCosignConfig(p.Cosign); err != nil {
@@ -284,30 +469,67 @@ func (p Plugin) Exec() error {
 		fmt.Println("🔐 Cosign signing enabled - images will be signed after push")
 	}
 
-	for _, tag := range p.Build.Tags {
-		cmds = append(cmds, commandTag(p.Build, tag)) // docker tag
+	// build the bake targets instead of a single image
+	if p.Bake.enabled() {
+		if err := p.runCommands(cmds); err != nil {
+			return err
+		}
+		return p.bake()
+	}
 
-		if !p.Dryrun {
-			cmds = append(cmds, commandPush(p.Build, tag)) // docker push
+	// build every image of a multi-image step on the shared daemon
+	if len(p.Images) > 0 {
+		if err := p.runCommands(cmds); err != nil {
+			return err
 		}
+		return p.buildImages()
 	}
 
-	// execute all commands in batch mode.
-	for _, cmd := range cmds {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		trace(cmd)
+	// run the tests before building, exporting and pushing anything
+	if p.Test.enabled() {
+		if err := p.runCommands(cmds); err != nil {
+			return err
+		}
+		cmds = nil
+		if err := p.runTests(); err != nil {
+			return err
+		}
+	}
 
-		err := cmd.Run()
-		if err != nil && isCommandPull(cmd.Args) {
-			fmt.Printf("Could not pull cache-from image %s. Ignoring...\n", cmd.Args[2])
-		} else if err != nil && isCommandPrune(cmd.Args) {
-			fmt.Printf("Could not prune system containers. Ignoring...\n")
-		} else if err != nil && isCommandRmi(cmd.Args) {
-			fmt.Printf("Could not remove image %s. Ignoring...\n", cmd.Args[2])
-		} else if err != nil {
+	// export the files of the output stage before building the image
+	if p.Export.enabled() {
+		if err := p.runCommands(cmds); err != nil {
+			return err
+		}
+		cmds = nil
+		if err := p.exportBuild(); err != nil {
 			return err
 		}
+		if p.Export.Only {
+			return nil
+		}
+	}
+
+	// smoke test the built image before tagging and pushing it
+	if p.Smoke.enabled() {
+		cmds = append(cmds, commandBuild(p.Build))
+		if err := p.runCommands(cmds); err != nil {
+			return err
+		}
+		var err error
+		if p.smokeResults, err = p.runSmokeTests(p.Build.TempTag); err != nil {
+			if err := p.writeCard(); err != nil {
+				fmt.Printf("Could not create adaptive card. %s\n", err)
+			}
+			return err
+		}
+		cmds = p.pushCommands()
+	} else {
+		cmds = append(cmds, p.imageCommands()...)
+	}
+
+	if err := p.runCommands(cmds); err != nil {
+		return err
 	}
 
 	// output the adaptive card
@@ -315,6 +537,15 @@ func (p Plugin) Exec() error {
 		fmt.Printf("Could not create adaptive card. %s\n", err)
 	}
 
+	// export the digest and image references to the later steps
+	if p.outputFile() != "" {
+		var digest string
+		if !p.Dryrun {
+			digest, _ = getDigest(p.Build.TempTag)
+		}
+		p.exportOutputs(p.Build.Tags, digest)
+	}
+
 	if p.ArtifactFile != "" {
 		if digest, err := getDigest(p.Build.TempTag); err == nil {
 			if err = drone.WritePluginArtifactFile(p.Daemon.RegistryType, p.ArtifactFile, p.Daemon.Registry, p.Build.Repo, digest, p.Build.Tags); err != nil {
@@ -327,98 +558,250 @@ func (p Plugin) Exec() error {
 
 	// Handle cosign signing after all commands complete (like artifact generation)
 	if p.shouldSignWithCosign() && !p.Dryrun {
-		// Set up environment variables for cosign
-		os.Setenv("COSIGN_YES", "true")
+		p.signImage()
+	}
 
-		if digest, err := getDigest(p.Build.TempTag); err == nil {
-			fmt.Printf("🔐 Found image digest: %s\n", digest)
+	return nil
+}
 
-			// Sign with digest reference
-			imageRef := fmt.Sprintf("%s@%s", p.Build.Repo, digest)
-			cosignCmd := createCosignCommand(imageRef, p.Cosign)
-			executeCosignCommand(cosignCmd)
-		} else {
-			fmt.Printf("⚠️  WARNING: Could not get image digest for cosign signing: %s\n", err)
-			fmt.Printf("   Falling back to tag-based signing\n")
+// imageCommands returns the commands building, tagging and pushing the image
+// of p.Build.
+func (p Plugin) imageCommands() []*exec.Cmd {
+	cmds := []*exec.Cmd{commandBuild(p.Build)} // docker build
+	return append(cmds, p.pushCommands()...)
+}
 
-			// Fall back to tag-based signing for each tag
-			for _, tag := range p.Build.Tags {
-				imageRef := fmt.Sprintf("%s:%s", p.Build.Repo, tag)
-				cosignCmd := createCosignCommand(imageRef, p.Cosign)
-				executeCosignCommand(cosignCmd)
+// pushCommands returns the commands tagging and pushing the built image of
+// p.Build.
+func (p Plugin) pushCommands() []*exec.Cmd {
+	var cmds []*exec.Cmd
+	for _, tag := range p.Build.Tags {
+		cmds = append(cmds, commandTag(p.Build, tag)) // docker tag
+
+		if !p.Dryrun {
+			cmds = append(cmds, commandPush(p.Build, tag)) // docker push
+		}
+	}
+	return cmds
+}
+
+// runCommands executes the commands in batch mode, stopping at the first
+// failure. Failing cache pulls, prunes and image removals are ignored.
+func (p Plugin) runCommands(cmds []*exec.Cmd) error {
+	for _, cmd := range cmds {
+		if isCommandPush(cmd.Args) {
+			if err := p.push(cmd); err != nil {
+				return err
 			}
+			continue
+		}
+		start := time.Now()
+		rateLimit := &rateLimitDetector{}
+		stats := &buildStats{}
+		err := runPrefixedCommand(cmd, p.outputPrefix, rateLimit, stats)
+		if err != nil && rateLimit.Detected() {
+			err = p.retryRateLimited(cmd, err)
+		}
+		if isCommandBuild(cmd.Args) {
+			p.report.recordBuild(p.imageName, start, stats, p.Build.TempTag, err)
+			p.recordSpan("docker.build", start, err,
+				telemetry.AttrRepo.String(p.Build.Repo),
+				telemetry.AttrImage.String(p.Build.TempTag),
+			)
+		} else if isCommandTag(cmd.Args) {
+			p.recordTagSpan(cmd.Args[3], start, err)
+		}
+		if err != nil && isCommandPull(cmd.Args) {
+			fmt.Printf("Could not pull cache-from image %s. Ignoring...\n", cmd.Args[2])
+		} else if err != nil && isCommandPrune(cmd.Args) {
+			fmt.Printf("Could not prune system containers. Ignoring...\n")
+		} else if err != nil && isCommandRmi(cmd.Args) {
+			fmt.Printf("Could not remove image %s. Ignoring...\n", cmd.Args[2])
+		} else if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// execute cleanup routines in batch mode
-	if p.Cleanup {
-		// clear the slice
-		cmds = nil
+// signImage signs the pushed image of p.Build with cosign, by digest when it
+// is known and by tag otherwise.
+func (p Plugin) signImage() {
+	// Set up environment variables for cosign
+	os.Setenv("COSIGN_YES", "true")
 
-		cmds = append(cmds, commandRmi(p.Build.TempTag)) // docker rmi
-		cmds = append(cmds, commandPrune())              // docker system prune -f
+	if digest, err := getDigest(p.Build.TempTag); err == nil {
+		fmt.Printf("🔐 Found image digest: %s\n", digest)
+
+		// Sign with digest reference
+		imageRef := fmt.Sprintf("%s@%s", p.Build.Repo, digest)
+		p.sign(imageRef)
+	} else {
+		fmt.Printf("⚠️  WARNING: Could not get image digest for cosign signing: %s\n", err)
+		fmt.Printf("   Falling back to tag-based signing\n")
 
-		for _, cmd := range cmds {
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			trace(cmd)
+		// Fall back to tag-based signing for each tag
+		for _, tag := range p.Build.Tags {
+			imageRef := fmt.Sprintf("%s:%s", p.Build.Repo, tag)
+			p.sign(imageRef)
 		}
 	}
+}
 
-	return nil
+// dockerConfig merges the existing docker config at path, the user supplied
+// config, the credential helpers and the registry credentials, recording the
+// origin of every entry.
+func (p Plugin) dockerConfig(path string) (*docker.ConfigManager, error) {
+	manager := docker.NewConfigManager()
+
+	if existing, err := os.ReadFile(path); err == nil {
+		if err := manager.MergeJSON(existing, docker.OriginExisting); err != nil {
+			fmt.Printf("Ignoring existing %s: %s\n", path, err)
+			manager = docker.NewConfigManager()
+		}
+	}
+	if p.Login.Config != "" {
+		if err := manager.MergeJSON([]byte(p.Login.Config), docker.OriginUserConfig); err != nil {
+			return nil, err
+		}
+	}
+	if p.Login.CredsStore != "" {
+		manager.SetCredsStore(p.Login.CredsStore, docker.OriginCredHelper)
+	}
+	for _, entry := range p.Login.CredHelpers {
+		registry, helper, ok := strings.Cut(entry, "=")
+		if !ok || registry == "" || helper == "" {
+			return nil, fmt.Errorf("%s is not a valid credential helper, expected registry=helper", entry)
+		}
+		manager.AddCredHelper(registry, helper, docker.OriginCredHelper)
+	}
+	for _, connector := range p.baseImageConnectors() {
+		if connector.validate() != nil {
+			// reported when logging in to the base image connectors
+			continue
+		}
+		var err error
+		if connector.Config != "" {
+			err = manager.MergeJSON([]byte(connector.Config), docker.OriginBaseImage)
+		} else {
+			err = manager.AddCredentials(docker.RegistryCredentials{
+				Registry: connector.Registry,
+				Username: connector.Username,
+				Password: connector.Password,
+			}, docker.OriginBaseImage)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("base image connector %s: %w", connector.name(), err)
+		}
+	}
+	// push registry credentials are merged last so they take precedence
+	switch {
+	case p.Login.Password != "":
+		if err := manager.AddCredentials(docker.RegistryCredentials{
+			Registry: p.Login.Registry,
+			Username: p.Login.Username,
+			Password: p.Login.Password,
+		}, docker.OriginPushRegistry); err != nil {
+			return nil, err
+		}
+	case p.Login.AccessToken != "":
+		if err := manager.AddCredentials(docker.RegistryCredentials{
+			Registry: p.Login.Registry,
+			Username: "oauth2accesstoken",
+			Password: p.Login.AccessToken,
+		}, docker.OriginPushRegistry); err != nil {
+			return nil, err
+		}
+	}
+	return manager, nil
 }
 
-// helper function to set the credentials
-func setDockerAuth(username, password, registry, baseImageUsername,
-	baseImagePassword, baseImageRegistry string) ([]byte, error) {
-	var credentials []docker.RegistryCredentials
-	// add only docker registry to the config
-	dockerConfig := docker.NewConfig()
-	if password != "" {
-		pushToRegistryCreds := docker.RegistryCredentials{
-			Registry: registry,
-			Username: username,
-			Password: password,
-		}
-		// push registry auth
-		credentials = append(credentials, pushToRegistryCreds)
-	}
-
-	if baseImageRegistry != "" {
-		pullFromRegistryCreds := docker.RegistryCredentials{
-			Registry: baseImageRegistry,
-			Username: baseImageUsername,
-			Password: baseImagePassword,
-		}
-		// base image registry auth
-		credentials = append(credentials, pullFromRegistryCreds)
-	}
-	// Creates docker config for both the registries used for authentication
-	return dockerConfig.CreateDockerConfigJson(credentials)
+// writeDockerConfig writes the merged docker config to path. The file is left
+// untouched when no source other than the existing file contributed to it.
+func (p Plugin) writeDockerConfig(path string) (*docker.ConfigManager, error) {
+	manager, err := p.dockerConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	var changed bool
+	for _, entry := range manager.Entries() {
+		if entry.Origin == docker.OriginExisting {
+			continue
+		}
+		changed = true
+		if entry.Registry != "" {
+			fmt.Printf("Docker config: %s entry for %s from %s\n", entry.Kind, entry.Registry, entry.Origin)
+		} else {
+			fmt.Printf("Docker config: %s entry from %s\n", entry.Kind, entry.Origin)
+		}
+	}
+	if !changed && p.Login.Config == "" {
+		return manager, nil
+	}
+	return manager, manager.WriteFile(path)
 }
 
 // helper function to create the docker login command.
+// The password is piped through stdin so it never shows up in the process
+// arguments.
 func commandLogin(login Login) *exec.Cmd {
 	if login.Email != "" {
 		return commandLoginEmail(login)
 	}
-	return exec.Command(
+	cmd := exec.Command(
 		dockerExe, "login",
 		"-u", login.Username,
-		"-p", login.Password,
+		"--password-stdin",
 		login.Registry,
 	)
+	cmd.Stdin = strings.NewReader(login.Password)
+	return cmd
 }
 
 func commandLoginAccessToken(login Login, accessToken string) *exec.Cmd {
-	cmd := exec.Command(dockerExe,
-		"login",
-		"-u",
-		"oauth2accesstoken",
-		"--password-stdin",
-		login.Registry)
-	cmd.Stdin = strings.NewReader(accessToken)
-	return cmd
+	return commandLogin(Login{
+		Registry: login.Registry,
+		Username: "oauth2accesstoken",
+		Password: accessToken,
+	})
+}
+
+// retryRateLimited retries a pull or build that failed because of the Docker
+// Hub rate limit through the configured mirror or pull-through cache.
+func (p Plugin) retryRateLimited(cmd *exec.Cmd, err error) error {
+	switch {
+	case isCommandPull(cmd.Args):
+		if mirrorErr := p.pullThroughMirror(cmd.Args[2]); mirrorErr != nil {
+			fmt.Println(mirrorErr)
+			return err
+		}
+		return nil
+	case isCommandBuild(cmd.Args):
+		if p.Build.Builder != "" {
+			// a remote builder does not see the images of the local daemon
+			fmt.Printf("Docker Hub rate limit reached, the build is not retried as builder %s cannot use base images pulled through the mirror.\n", p.Build.Builder)
+			return err
+		}
+		if p.rateLimitMirror() == "" {
+			fmt.Println("Docker Hub rate limit reached and no registry mirror or pull-through cache is configured.")
+			return err
+		}
+		if mirrorErr := p.prefetchBaseImagesThroughMirror(); mirrorErr != nil {
+			fmt.Println(mirrorErr)
+			return err
+		}
+		// the base images are now available locally, do not pull them again
+		build := p.Build
+		build.Pull = false
+		return runPrefixedCommand(commandBuild(build), p.outputPrefix)
+	}
+	return err
+}
+
+// helper to check if args match "docker build" or "docker buildx build"
+func isCommandBuild(args []string) bool {
+	return (len(args) > 1 && args[1] == "build") ||
+		(len(args) > 2 && args[1] == "buildx" && args[2] == "build")
 }
 
 // helper to check if args match "docker pull <image>"
@@ -431,13 +814,15 @@ func commandPull(repo string) *exec.Cmd {
 }
 
 func commandLoginEmail(login Login) *exec.Cmd {
-	return exec.Command(
+	cmd := exec.Command(
 		dockerExe, "login",
 		"-u", login.Username,
-		"-p", login.Password,
+		"--password-stdin",
 		"-e", login.Email,
 		login.Registry,
 	)
+	cmd.Stdin = strings.NewReader(login.Password)
+	return cmd
 }
 
 // helper function to create the docker info command.
@@ -458,8 +843,28 @@ func commandBuild(build Build) *exec.Cmd {
 		"-f", build.Dockerfile,
 		"-t", build.TempTag,
 	}
+	if build.Builder != "" {
+		// builds on a buildx builder must be loaded back into the daemon so
+		// they can be tagged and pushed.
+		args = append([]string{"buildx"}, args...)
+		args = append(args, "--builder", build.Builder, "--load")
+	}
 
 	args = append(args, build.Context)
+	args = append(args, buildFlags(build)...)
+
+	cmd := exec.Command(dockerExe, args...)
+	// we need to enable buildkit, for secret support and ssh agent support.
+	// It is set on the command as the images of a step are built concurrently.
+	if build.Secret != "" || len(build.SecretEnvs) > 0 || len(build.SecretFiles) > 0 || build.SSHAgentKey != "" {
+		cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	}
+	return cmd
+}
+
+// buildFlags returns the flags of the build command following the context.
+func buildFlags(build Build) []string {
+	var args []string
 	if build.Squash {
 		args = append(args, "--squash")
 	}
@@ -516,6 +921,16 @@ func commandBuild(build Build) *exec.Cmd {
 		args = append(args, "--ssh", build.SSHKeyPath)
 	}
 
+	for _, label := range buildLabels(build) {
+		args = append(args, "--label", label)
+	}
+	return args
+}
+
+// buildLabels returns the labels of the image, the auto-labels followed by
+// the custom labels.
+func buildLabels(build Build) []string {
+	var labels []string
 	if build.AutoLabel {
 		labelSchema := []string{
 			fmt.Sprintf("created=%s", time.Now().Format(time.RFC3339)),
@@ -530,21 +945,10 @@ func commandBuild(build Build) *exec.Cmd {
 		}
 
 		for _, label := range labelSchema {
-			args = append(args, "--label", fmt.Sprintf("%s.%s", labelPrefix, label))
-		}
-	}
-
-	if len(build.Labels) > 0 {
-		for _, label := range build.Labels {
-			args = append(args, "--label", label)
+			labels = append(labels, fmt.Sprintf("%s.%s", labelPrefix, label))
 		}
 	}
-
-	// we need to enable buildkit, for secret support and ssh agent support
-	if build.Secret != "" || len(build.SecretEnvs) > 0 || len(build.SecretFiles) > 0 || build.SSHAgentKey != "" {
-		os.Setenv("DOCKER_BUILDKIT", "1")
-	}
-	return exec.Command(dockerExe, args...)
+	return append(labels, build.Labels...)
 }
 
 func getSecretStringCmdArg(kvp string) (string, error) {
@@ -739,6 +1143,11 @@ func commandDaemon(daemon Daemon) *exec.Cmd {
 	return exec.Command(dockerdExe, args...)
 }
 
+// helper to check if args match "docker tag"
+func isCommandTag(args []string) bool {
+	return len(args) > 3 && args[1] == "tag"
+}
+
 // helper to check if args match "docker prune"
 func isCommandPrune(args []string) bool {
 	return len(args) > 3 && args[2] == "prune"
@@ -782,7 +1191,12 @@ func writeSSHPrivateKey(key string) (path string, err error) {
 // trace writes each command to stdout with the command wrapped in an xml
 // tag so that it can be extracted and displayed in the logs.
 func trace(cmd *exec.Cmd) {
-	fmt.Fprintf(os.Stdout, "+ %s\n", strings.Join(cmd.Args, " "))
+	tracePrefixed(cmd, "")
+}
+
+// tracePrefixed is trace with the line starting with prefix.
+func tracePrefixed(cmd *exec.Cmd, prefix string) {
+	fmt.Fprintf(os.Stdout, "%s+ %s\n", prefix, secrets.Redact(strings.Join(cmd.Args, " ")))
 }
 
 func GetDroneDockerExecCmd() string {
@@ -915,17 +1329,27 @@ func createCosignCommand(imageRef string, cosign CosignConfig) *exec.Cmd {
 	return exec.Command(cosignExe, args...)
 }
 
+// sign signs the image reference with cosign and records the outcome.
+func (p Plugin) sign(imageRef string) {
+	start := time.Now()
+	err := executeCosignCommand(createCosignCommand(imageRef, p.Cosign))
+	p.report.recordSigning(imageRef, start, err)
+	p.recordSpan("cosign.sign", start, err, telemetry.AttrImage.String(imageRef))
+}
+
 // executeCosignCommand executes the given cosign command and handles errors
-func executeCosignCommand(cmd *exec.Cmd) {
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	fmt.Printf("🚀 Executing: %s %s\n", cmd.Path, strings.Join(cmd.Args[1:], " "))
+func executeCosignCommand(cmd *exec.Cmd) error {
+	flush := attachOutput(cmd)
+	defer flush()
+	fmt.Printf("🚀 Executing: %s\n", secrets.Redact(cmd.Path+" "+strings.Join(cmd.Args[1:], " ")))
 
-	if err := cmd.Run(); err != nil {
+	err := cmd.Run()
+	if err != nil {
 		fmt.Printf("⚠️  WARNING: Image signing failed: %s\n", err)
 		fmt.Printf("   Image was pushed successfully but could not be signed\n")
 		fmt.Printf("   This is not fatal - continuing with the build\n")
 	}
+	return err
 }
 
 // pushOnly handles pushing images without building them
@@ -995,10 +1419,10 @@ func (p Plugin) pushOnly() error {
 				// Tag the source image with the target name
 				fmt.Printf("Tagging %s as %s\n", sourceFullImageName, targetFullImageName)
 				tagCmd := exec.Command(dockerExe, "tag", sourceFullImageName, targetFullImageName)
-				tagCmd.Stdout = os.Stdout
-				tagCmd.Stderr = os.Stderr
-				trace(tagCmd)
-				if err := tagCmd.Run(); err != nil {
+				start := time.Now()
+				err := runCommand(tagCmd)
+				p.recordTagSpan(targetFullImageName, start, err)
+				if err != nil {
 					return fmt.Errorf("failed to tag image %s as %s: %w", sourceFullImageName, targetFullImageName, err)
 				}
 			}
@@ -1017,10 +1441,7 @@ func (p Plugin) pushOnly() error {
 		// Push image
 		fmt.Println("Pushing image:", fullImageName)
 		pushCmd := commandPush(p.Build, tag)
-		pushCmd.Stdout = os.Stdout
-		pushCmd.Stderr = os.Stderr
-		trace(pushCmd)
-		if err := pushCmd.Run(); err != nil {
+		if err := p.push(pushCmd); err != nil {
 			return fmt.Errorf("failed to push image %s: %w", fullImageName, err)
 		}
 
@@ -1047,6 +1468,11 @@ func (p Plugin) pushOnly() error {
 		}
 	}
 
+	// export the digest and image references to the later steps
+	if firstPushedImage != "" {
+		p.exportOutputs(p.Build.Tags, digest)
+	}
+
 	// Write to artifact file
 	if p.ArtifactFile != "" && digest != "" {
 		if err := drone.WritePluginArtifactFile(
@@ -1072,8 +1498,7 @@ func (p Plugin) pushOnly() error {
 
 			// Sign with digest reference
 			imageRef := fmt.Sprintf("%s@%s", p.Build.Repo, digest)
-			cosignCmd := createCosignCommand(imageRef, p.Cosign)
-			executeCosignCommand(cosignCmd)
+			p.sign(imageRef)
 		} else {
 			fmt.Printf("⚠️  WARNING: Could not get image digest for cosign signing\n")
 			fmt.Printf("   Falling back to tag-based signing\n")
@@ -1081,8 +1506,7 @@ func (p Plugin) pushOnly() error {
 			// Fall back to tag-based signing for each tag
 			for _, tag := range p.Build.Tags {
 				imageRef := fmt.Sprintf("%s:%s", p.Build.Repo, tag)
-				cosignCmd := createCosignCommand(imageRef, p.Cosign)
-				executeCosignCommand(cosignCmd)
+				p.sign(imageRef)
 			}
 		}
 	}
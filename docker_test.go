@@ -1,13 +1,19 @@
 package docker
 
 import (
+	"encoding/json"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/dchest/uniuri"
+
+	"github.com/drone-plugins/drone-docker/internal/docker"
 )
 
 func TestCommandBuild(t *testing.T) {
@@ -166,6 +172,30 @@ func TestCommandBuild(t *testing.T) {
 				"--ssh id_rsa=/root/.ssh/id_rsa",
 			),
 		},
+		{
+			name: "remote builder",
+			build: Build{
+				Name:       "plugins/drone-docker:latest",
+				TempTag:    tempTag,
+				Dockerfile: "Dockerfile",
+				Context:    ".",
+				Builder:    "drone-remote",
+			},
+			want: exec.Command(
+				dockerExe,
+				"buildx",
+				"build",
+				"--rm=true",
+				"-f",
+				"Dockerfile",
+				"-t",
+				tempTag,
+				"--builder",
+				"drone-remote",
+				"--load",
+				".",
+			),
+		},
 	}
 
 	for _, tc := range tcs {
@@ -267,3 +297,114 @@ func TestGetProxyValue(t *testing.T) {
 		})
 	}
 }
+
+func TestDockerConfigMerge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"auths":{"ghcr.io":{"auth":"Z2g6dG9rZW4="}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := Plugin{
+		Login: Login{
+			Registry:    "gcr.io",
+			Username:    "_json_key",
+			Password:    "push-secret",
+			Config:      `{"auths":{"gcr.io":{"auth":"b2xkOm9sZA=="}}}`,
+			CredHelpers: []string{"123.dkr.ecr.us-east-1.amazonaws.com=ecr-login"},
+		},
+		BaseImageRegistry: "quay.io",
+		BaseImageUsername: "bot",
+		BaseImagePassword: "pull-secret",
+	}
+	manager, err := p.writeDockerConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := map[string]docker.Origin{
+		"ghcr.io": docker.OriginExisting,
+		"gcr.io":  docker.OriginPushRegistry,
+		"quay.io": docker.OriginBaseImage,
+	}
+	for registry, origin := range want {
+		if got, _ := manager.Origin(docker.KindAuth, registry); got != origin {
+			t.Errorf("Got origin %s for %s, want %s", got, registry, origin)
+		}
+	}
+	if got, _ := manager.Origin(docker.KindCredHelper, "123.dkr.ecr.us-east-1.amazonaws.com"); got != docker.OriginCredHelper {
+		t.Errorf("Got origin %s for cred helper, want %s", got, docker.OriginCredHelper)
+	}
+
+	var written docker.Config
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if len(written.Auths) != 3 {
+		t.Errorf("Got %d auths, want 3", len(written.Auths))
+	}
+}
+
+func TestDockerConfigInvalidCredHelper(t *testing.T) {
+	p := Plugin{Login: Login{CredHelpers: []string{"gcr.io"}}}
+	if _, err := p.dockerConfig(filepath.Join(t.TempDir(), "config.json")); err == nil {
+		t.Errorf("expected an error for an invalid credential helper")
+	}
+}
+
+func TestCommandLogin(t *testing.T) {
+	tcs := []struct {
+		name     string
+		cmd      *exec.Cmd
+		want     *exec.Cmd
+		password string
+	}{
+		{
+			name:     "username and password",
+			cmd:      commandLogin(Login{Registry: "quay.io", Username: "bot", Password: "secret"}),
+			want:     exec.Command(dockerExe, "login", "-u", "bot", "--password-stdin", "quay.io"),
+			password: "secret",
+		},
+		{
+			name:     "email",
+			cmd:      commandLogin(Login{Registry: "registry.heroku.com", Username: "me@example.com", Password: "key", Email: "me@example.com"}),
+			want:     exec.Command(dockerExe, "login", "-u", "me@example.com", "--password-stdin", "-e", "me@example.com", "registry.heroku.com"),
+			password: "key",
+		},
+		{
+			name:     "access token",
+			cmd:      commandLoginAccessToken(Login{Registry: "gcr.io"}, "ya29.token"),
+			want:     exec.Command(dockerExe, "login", "-u", "oauth2accesstoken", "--password-stdin", "gcr.io"),
+			password: "ya29.token",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.cmd.String() != tc.want.String() {
+				t.Errorf("Got cmd %v, want %v", tc.cmd, tc.want)
+			}
+			if strings.Contains(tc.cmd.String(), tc.password) {
+				t.Errorf("password must not be passed as an argument")
+			}
+			stdin, _ := io.ReadAll(tc.cmd.Stdin)
+			if string(stdin) != tc.password {
+				t.Errorf("Got stdin %q, want %q", stdin, tc.password)
+			}
+		})
+	}
+}
+
+func TestCommandBuildBuildkitEnv(t *testing.T) {
+	os.Unsetenv("DOCKER_BUILDKIT")
+	cmd := commandBuild(Build{Dockerfile: "Dockerfile", Context: ".", SecretEnvs: []string{"token=GITHUB_TOKEN"}})
+	if !slices.Contains(cmd.Env, "DOCKER_BUILDKIT=1") {
+		t.Errorf("Expected buildkit to be enabled on the command, got %v", cmd.Env)
+	}
+	if os.Getenv("DOCKER_BUILDKIT") != "" {
+		t.Error("Expected the process environment to be left untouched")
+	}
+	if cmd := commandBuild(Build{Dockerfile: "Dockerfile", Context: "."}); cmd.Env != nil {
+		t.Errorf("Expected the inherited environment, got %v", cmd.Env)
+	}
+}
@@ -0,0 +1,111 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+// dockerignore matches the paths of a build context against the patterns of
+// a .dockerignore file with the matcher BuildKit uses, the last matching
+// pattern deciding.
+type dockerignore struct {
+	matcher *patternmatcher.PatternMatcher
+}
+
+// readDockerignore reads the ignore file of the build, the Dockerfile
+// specific <Dockerfile>.dockerignore or the .dockerignore of the context.
+// The context is not filtered when neither exists.
+func readDockerignore(context, dockerfile string) (*dockerignore, error) {
+	files := []string{filepath.Join(context, ".dockerignore")}
+	if dockerfile != "" {
+		files = append([]string{dockerfile + ".dockerignore"}, files...)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", file, err)
+		}
+		return parseDockerignore(string(data))
+	}
+	return parseDockerignore("")
+}
+
+// parseDockerignore parses the patterns of a .dockerignore file.
+func parseDockerignore(data string) (*dockerignore, error) {
+	patterns, err := ignorefile.ReadAll(strings.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := patternmatcher.New(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid .dockerignore pattern: %w", err)
+	}
+	return &dockerignore{matcher: matcher}, nil
+}
+
+// ignored reports whether the context path, relative and slash separated,
+// is left out of the build context. A path is matched by a pattern when the
+// path or one of its parent directories matches.
+func (d *dockerignore) ignored(rel string) bool {
+	ignored, _, err := d.matcher.MatchesUsingParentResults(rel, patternmatcher.MatchInfo{})
+	return err == nil && ignored
+}
+
+// hasExclusions reports whether a pattern re-includes paths, in which case
+// the files of ignored directories may still be sent.
+func (d *dockerignore) hasExclusions() bool {
+	return d.matcher.Exclusions()
+}
+
+// contextFile is a file of the build context sent to the builder.
+type contextFile struct {
+	Path string      // Path relative to the context, slash separated
+	Info fs.FileInfo // File information, not following symlinks
+}
+
+// walkContext returns the files and symlinks of the build context that
+// survive the ignore patterns, sorted by path.
+func walkContext(context string, ignore *dockerignore) ([]contextFile, error) {
+	var files []contextFile
+	err := filepath.WalkDir(context, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(context, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if ignore.ignored(rel) {
+			if d.IsDir() && !ignore.hasExclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, contextFile{Path: rel, Info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to walk the build context: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
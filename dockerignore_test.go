@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDockerignore(t *testing.T) {
+	ignore, err := parseDockerignore(`
+# comment
+.git
+*.log
+!important.log
+**/node_modules
+/tmp/*
+docs/**/*.md
+!docs/README.md
+file[0-9].txt
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcs := []struct {
+		path string
+		want bool
+	}{
+		{".git", true},
+		{".git/config", true},
+		{"app.log", true},
+		{"important.log", false},
+		{"logs/app.log", false},
+		{"node_modules/left-pad/index.js", true},
+		{"web/node_modules/left-pad/index.js", true},
+		{"tmp/cache", true},
+		{"tmp", false},
+		{"docs/guide.md", true},
+		{"docs/api/v1/index.md", true},
+		{"docs/README.md", false},
+		{"file1.txt", true},
+		{"fileA.txt", false},
+		{"main.go", false},
+	}
+	for _, tc := range tcs {
+		if got := ignore.ignored(tc.path); got != tc.want {
+			t.Errorf("ignored(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+
+	if _, err := parseDockerignore("file[0-9.txt"); err == nil {
+		t.Error("Expected an error for an unterminated character class")
+	}
+	if _, err := parseDockerignore("!"); err == nil {
+		t.Error("Expected an error for an empty exclusion")
+	}
+}
+
+func TestWalkContext(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		".dockerignore":           "node_modules\n*.log\n",
+		"Dockerfile":              "FROM scratch\n",
+		"main.go":                 "package main\n",
+		"app.log":                 "log\n",
+		"node_modules/x/index.js": "x\n",
+		"cmd/app/main.go":         "package main\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ignore, err := readDockerignore(dir, filepath.Join(dir, "Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := walkContext(dir, ignore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	want := []string{".dockerignore", "Dockerfile", "cmd/app/main.go", "main.go"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Expected context files %v, got %v", want, paths)
+	}
+
+	// the Dockerfile specific ignore file takes precedence
+	os.WriteFile(filepath.Join(dir, "Dockerfile.dockerignore"), []byte("cmd\n"), 0644)
+	ignore, err = readDockerignore(dir, filepath.Join(dir, "Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ignore.ignored("cmd/app/main.go") || ignore.ignored("app.log") {
+		t.Error("Expected the patterns of Dockerfile.dockerignore")
+	}
+}
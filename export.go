@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
+)
+
+// Build output types.
+const (
+	exportLocal = "local"
+	exportTar   = "tar"
+)
+
+// BuildOutput defines the files exported from a build stage with the
+// BuildKit local or tar exporter.
+type BuildOutput struct {
+	Type   string // Exporter type, local or tar
+	Dest   string // Destination directory (local) or archive (tar)
+	Target string // Build stage exported, the build target by default
+	Only   bool   // The image is neither built nor pushed
+}
+
+// enabled reports whether build outputs are exported.
+func (o BuildOutput) enabled() bool {
+	return o.Dest != ""
+}
+
+// exporter returns the exporter type, local by default.
+func (o BuildOutput) exporter() string {
+	if o.Type == "" {
+		return exportLocal
+	}
+	return o.Type
+}
+
+// helper function to create the docker buildx build command exporting the
+// files of the output stage. The build settings are the image build ones.
+func commandExport(build Build, output BuildOutput) *exec.Cmd {
+	if output.Target != "" {
+		build.Target = output.Target
+	}
+	// the image settings do not apply to exported files
+	build.Squash = false
+	build.Compress = false
+
+	args := []string{"buildx", "build", "-f", build.Dockerfile}
+	if build.Builder != "" {
+		args = append(args, "--builder", build.Builder)
+	}
+	args = append(args, "--output", fmt.Sprintf("type=%s,dest=%s", output.exporter(), output.Dest))
+	args = append(args, build.Context)
+	args = append(args, buildFlags(build)...)
+	return exec.Command(dockerExe, args...)
+}
+
+// exportBuild exports the files of the output stage and records them in the
+// run report.
+func (p Plugin) exportBuild() error {
+	start := time.Now()
+	err := runCommand(commandExport(p.Build, p.Export))
+	var files []string
+	if err == nil {
+		files, err = exportedFiles(p.Export)
+	}
+	p.report.recordExport(p.Export, start, files, err)
+	p.recordSpan("docker.export", start, err, telemetry.AttrRepo.String(p.Build.Repo))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d file(s) to %s\n", len(files), p.Export.Dest)
+	return nil
+}
+
+// exportedFiles lists the exported files, relative to the destination
+// directory or as named in the archive.
+func exportedFiles(output BuildOutput) ([]string, error) {
+	if output.exporter() == exportTar {
+		return tarFiles(output.Dest)
+	}
+	var files []string
+	err := filepath.WalkDir(output.Dest, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(output.Dest, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the exported files: %w", err)
+	}
+	return files, nil
+}
+
+// tarFiles lists the regular files of the archive at path.
+func tarFiles(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the exported files: %w", err)
+	}
+	defer f.Close()
+
+	var files []string
+	reader := tar.NewReader(f)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the exported files: %w", err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			files = append(files, header.Name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
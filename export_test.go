@@ -0,0 +1,162 @@
+package docker
+
+import (
+	"archive/tar"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandExport(t *testing.T) {
+	build := Build{
+		Dockerfile: "Dockerfile",
+		Context:    ".",
+		Target:     "prod",
+		Squash:     true,
+		Args:       []string{"VERSION=1.0"},
+	}
+	tcs := []struct {
+		name   string
+		build  Build
+		output BuildOutput
+		want   *exec.Cmd
+	}{
+		{
+			name:   "local",
+			build:  build,
+			output: BuildOutput{Dest: "dist", Target: "binaries"},
+			want: exec.Command(dockerExe, "buildx", "build", "-f", "Dockerfile",
+				"--output", "type=local,dest=dist", ".",
+				"--build-arg", "VERSION=1.0",
+				"--target", "binaries",
+			),
+		},
+		{
+			name: "tar on a builder",
+			build: func() Build {
+				b := build
+				b.Builder = "drone-remote"
+				return b
+			}(),
+			output: BuildOutput{Type: "tar", Dest: "dist/out.tar"},
+			want: exec.Command(dockerExe, "buildx", "build", "-f", "Dockerfile", "--builder", "drone-remote",
+				"--output", "type=tar,dest=dist/out.tar", ".",
+				"--build-arg", "VERSION=1.0",
+				"--target", "prod",
+			),
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := commandExport(tc.build, tc.output)
+			if !reflect.DeepEqual(cmd.Args, tc.want.Args) {
+				t.Errorf("Got cmd %v, want %v", cmd.Args, tc.want.Args)
+			}
+		})
+	}
+}
+
+func TestExportedFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"bin/app", "bin/tool", "README"} {
+		path := filepath.Join(dir, "local", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := exportedFiles(BuildOutput{Dest: filepath.Join(dir, "local")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"README", "bin/app", "bin/tool"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("Got local files %v, want %v", files, want)
+	}
+
+	archive := filepath.Join(dir, "out.tar")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tar.NewWriter(f)
+	w.WriteHeader(&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, name := range []string{"bin/app", "LICENSE"} {
+		w.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(name))})
+		w.Write([]byte(name))
+	}
+	w.Close()
+	f.Close()
+
+	files, err = exportedFiles(BuildOutput{Type: "tar", Dest: archive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"LICENSE", "bin/app"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("Got archive files %v, want %v", files, want)
+	}
+
+	if _, err := exportedFiles(BuildOutput{Type: "tar", Dest: filepath.Join(dir, "missing.tar")}); err == nil {
+		t.Errorf("Expected an error for a missing archive")
+	}
+}
+
+func TestRecordExport(t *testing.T) {
+	p := Plugin{ReportFile: "report.json", Export: BuildOutput{Dest: "dist", Only: true}}
+	report := p.newRunReport()
+	report.recordExport(p.Export, time.Now(), nil, nil)
+	if report.Mode != "export" {
+		t.Errorf("Got mode %s, want export", report.Mode)
+	}
+	want := &reportExport{Type: "local", Dest: "dist", Files: []string{}}
+	if !reflect.DeepEqual(report.Export, want) {
+		t.Errorf("Got export %+v, want %+v", report.Export, want)
+	}
+}
+
+func TestValidateExport(t *testing.T) {
+	tcs := []struct {
+		name   string
+		plugin Plugin
+		err    string
+	}{
+		{
+			name:   "export only needs no repo",
+			plugin: Plugin{Export: BuildOutput{Dest: "dist", Only: true}, Build: Build{Tags: []string{"latest"}}},
+		},
+		{
+			name:   "missing destination",
+			plugin: Plugin{Export: BuildOutput{Only: true}, Build: Build{Repo: "octocat/app"}},
+			err:    "output_dest (PLUGIN_OUTPUT_DEST): is required",
+		},
+		{
+			name:   "invalid type",
+			plugin: Plugin{Export: BuildOutput{Dest: "dist", Type: "oci"}, Build: Build{Repo: "octocat/app"}},
+			err:    "invalid type oci",
+		},
+		{
+			name:   "push only",
+			plugin: Plugin{PushOnly: true, Export: BuildOutput{Dest: "dist"}, Build: Build{Repo: "octocat/app"}},
+			err:    "cannot be combined with push_only",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.plugin.validate().Err()
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("Unexpected error %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Expected an error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
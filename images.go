@@ -0,0 +1,260 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/drone-plugins/drone-plugin-lib/drone"
+)
+
+// Image defines one of the images built by a multi-image step. Unset fields
+// inherit the build settings of the step, and args are added to the step
+// build args.
+type Image struct {
+	Name       string            `json:"name" yaml:"name"`             // Name used in logs, cards and outputs
+	Dockerfile string            `json:"dockerfile" yaml:"dockerfile"` // Dockerfile path
+	Context    string            `json:"context" yaml:"context"`       // Build context
+	Target     string            `json:"target" yaml:"target"`         // Build stage to target
+	Args       map[string]string `json:"args" yaml:"args"`             // Build arguments
+	Repo       string            `json:"repo" yaml:"repo"`             // Repository the image is pushed to
+	Tags       []string          `json:"tags" yaml:"tags"`             // Image tags
+}
+
+// ParseImages parses a JSON list of images.
+func ParseImages(data string) ([]Image, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var images []Image
+	if err := json.Unmarshal([]byte(data), &images); err != nil {
+		return nil, fmt.Errorf("invalid images: %w", err)
+	}
+	return images, nil
+}
+
+// imagePlugin returns a copy of the plugin building the i-th image, with the
+// image settings applied over the step build settings.
+func (p Plugin) imagePlugin(i int) Plugin {
+	image := p.Images[i]
+	build := p.Build
+	build.TempTag = fmt.Sprintf("%s-%d", p.Build.TempTag, i)
+	if image.Dockerfile != "" {
+		build.Dockerfile = image.Dockerfile
+	}
+	if image.Context != "" {
+		build.Context = image.Context
+	}
+	if image.Target != "" {
+		build.Target = image.Target
+	}
+	if image.Repo != "" {
+		build.Repo = image.Repo
+	}
+	if len(image.Tags) > 0 {
+		build.Tags = image.Tags
+	}
+
+	// append the image args after the step args so they take precedence
+	keys := make([]string, 0, len(image.Args))
+	for key := range image.Args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	build.Args = append([]string{}, build.Args...)
+	build.ArgsNew = append([]string{}, build.ArgsNew...)
+	for _, key := range keys {
+		arg := key + "=" + image.Args[key]
+		if build.IsMultipleBuildArgs {
+			build.ArgsNew = append(build.ArgsNew, arg)
+		} else {
+			build.Args = append(build.Args, arg)
+		}
+	}
+
+	ip := p
+	ip.Build = build
+	ip.Images = nil
+	ip.imageName = p.imageNameAt(i)
+	ip.CardPath = imageCardPath(p.CardPath, ip.imageName)
+	return ip
+}
+
+// imageNameAt returns the name of the i-th image, defaulting to the last
+// element of its repository.
+func (p Plugin) imageNameAt(i int) string {
+	image := p.Images[i]
+	if image.Name != "" {
+		return image.Name
+	}
+	repo := image.Repo
+	if repo == "" {
+		repo = p.Build.Repo
+	}
+	if repo == "" {
+		return fmt.Sprintf("image-%d", i+1)
+	}
+	return repo[strings.LastIndex(repo, "/")+1:]
+}
+
+// imageCardPath returns the card path of the named image, the step card
+// path suffixed with the image name. Cards streamed to stdout or stderr
+// share the stream.
+func imageCardPath(path, name string) string {
+	if path == "" || strings.HasPrefix(path, "/dev/") {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + name + ext
+}
+
+// imageResult is the outcome of building one image of a multi-image step.
+type imageResult struct {
+	name   string
+	repo   string
+	tags   []string
+	digest string
+	err    error
+}
+
+// buildImages builds, tags and pushes every image of a multi-image step,
+// running up to Concurrency builds at once. Every image is attempted and the
+// failures are reported together once all builds are over.
+func (p Plugin) buildImages() error {
+	concurrency := p.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	fmt.Printf("Building %d images, %d at a time\n", len(p.Images), concurrency)
+
+	results := make([]imageResult, len(p.Images))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range p.Images {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			ip := p.imagePlugin(i)
+			if concurrency > 1 {
+				// tell apart the interleaved output of the builds
+				ip.outputPrefix = "[" + ip.imageName + "] "
+			}
+			results[i] = ip.buildImage()
+		}(i)
+	}
+	wg.Wait()
+
+	var errs []error
+	var artifacts []drone.Image
+	for _, result := range results {
+		if result.err != nil {
+			errs = append(errs, fmt.Errorf("image %s: %w", result.name, result.err))
+			continue
+		}
+		for _, tag := range result.tags {
+			artifacts = append(artifacts, drone.Image{
+				Image:  fmt.Sprintf("%s:%s", result.repo, tag),
+				Digest: result.digest,
+			})
+		}
+	}
+
+	if p.ArtifactFile != "" && !p.Dryrun && len(artifacts) > 0 {
+		if err := writeArtifactFile(p.Daemon.RegistryType, p.ArtifactFile, p.Daemon.Registry, artifacts); err != nil {
+			fmt.Printf("failed to write plugin artifact file at path: %s with error: %s\n", p.ArtifactFile, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// buildImage builds, tags and pushes the image of a multi-image step, then
+// writes its card and outputs and signs it.
+func (p Plugin) buildImage() imageResult {
+	result := imageResult{name: p.imageName, repo: p.Build.Repo, tags: p.Build.Tags}
+	fmt.Printf("Building image %s\n", p.imageName)
+	if p.ContextCheck.enabled() {
+		if result.err = p.checkContext(); result.err != nil {
+			return result
+		}
+	}
+	if result.err = p.runCommands(p.imageCommands()); result.err != nil {
+		return result
+	}
+
+	if err := p.writeCard(); err != nil {
+		fmt.Printf("Could not create adaptive card for image %s. %s\n", p.imageName, err)
+	}
+	if !p.Dryrun {
+		result.digest, _ = getDigest(p.Build.TempTag)
+	}
+	p.exportOutputs(p.Build.Tags, result.digest)
+	if p.shouldSignWithCosign() && !p.Dryrun {
+		p.signImage()
+	}
+	return result
+}
+
+// prefixLines returns a writer relaying to out with every line starting
+// with prefix.
+func prefixLines(out io.Writer, prefix string) io.Writer {
+	if prefix == "" {
+		return out
+	}
+	return &prefixWriter{out: out, prefix: prefix}
+}
+
+type prefixWriter struct {
+	out    io.Writer
+	prefix string
+	inLine bool // The last write ended in the middle of a line
+}
+
+// Write relays b in a single write, so that the lines of concurrent writers
+// are not mixed up.
+func (w *prefixWriter) Write(b []byte) (int, error) {
+	var buf []byte
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !w.inLine {
+			buf = append(buf, w.prefix...)
+		}
+		buf = append(buf, line...)
+		w.inLine = line[len(line)-1] != '\n'
+	}
+	if _, err := w.out.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeArtifactFile writes the docker artifact file listing images, in the
+// format of drone.WritePluginArtifactFile.
+func writeArtifactFile(registryType drone.RegistryType, path, registryURL string, images []drone.Image) error {
+	artifact := drone.DockerArtifact{
+		Kind: "docker/v1",
+		Data: drone.Data{
+			RegistryType: registryType,
+			RegistryURL:  registryURL,
+			Images:       images,
+		},
+	}
+	data, err := json.MarshalIndent(artifact, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("unable to create artifact directory: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
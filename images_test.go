@@ -0,0 +1,189 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drone-plugins/drone-plugin-lib/drone"
+)
+
+func TestParseImages(t *testing.T) {
+	images, err := ParseImages(`[{"name":"api","dockerfile":"api/Dockerfile","args":{"A":"1"},"tags":["v1"]}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Image{{Name: "api", Dockerfile: "api/Dockerfile", Args: map[string]string{"A": "1"}, Tags: []string{"v1"}}}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("Got images %+v, want %+v", images, want)
+	}
+	if images, err := ParseImages(" "); err != nil || images != nil {
+		t.Errorf("Expected no images, got %v and %v", images, err)
+	}
+	if _, err := ParseImages("{"); err == nil {
+		t.Errorf("Expected an error for invalid JSON")
+	}
+}
+
+func TestImagePlugin(t *testing.T) {
+	p := Plugin{
+		CardPath: "/tmp/cards/card.json",
+		Build: Build{
+			Dockerfile: "Dockerfile",
+			Context:    ".",
+			TempTag:    "abc123",
+			Repo:       "quay.io/octocat/hello",
+			Tags:       []string{"latest"},
+			Args:       []string{"VERSION=1.0"},
+		},
+		Images: []Image{
+			{Name: "api", Dockerfile: "api/Dockerfile", Context: "api", Args: map[string]string{"B": "2", "A": "1"}},
+			{Repo: "quay.io/octocat/worker", Target: "prod", Tags: []string{"v1", "v1.0"}},
+		},
+	}
+
+	api := p.imagePlugin(0)
+	if api.imageName != "api" || api.Build.TempTag != "abc123-0" || api.CardPath != "/tmp/cards/card-api.json" {
+		t.Errorf("Unexpected image %s with temp tag %s and card %s", api.imageName, api.Build.TempTag, api.CardPath)
+	}
+	if api.Build.Dockerfile != "api/Dockerfile" || api.Build.Context != "api" || api.Build.Repo != "quay.io/octocat/hello" {
+		t.Errorf("Unexpected build settings %+v", api.Build)
+	}
+	if want := []string{"VERSION=1.0", "A=1", "B=2"}; !reflect.DeepEqual(api.Build.Args, want) {
+		t.Errorf("Got build args %v, want %v", api.Build.Args, want)
+	}
+	if len(api.Images) != 0 {
+		t.Errorf("An image plugin should not build other images")
+	}
+
+	worker := p.imagePlugin(1)
+	if worker.imageName != "worker" || worker.Build.Dockerfile != "Dockerfile" || worker.Build.Target != "prod" {
+		t.Errorf("Unexpected image %s with %+v", worker.imageName, worker.Build)
+	}
+	if !reflect.DeepEqual(worker.Build.Tags, []string{"v1", "v1.0"}) || !reflect.DeepEqual(worker.Build.Args, []string{"VERSION=1.0"}) {
+		t.Errorf("Unexpected tags %v and args %v", worker.Build.Tags, worker.Build.Args)
+	}
+	if !reflect.DeepEqual(p.Build.Args, []string{"VERSION=1.0"}) {
+		t.Errorf("The step build args should not change, got %v", p.Build.Args)
+	}
+}
+
+func TestImageCardPath(t *testing.T) {
+	tcs := []struct {
+		path, want string
+	}{
+		{"/harness/card.json", "/harness/card-api.json"},
+		{"card", "card-api"},
+		{"/dev/stdout", "/dev/stdout"},
+		{"", ""},
+	}
+	for _, tc := range tcs {
+		if got := imageCardPath(tc.path, "api"); got != tc.want {
+			t.Errorf("imageCardPath(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := prefixLines(&buf, "[api] ")
+	w.Write([]byte("#1 building\n#2 load"))
+	w.Write([]byte("ing\n\n#3 done\n"))
+	want := "[api] #1 building\n[api] #2 loading\n[api] \n[api] #3 done\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Got output %q, want %q", got, want)
+	}
+	if w := prefixLines(&buf, ""); w != &buf {
+		t.Error("Expected the writer to be returned as is without a prefix")
+	}
+}
+
+func TestWriteArtifactFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artifacts", "docker.json")
+	images := []drone.Image{
+		{Image: "quay.io/octocat/api:latest", Digest: "sha256:abc"},
+		{Image: "quay.io/octocat/worker:latest", Digest: "sha256:def"},
+	}
+	if err := writeArtifactFile(drone.Docker, path, "quay.io", images); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var artifact drone.DockerArtifact
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		t.Fatal(err)
+	}
+	if artifact.Kind != "docker/v1" || artifact.Data.RegistryURL != "quay.io" || !reflect.DeepEqual(artifact.Data.Images, images) {
+		t.Errorf("Unexpected artifact %s", data)
+	}
+}
+
+func TestValidateImages(t *testing.T) {
+	dir := t.TempDir()
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	if err := os.WriteFile(dockerfile, []byte("FROM alpine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := Plugin{
+		// the step dockerfile and repo are not needed when every image sets them
+		Build: Build{Dockerfile: filepath.Join(dir, "missing"), Tags: []string{"latest"}},
+		Images: []Image{
+			{Name: "api", Dockerfile: dockerfile, Repo: "octocat/api"},
+			{Name: "api", Dockerfile: filepath.Join(dir, "worker.Dockerfile"), Repo: "octocat/worker"},
+			{Name: "cli", Dockerfile: dockerfile},
+		},
+		Concurrency: -1,
+	}
+	err := p.validate().Err()
+	if err == nil {
+		t.Fatal("Expected errors")
+	}
+	for _, want := range []string{"PLUGIN_CONCURRENCY", "api is used more than once", "worker.Dockerfile", "image cli has no repo"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected an error mentioning %q, got %s", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "PLUGIN_DOCKERFILE") || strings.Contains(err.Error(), "PLUGIN_REPO") {
+		t.Errorf("Step build settings should not be checked for a multi-image step, got %s", err)
+	}
+}
+
+func TestExecutionPlanImages(t *testing.T) {
+	p := Plugin{
+		Build: Build{Dockerfile: "Dockerfile", Context: ".", TempTag: "abc123", Tags: []string{"latest"}},
+		Images: []Image{
+			{Name: "api", Dockerfile: "api/Dockerfile", Context: "api", Repo: "octocat/api"},
+			{Name: "worker", Repo: "octocat/worker", Tags: []string{"v1"}},
+		},
+	}
+	plan := p.executionPlan()
+	if plan.Build != nil || len(plan.Images) != 2 {
+		t.Fatalf("Expected a plan of two images, got %+v", plan)
+	}
+	if want := []string{"octocat/api:latest", "octocat/worker:v1"}; !reflect.DeepEqual(plan.Destinations, want) {
+		t.Errorf("Got destinations %v, want %v", plan.Destinations, want)
+	}
+	if plan.Images[0].Build.Dockerfile != "api/Dockerfile" || plan.Images[1].Build.Dockerfile != "Dockerfile" {
+		t.Errorf("Unexpected image builds %+v, %+v", plan.Images[0].Build, plan.Images[1].Build)
+	}
+	if len(plan.Commands) != 6 {
+		t.Errorf("Expected a build, tag and push per image, got %v", plan.Commands)
+	}
+}
+
+func TestImageOutputsPrefix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output.env")
+	p := Plugin{OutputFile: path, Build: Build{Repo: "octocat/api"}, imageName: "api-server"}
+	p.exportOutputs([]string{"latest"}, "sha256:abc")
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "API_SERVER_IMAGE_DIGEST=sha256:abc\n") {
+		t.Errorf("Expected outputs prefixed with the image name, got:\n%s", data)
+	}
+}
@@ -15,12 +15,16 @@ const (
 
 type (
 	Auth struct {
-		Auth string `json:"auth"`
+		Auth          string `json:"auth"`
+		Email         string `json:"email,omitempty"`
+		IdentityToken string `json:"identitytoken,omitempty"`
+		RegistryToken string `json:"registrytoken,omitempty"`
 	}
 
 	Config struct {
 		Auths       map[string]Auth   `json:"auths"`
 		CredHelpers map[string]string `json:"credHelpers,omitempty"`
+		CredsStore  string            `json:"credsStore,omitempty"`
 	}
 )
 
@@ -0,0 +1,179 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// CredentialHelperPrefix is the executable name prefix docker uses to find
+// credential helpers, e.g. docker-credential-drone-ecr.
+const CredentialHelperPrefix = "docker-credential-"
+
+// CredentialHelpersEnv lists the credential helpers registered in the docker
+// config by drone-docker, as registry=helper pairs.
+const CredentialHelpersEnv = "PLUGIN_CRED_HELPERS"
+
+// CredentialProviderEnv names the credential helper drone-docker uses to
+// refresh expired registry credentials.
+const CredentialProviderEnv = "PLUGIN_CREDENTIAL_PROVIDER"
+
+// HelperCredentials is the payload exchanged with docker by a credential
+// helper.
+type HelperCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// CredentialsFunc mints credentials for the given registry server URL.
+type CredentialsFunc func(serverURL string) (username, secret string, err error)
+
+// IsCredentialHelper reports whether the process was invoked by docker as a
+// credential helper.
+func IsCredentialHelper(args []string) bool {
+	return len(args) > 0 && strings.HasPrefix(filepath.Base(args[0]), CredentialHelperPrefix)
+}
+
+// RunCredentialHelper serves a single credential helper request from docker
+// on stdin and stdout. Anything the credential code prints to stdout is
+// diverted to stderr to keep the helper protocol intact.
+func RunCredentialHelper(args []string, get CredentialsFunc) error {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = out }()
+
+	return ServeCredentialHelper(args[1:], os.Stdin, out, get)
+}
+
+// ServeCredentialHelper implements the docker credential helper protocol.
+// Credentials are minted on every get; store and erase are accepted and
+// ignored since the credentials are never persisted.
+func ServeCredentialHelper(args []string, in io.Reader, out io.Writer, get CredentialsFunc) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s<name> get|store|erase|list", CredentialHelperPrefix)
+	}
+	switch args[0] {
+	case "get":
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		serverURL := strings.TrimSpace(string(data))
+		username, secret, err := get(serverURL)
+		if err != nil {
+			return fmt.Errorf("failed to get credentials for %s: %w", serverURL, err)
+		}
+		return json.NewEncoder(out).Encode(HelperCredentials{
+			ServerURL: serverURL,
+			Username:  username,
+			Secret:    secret,
+		})
+	case "store", "erase":
+		_, err := io.Copy(io.Discard, in)
+		return err
+	case "list":
+		_, err := io.WriteString(out, "{}\n")
+		return err
+	}
+	return fmt.Errorf("unknown credential helper action %s", args[0])
+}
+
+// GetHelperCredentials runs the get action of the credential helper
+// docker-credential-<helper> for serverURL.
+func GetHelperCredentials(helper, serverURL string) (HelperCredentials, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(CredentialHelperPrefix+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return HelperCredentials{}, fmt.Errorf("credential helper %s failed: %s", helper, msg)
+		}
+		return HelperCredentials{}, fmt.Errorf("credential helper %s failed: %w", helper, err)
+	}
+	var creds HelperCredentials
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return HelperCredentials{}, fmt.Errorf("invalid response from credential helper %s: %w", helper, err)
+	}
+	return creds, nil
+}
+
+// SetupCredentialHelper lets the registry wrappers hand credential refresh to
+// drone-docker. When register is set, the helper is registered for registry so
+// docker mints fresh tokens on demand instead of relying on the login password
+// for the whole build, and a failure is returned. Otherwise the helper is only
+// exposed as the credential provider drone-docker uses when a push is rejected
+// with expired credentials; as the login password still works without it, a
+// failure is logged and the build goes on.
+func SetupCredentialHelper(name, registry string, register bool) error {
+	if register {
+		return InstallCredentialHelper(name, registry)
+	}
+	if err := InstallCredentialProvider(name); err != nil {
+		slog.Warn("failed to install credential provider", "error", err)
+	}
+	return nil
+}
+
+// InstallCredentialHelper exposes the running executable as the credential
+// helper docker-credential-<name> on the PATH and registers it for registry
+// in CredentialHelpersEnv, so drone-docker adds it to the docker config.
+func InstallCredentialHelper(name, registry string) error {
+	if err := InstallCredentialProvider(name); err != nil {
+		return err
+	}
+	entry := fmt.Sprintf("%s=%s", registry, name)
+	if existing := os.Getenv(CredentialHelpersEnv); existing != "" {
+		entry = existing + "," + entry
+	}
+	os.Setenv(CredentialHelpersEnv, entry)
+	return nil
+}
+
+// InstallCredentialProvider exposes the running executable as the credential
+// helper docker-credential-<name> on the PATH and names it in
+// CredentialProviderEnv, so drone-docker can refresh expired credentials
+// without registering the helper in the docker config.
+func InstallCredentialProvider(name string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to locate executable: %w", err)
+	}
+	dir := filepath.Join(os.TempDir(), "drone-credential-helpers")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("unable to create credential helper directory: %w", err)
+	}
+
+	helper := filepath.Join(dir, CredentialHelperPrefix+name)
+	if runtime.GOOS == "windows" {
+		helper += ".exe"
+	}
+	os.Remove(helper)
+	if err := os.Symlink(executable, helper); err != nil {
+		// symlinks may require privileges, fall back to a copy
+		if err := copyExecutable(executable, helper); err != nil {
+			return fmt.Errorf("unable to install credential helper: %w", err)
+		}
+	}
+
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	os.Setenv(CredentialProviderEnv, name)
+	return nil
+}
+
+func copyExecutable(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0755)
+}
@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsCredentialHelper(t *testing.T) {
+	assert.True(t, IsCredentialHelper([]string{"/tmp/helpers/docker-credential-drone-ecr", "get"}))
+	assert.False(t, IsCredentialHelper([]string{"/bin/drone-ecr"}))
+	assert.False(t, IsCredentialHelper(nil))
+}
+
+func TestServeCredentialHelper(t *testing.T) {
+	var requested string
+	get := func(serverURL string) (string, string, error) {
+		requested = serverURL
+		return "AWS", "token", nil
+	}
+
+	var out bytes.Buffer
+	err := ServeCredentialHelper([]string{"get"}, strings.NewReader("123.dkr.ecr.us-east-1.amazonaws.com\n"), &out, get)
+	assert.NoError(t, err)
+	assert.Equal(t, "123.dkr.ecr.us-east-1.amazonaws.com", requested)
+
+	var creds HelperCredentials
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &creds))
+	assert.Equal(t, HelperCredentials{
+		ServerURL: "123.dkr.ecr.us-east-1.amazonaws.com",
+		Username:  "AWS",
+		Secret:    "token",
+	}, creds)
+
+	out.Reset()
+	assert.NoError(t, ServeCredentialHelper([]string{"store"}, strings.NewReader(`{"ServerURL":"x"}`), &out, get))
+	assert.NoError(t, ServeCredentialHelper([]string{"erase"}, strings.NewReader("x"), &out, get))
+	assert.Empty(t, out.String())
+
+	assert.NoError(t, ServeCredentialHelper([]string{"list"}, strings.NewReader(""), &out, get))
+	assert.Equal(t, "{}\n", out.String())
+
+	assert.Error(t, ServeCredentialHelper([]string{"version"}, strings.NewReader(""), &out, get))
+	assert.Error(t, ServeCredentialHelper(nil, strings.NewReader(""), &out, get))
+}
+
+func TestServeCredentialHelperError(t *testing.T) {
+	get := func(string) (string, string, error) {
+		return "", "", errors.New("token expired")
+	}
+	var out bytes.Buffer
+	err := ServeCredentialHelper([]string{"get"}, strings.NewReader("gcr.io"), &out, get)
+	assert.ErrorContains(t, err, "gcr.io")
+	assert.ErrorContains(t, err, "token expired")
+	assert.Empty(t, out.String())
+}
+
+func TestInstallCredentialHelper(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	t.Setenv("PATH", os.Getenv("PATH"))
+	t.Setenv(CredentialHelpersEnv, "quay.io=quay")
+
+	assert.NoError(t, InstallCredentialHelper("drone-ecr", "123.dkr.ecr.us-east-1.amazonaws.com"))
+
+	dir := filepath.Join(tmp, "drone-credential-helpers")
+	_, err := os.Stat(filepath.Join(dir, "docker-credential-drone-ecr"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(os.Getenv("PATH"), dir+string(os.PathListSeparator)))
+	assert.Equal(t, "quay.io=quay,123.dkr.ecr.us-east-1.amazonaws.com=drone-ecr", os.Getenv(CredentialHelpersEnv))
+}
+
+func TestSetupCredentialHelper(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	t.Setenv("PATH", os.Getenv("PATH"))
+	t.Setenv(CredentialHelpersEnv, "")
+	t.Setenv(CredentialProviderEnv, "")
+
+	assert.NoError(t, SetupCredentialHelper("drone-gcr", "gcr.io", false))
+	assert.Equal(t, "drone-gcr", os.Getenv(CredentialProviderEnv))
+	assert.Empty(t, os.Getenv(CredentialHelpersEnv))
+
+	assert.NoError(t, SetupCredentialHelper("drone-gcr", "gcr.io", true))
+	assert.Equal(t, "gcr.io=drone-gcr", os.Getenv(CredentialHelpersEnv))
+}
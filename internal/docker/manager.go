@@ -0,0 +1,204 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Origin records which input supplied a docker config entry.
+type Origin string
+
+const (
+	OriginExisting     Origin = "existing-config" // config.json already present on disk
+	OriginUserConfig   Origin = "user-config"     // PLUGIN_CONFIG / DOCKER_PLUGIN_CONFIG
+	OriginPushRegistry Origin = "push-registry"   // push registry credentials
+	OriginBaseImage    Origin = "base-image"      // base image connector credentials
+	OriginCredHelper   Origin = "cred-helper"     // credential helper settings
+)
+
+// Entry kinds tracked by the ConfigManager.
+const (
+	KindAuth       = "auths"
+	KindCredHelper = "credHelpers"
+	KindCredsStore = "credsStore"
+)
+
+// Entry describes a single docker config entry and where it came from.
+type Entry struct {
+	Kind     string
+	Registry string
+	Origin   Origin
+}
+
+// ConfigManager merges docker config content from several sources into a
+// single config.json, remembering the origin of every entry. Later sources
+// override earlier ones for the same registry.
+type ConfigManager struct {
+	config  *Config
+	extra   map[string]json.RawMessage
+	origins map[string]Origin
+}
+
+// NewConfigManager returns an empty ConfigManager.
+func NewConfigManager() *ConfigManager {
+	return &ConfigManager{
+		config:  NewConfig(),
+		extra:   make(map[string]json.RawMessage),
+		origins: make(map[string]Origin),
+	}
+}
+
+// MergeJSON merges the content of a docker config.json. Keys the manager does
+// not handle (proxies, HttpHeaders, ...) are preserved as is.
+func (m *ConfigManager) MergeJSON(data []byte, origin Origin) error {
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("invalid docker config json: %w", err)
+	}
+	for key, value := range raw {
+		switch key {
+		case KindAuth:
+			var auths map[string]Auth
+			if err := json.Unmarshal(value, &auths); err != nil {
+				return fmt.Errorf("invalid docker config auths: %w", err)
+			}
+			for registry, auth := range auths {
+				m.setAuth(registry, auth, origin)
+			}
+		case KindCredHelper:
+			var helpers map[string]string
+			if err := json.Unmarshal(value, &helpers); err != nil {
+				return fmt.Errorf("invalid docker config credHelpers: %w", err)
+			}
+			for registry, helper := range helpers {
+				m.AddCredHelper(registry, helper, origin)
+			}
+		case KindCredsStore:
+			var store string
+			if err := json.Unmarshal(value, &store); err != nil {
+				return fmt.Errorf("invalid docker config credsStore: %w", err)
+			}
+			m.SetCredsStore(store, origin)
+		default:
+			m.extra[key] = value
+		}
+	}
+	return nil
+}
+
+// AddCredentials adds username and password credentials for a registry.
+func (m *ConfigManager) AddCredentials(cred RegistryCredentials, origin Origin) error {
+	if cred.Username == "" {
+		return fmt.Errorf("Username must be specified for registry: %s", cred.Registry)
+	}
+	if cred.Password == "" {
+		return fmt.Errorf("Password must be specified for registry: %s", cred.Registry)
+	}
+	registry := NormalizeRegistry(cred.Registry)
+	m.config.SetAuth(registry, cred.Username, cred.Password)
+	m.origins[entryKey(KindAuth, registry)] = origin
+	return nil
+}
+
+// AddCredHelper registers a credential helper for a registry.
+func (m *ConfigManager) AddCredHelper(registry, helper string, origin Origin) {
+	m.config.SetCredHelper(registry, helper)
+	m.origins[entryKey(KindCredHelper, registry)] = origin
+}
+
+// SetCredsStore sets the default credential store.
+func (m *ConfigManager) SetCredsStore(store string, origin Origin) {
+	m.config.CredsStore = store
+	m.origins[entryKey(KindCredsStore, "")] = origin
+}
+
+// Origin returns the origin of the entry of the given kind for a registry.
+func (m *ConfigManager) Origin(kind, registry string) (Origin, bool) {
+	if kind == KindAuth {
+		registry = NormalizeRegistry(registry)
+	}
+	origin, ok := m.origins[entryKey(kind, registry)]
+	return origin, ok
+}
+
+// Entries returns every entry of the merged config, sorted by kind and
+// registry.
+func (m *ConfigManager) Entries() []Entry {
+	var entries []Entry
+	for key, origin := range m.origins {
+		kind, registry, _ := strings.Cut(key, "/")
+		entries = append(entries, Entry{Kind: kind, Registry: registry, Origin: origin})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Kind != entries[j].Kind {
+			return entries[i].Kind < entries[j].Kind
+		}
+		return entries[i].Registry < entries[j].Registry
+	})
+	return entries
+}
+
+// Empty reports whether nothing has been merged into the manager.
+func (m *ConfigManager) Empty() bool {
+	return len(m.origins) == 0 && len(m.extra) == 0
+}
+
+// Marshal serializes the merged docker config.
+func (m *ConfigManager) Marshal() ([]byte, error) {
+	out := make(map[string]interface{}, len(m.extra)+3)
+	for key, value := range m.extra {
+		out[key] = value
+	}
+	out[KindAuth] = m.config.Auths
+	if len(m.config.CredHelpers) > 0 {
+		out[KindCredHelper] = m.config.CredHelpers
+	}
+	if m.config.CredsStore != "" {
+		out[KindCredsStore] = m.config.CredsStore
+	}
+	data, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize docker config json: %w", err)
+	}
+	return data, nil
+}
+
+// WriteFile writes the merged docker config to path.
+func (m *ConfigManager) WriteFile(path string) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func (m *ConfigManager) setAuth(registry string, auth Auth, origin Origin) {
+	registry = NormalizeRegistry(registry)
+	m.config.Auths[registry] = auth
+	m.origins[entryKey(KindAuth, registry)] = origin
+}
+
+// NormalizeRegistry maps the different spellings of the Docker Hub registry
+// to the key used by docker login, so entries for the same registry merge.
+func NormalizeRegistry(registry string) string {
+	switch strings.TrimSuffix(registry, "/") {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com",
+		strings.TrimSuffix(v1RegistryURL, "/"), strings.TrimSuffix(v2RegistryURL, "/"), strings.TrimSuffix(v2HubRegistryURL, "/"):
+		return v1RegistryURL
+	}
+	return registry
+}
+
+func entryKey(kind, registry string) string {
+	return kind + "/" + registry
+}
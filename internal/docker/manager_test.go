@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigManagerMerge(t *testing.T) {
+	m := NewConfigManager()
+	assert.True(t, m.Empty())
+
+	userConfig := `{
+		"auths": {"docker.io": {"auth": "dXNlcjpwYXNz"}, "quay.io": {"auth": "cXVheTpwYXNz"}},
+		"credHelpers": {"123.dkr.ecr.us-east-1.amazonaws.com": "ecr-login"},
+		"proxies": {"default": {"httpProxy": "http://proxy:3128"}}
+	}`
+	assert.NoError(t, m.MergeJSON([]byte(userConfig), OriginUserConfig))
+	assert.NoError(t, m.AddCredentials(RegistryCredentials{Registry: "quay.io", Username: "bot", Password: "secret"}, OriginBaseImage))
+	m.AddCredHelper("gcr.io", "gcloud", OriginCredHelper)
+	m.SetCredsStore("pass", OriginCredHelper)
+
+	origin, ok := m.Origin(KindAuth, "https://index.docker.io/v1/")
+	assert.True(t, ok)
+	assert.Equal(t, OriginUserConfig, origin)
+
+	origin, _ = m.Origin(KindAuth, "quay.io")
+	assert.Equal(t, OriginBaseImage, origin)
+
+	assert.Equal(t, []Entry{
+		{Kind: KindAuth, Registry: RegistryV1, Origin: OriginUserConfig},
+		{Kind: KindAuth, Registry: "quay.io", Origin: OriginBaseImage},
+		{Kind: KindCredHelper, Registry: "123.dkr.ecr.us-east-1.amazonaws.com", Origin: OriginUserConfig},
+		{Kind: KindCredHelper, Registry: "gcr.io", Origin: OriginCredHelper},
+		{Kind: KindCredsStore, Registry: "", Origin: OriginCredHelper},
+	}, m.Entries())
+
+	path := filepath.Join(t.TempDir(), ".docker", "config.json")
+	assert.NoError(t, m.WriteFile(path))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	var out map[string]json.RawMessage
+	assert.NoError(t, json.Unmarshal(data, &out))
+	assert.Contains(t, out, "proxies")
+
+	var config Config
+	assert.NoError(t, json.Unmarshal(data, &config))
+	assert.Equal(t, "Ym90OnNlY3JldA==", config.Auths["quay.io"].Auth)
+	assert.Equal(t, "dXNlcjpwYXNz", config.Auths[RegistryV1].Auth)
+	assert.Equal(t, "gcloud", config.CredHelpers["gcr.io"])
+	assert.Equal(t, "pass", config.CredsStore)
+}
+
+func TestConfigManagerInvalid(t *testing.T) {
+	m := NewConfigManager()
+	assert.Error(t, m.MergeJSON([]byte("{not json"), OriginUserConfig))
+	assert.Error(t, m.MergeJSON([]byte(`{"auths": []}`), OriginUserConfig))
+	assert.Error(t, m.AddCredentials(RegistryCredentials{Registry: "gcr.io", Password: "p"}, OriginPushRegistry))
+	assert.Error(t, m.AddCredentials(RegistryCredentials{Registry: "gcr.io", Username: "u"}, OriginPushRegistry))
+}
+
+func TestNormalizeRegistry(t *testing.T) {
+	for _, registry := range []string{"", "docker.io", "index.docker.io", "https://index.docker.io/v1/", "https://index.docker.io/v2/"} {
+		assert.Equal(t, RegistryV1, NormalizeRegistry(registry), registry)
+	}
+	assert.Equal(t, "gcr.io", NormalizeRegistry("gcr.io"))
+}
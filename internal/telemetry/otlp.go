@@ -0,0 +1,42 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// newExporterFromEnv returns the OTLP exporter of the protocol set by
+// OTEL_EXPORTER_OTLP_PROTOCOL, http/protobuf by default, or nil when no
+// endpoint is configured. The exporters read the endpoint, headers, timeout,
+// compression and TLS settings from the environment themselves.
+func newExporterFromEnv(ctx context.Context) (sdktrace.SpanExporter, error) {
+	if lookupEnv("ENDPOINT") == "" {
+		return nil, nil
+	}
+	switch protocol := lookupEnv("PROTOCOL"); protocol {
+	case "", "http/protobuf":
+		return otlptracehttp.New(ctx)
+	case "http/json":
+		slog.Warn("the http/json OTLP protocol is not supported, exporting traces with http/protobuf")
+		return otlptracehttp.New(ctx)
+	case "grpc":
+		return otlptracegrpc.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %s", protocol)
+	}
+}
+
+// lookupEnv returns the traces specific OTLP exporter setting, falling back
+// to the generic one.
+func lookupEnv(name string) string {
+	if value := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_" + name); value != "" {
+		return value
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_" + name)
+}
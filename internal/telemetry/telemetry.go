@@ -0,0 +1,151 @@
+// Package telemetry exports OpenTelemetry traces of the plugin phases over
+// OTLP, configured through the standard OTEL_* environment variables.
+package telemetry
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer used for every span.
+const InstrumentationName = "github.com/drone-plugins/drone-docker"
+
+// Span attributes shared by drone-docker and the registry wrappers.
+const (
+	AttrRepo         = attribute.Key("docker.repo")
+	AttrTag          = attribute.Key("docker.tag")
+	AttrImage        = attribute.Key("docker.image")
+	AttrDigest       = attribute.Key("docker.digest")
+	AttrRegistry     = attribute.Key("docker.registry")
+	AttrRegistryType = attribute.Key("docker.registry.type")
+	AttrPurpose      = attribute.Key("docker.login.purpose")
+)
+
+// environment variables carrying the trace context to child processes, see
+// https://opentelemetry.io/docs/specs/otel/context/env-carriers/
+var propagationEnvs = map[string]string{
+	"traceparent": "TRACEPARENT",
+	"tracestate":  "TRACESTATE",
+}
+
+var propagator = propagation.TraceContext{}
+
+// Setup installs a tracer provider exporting spans to the OTLP endpoint
+// configured in the environment and returns a function flushing the pending
+// spans. Tracing stays disabled, and spans are no-ops, when no endpoint is
+// configured, when OTEL_SDK_DISABLED is true or when OTEL_TRACES_EXPORTER is
+// none.
+func Setup(service string) (shutdown func()) {
+	shutdown = func() {}
+	if disabled, _ := strconv.ParseBool(os.Getenv("OTEL_SDK_DISABLED")); disabled {
+		return
+	}
+	if exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter != "" && exporter != "otlp" {
+		if exporter != "none" {
+			slog.Warn("unsupported traces exporter, tracing is disabled", "exporter", exporter)
+		}
+		return
+	}
+	exporter, err := newExporterFromEnv(context.Background())
+	if err != nil {
+		slog.Warn("failed to create the traces exporter, tracing is disabled", "error", err)
+		return
+	}
+	if exporter == nil {
+		return
+	}
+
+	// attributes from OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME take
+	// precedence over the default service name
+	res, err := resource.New(context.Background(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attribute.String("service.name", service)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		slog.Warn("failed to detect the telemetry resource", "error", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			slog.Warn("failed to export traces", "error", err)
+		}
+	}
+}
+
+// Start starts a span named name as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(InstrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the outcome of the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Record records an operation that started at start and just ended with err
+// as a span named name, child of the span in ctx.
+func Record(ctx context.Context, name string, start time.Time, err error, attrs ...attribute.KeyValue) {
+	_, span := otel.Tracer(InstrumentationName).Start(ctx, name,
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attrs...),
+	)
+	End(span, err)
+}
+
+// FromEnv returns a context holding the remote span propagated by the parent
+// process through the TRACEPARENT and TRACESTATE environment variables.
+func FromEnv() context.Context {
+	carrier := propagation.MapCarrier{}
+	for key, env := range propagationEnvs {
+		if value := os.Getenv(env); value != "" {
+			carrier[key] = value
+		}
+	}
+	return propagator.Extract(context.Background(), carrier)
+}
+
+// Environ returns env with the TRACEPARENT and TRACESTATE variables set to
+// propagate the span in ctx to a child process.
+func Environ(ctx context.Context, env []string) []string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+
+	environ := make([]string, 0, len(env)+len(carrier))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if name != propagationEnvs["traceparent"] && name != propagationEnvs["tracestate"] {
+			environ = append(environ, kv)
+		}
+	}
+	for key, value := range carrier {
+		environ = append(environ, propagationEnvs[key]+"="+value)
+	}
+	return environ
+}
@@ -0,0 +1,166 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/drone-plugins/drone-docker/internal/telemetry/telemetrytest"
+)
+
+func TestSetupExportsSpans(t *testing.T) {
+	collector := telemetrytest.NewCollector(t)
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-api-key=abc%20123")
+	t.Setenv("OTEL_SERVICE_NAME", "")
+
+	shutdown := Setup("drone-docker")
+	ctx, span := Start(context.Background(), "drone-docker", AttrRepo.String("octocat/hello"))
+	Record(ctx, "docker.push", time.Now(), errors.New("denied"),
+		AttrTag.String("latest"),
+		AttrDigest.String("sha256:abc"),
+	)
+	End(span, nil)
+	shutdown()
+
+	root, ok := collector.Span("drone-docker")
+	require.True(t, ok)
+	assert.Equal(t, "drone-docker", root.Service)
+	assert.Equal(t, "octocat/hello", root.Attributes["docker.repo"])
+	assert.Empty(t, root.ParentSpanID)
+
+	push, ok := collector.Span("docker.push")
+	require.True(t, ok)
+	assert.Equal(t, root.TraceID, push.TraceID)
+	assert.Equal(t, root.SpanID, push.ParentSpanID)
+	assert.Equal(t, "latest", push.Attributes["docker.tag"])
+	assert.Equal(t, "sha256:abc", push.Attributes["docker.digest"])
+	assert.Equal(t, 2, push.StatusCode)
+	assert.Equal(t, "denied", push.StatusMsg)
+
+	require.NotEmpty(t, collector.Headers())
+	assert.Equal(t, "abc 123", collector.Headers()[0].Get("x-api-key"))
+	assert.Equal(t, "application/x-protobuf", collector.Headers()[0].Get("Content-Type"))
+}
+
+func TestSetupDisabled(t *testing.T) {
+	tcs := map[string]map[string]string{
+		"no endpoint":  {"OTEL_EXPORTER_OTLP_ENDPOINT": ""},
+		"sdk disabled": {"OTEL_SDK_DISABLED": "true"},
+		"no exporter":  {"OTEL_TRACES_EXPORTER": "none"},
+	}
+	for name, env := range tcs {
+		t.Run(name, func(t *testing.T) {
+			collector := telemetrytest.NewCollector(t)
+			for key, value := range env {
+				t.Setenv(key, value)
+			}
+			shutdown := Setup("drone-docker")
+			_, span := Start(context.Background(), "drone-docker")
+			assert.False(t, span.IsRecording())
+			End(span, nil)
+			shutdown()
+			assert.Empty(t, collector.Spans())
+		})
+	}
+}
+
+func TestTracesEndpoint(t *testing.T) {
+	collector := telemetrytest.NewCollector(t)
+	// the traces endpoint is used as is and takes precedence
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")+"/v1/traces")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://127.0.0.1:1")
+	t.Setenv("OTEL_EXPORTER_OTLP_COMPRESSION", "gzip")
+
+	shutdown := Setup("drone-docker")
+	_, span := Start(context.Background(), "drone-docker")
+	End(span, nil)
+	shutdown()
+
+	_, ok := collector.Span("drone-docker")
+	assert.True(t, ok)
+	require.NotEmpty(t, collector.Headers())
+	assert.Equal(t, "gzip", collector.Headers()[0].Get("Content-Encoding"))
+}
+
+func TestExporterProtocol(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	tcs := map[string]bool{
+		"":              true,
+		"http/protobuf": true,
+		"http/json":     true,
+		"grpc":          true,
+		"thrift":        false,
+	}
+	for protocol, supported := range tcs {
+		t.Run(protocol, func(t *testing.T) {
+			t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", protocol)
+			exporter, err := newExporterFromEnv(context.Background())
+			if !supported {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, exporter)
+			exporter.Shutdown(context.Background())
+		})
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	exporter, err := newExporterFromEnv(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, exporter)
+}
+
+func TestEnvironPropagation(t *testing.T) {
+	telemetrytest.NewCollector(t)
+	shutdown := Setup("drone-ecr")
+	defer shutdown()
+
+	ctx, span := Start(context.Background(), "drone-ecr")
+	defer span.End()
+
+	env := Environ(ctx, []string{"PATH=/bin", "TRACEPARENT=00-stale"})
+	assert.Contains(t, env, "PATH=/bin")
+	assert.NotContains(t, env, "TRACEPARENT=00-stale")
+
+	// the child process continues the trace of the parent span
+	for _, kv := range env {
+		if name, value, _ := strings.Cut(kv, "="); name == "TRACEPARENT" {
+			t.Setenv(name, value)
+		}
+	}
+	remote := trace.SpanContextFromContext(FromEnv())
+	assert.True(t, remote.IsRemote())
+	assert.Equal(t, span.SpanContext().TraceID(), remote.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), remote.SpanID())
+}
+
+func TestWrapperCommand(t *testing.T) {
+	collector := telemetrytest.NewCollector(t)
+	w := StartWrapper("drone-ecr", "ECR")
+	w.Credentials("123.dkr.ecr.us-east-1.amazonaws.com", time.Now(), nil)
+
+	cmd := exec.Command("true")
+	w.Command(cmd)
+	var traceparent string
+	for _, kv := range cmd.Env {
+		if value, ok := strings.CutPrefix(kv, "TRACEPARENT="); ok {
+			traceparent = value
+		}
+	}
+	assert.NotEmpty(t, traceparent)
+	w.Finish(nil)
+
+	creds, ok := collector.Span("registry.credentials")
+	require.True(t, ok)
+	assert.Equal(t, "ECR", creds.Attributes["docker.registry.type"])
+	assert.Equal(t, "drone-ecr", creds.Service)
+}
@@ -0,0 +1,64 @@
+package telemetry
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Wrapper traces a registry wrapper: the credentials it obtains and the
+// drone-docker process it spawns, which continues the same trace.
+type Wrapper struct {
+	ctx          context.Context
+	span         trace.Span
+	registryType string
+	shutdown     func()
+}
+
+// StartWrapper sets up tracing for the wrapper binary name and starts the
+// span covering its execution.
+func StartWrapper(name, registryType string) *Wrapper {
+	shutdown := Setup(name)
+	ctx, span := Start(FromEnv(), name, AttrRegistryType.String(registryType))
+	return &Wrapper{
+		ctx:          ctx,
+		span:         span,
+		registryType: registryType,
+		shutdown:     shutdown,
+	}
+}
+
+// SetAttributes adds attributes, such as the resolved repo, to the wrapper
+// span.
+func (w *Wrapper) SetAttributes(attrs ...attribute.KeyValue) {
+	w.span.SetAttributes(attrs...)
+}
+
+// Credentials records obtaining the credentials of registry, started at
+// start, as a span.
+func (w *Wrapper) Credentials(registry string, start time.Time, err error) {
+	Record(w.ctx, "registry.credentials", start, err,
+		AttrRegistry.String(registry),
+		AttrRegistryType.String(w.registryType),
+	)
+}
+
+// Command propagates the wrapper span to the drone-docker process.
+func (w *Wrapper) Command(cmd *exec.Cmd) {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = Environ(w.ctx, env)
+}
+
+// Finish ends the wrapper span with err and exports the pending spans. Call
+// it before the process exits.
+func (w *Wrapper) Finish(err error) {
+	End(w.span, err)
+	w.shutdown()
+}
@@ -0,0 +1,106 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// outputVar is an output variable exported to the later steps of the
+// pipeline.
+type outputVar struct {
+	Name  string
+	Value string
+}
+
+// imageOutputs returns the output variables describing the image pushed to
+// repo with tags: the digest, the canonical reference, the tags and one
+// reference per pushed tag, e.g. IMAGE_REF_LATEST. The canonical reference
+// pins the digest when it is known. Every name starts with prefix.
+func imageOutputs(prefix, repo string, tags []string, digest string) []outputVar {
+	var vars []outputVar
+	if digest != "" {
+		vars = append(vars, outputVar{prefix + "IMAGE_DIGEST", digest})
+	}
+	switch {
+	case digest != "":
+		vars = append(vars, outputVar{prefix + "IMAGE_REF", repo + "@" + digest})
+	case len(tags) > 0:
+		vars = append(vars, outputVar{prefix + "IMAGE_REF", repo + ":" + tags[0]})
+	}
+	vars = append(vars, outputVar{prefix + "IMAGE_TAGS", strings.Join(tags, ",")})
+	for _, tag := range tags {
+		vars = append(vars, outputVar{prefix + "IMAGE_REF_" + outputName(tag), repo + ":" + tag})
+	}
+	return vars
+}
+
+// outputName turns s into a variable name suffix, upper casing it and
+// replacing every character that is not a letter or a digit by an
+// underscore.
+func outputName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, s)
+}
+
+// outputFile returns the file the step outputs are written to, the secret
+// output file when outputs are exported as secrets.
+func (p Plugin) outputFile() string {
+	if p.SecretOutputs {
+		return p.SecretOutputFile
+	}
+	return p.OutputFile
+}
+
+// exportOutputs writes the outputs of the pushed image to the output file, if
+// any. Failures are reported but never fail the step.
+func (p Plugin) exportOutputs(tags []string, digest string) {
+	path := p.outputFile()
+	if path == "" {
+		return
+	}
+	// the outputs of a multi-image step are prefixed with the image name
+	var prefix string
+	if p.imageName != "" {
+		prefix = outputName(p.imageName) + "_"
+	}
+	if err := writeOutputs(path, imageOutputs(prefix, p.Build.Repo, tags, digest)); err != nil {
+		fmt.Printf("Could not write the step outputs. %s\n", err)
+		return
+	}
+	fmt.Printf("Step outputs written to %s\n", path)
+}
+
+// outputsMu serializes the output writes of the images of a multi-image
+// step.
+var outputsMu sync.Mutex
+
+// writeOutputs appends the variables to the dotenv file at path, keeping the
+// outputs already written by the step.
+func writeOutputs(path string, vars []outputVar) error {
+	var b strings.Builder
+	for _, v := range vars {
+		if strings.ContainsAny(v.Value, "\r\n") {
+			return fmt.Errorf("output %s cannot span multiple lines", v.Name)
+		}
+		fmt.Fprintf(&b, "%s=%s\n", v.Name, v.Value)
+	}
+
+	outputsMu.Lock()
+	defer outputsMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open output file: %w", err)
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write output file: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestImageOutputs(t *testing.T) {
+	tcs := []struct {
+		name   string
+		tags   []string
+		digest string
+		want   []outputVar
+	}{
+		{
+			name:   "pushed",
+			tags:   []string{"latest", "1.0"},
+			digest: "sha256:abc",
+			want: []outputVar{
+				{"IMAGE_DIGEST", "sha256:abc"},
+				{"IMAGE_REF", "quay.io/octocat/hello@sha256:abc"},
+				{"IMAGE_TAGS", "latest,1.0"},
+				{"IMAGE_REF_LATEST", "quay.io/octocat/hello:latest"},
+				{"IMAGE_REF_1_0", "quay.io/octocat/hello:1.0"},
+			},
+		},
+		{
+			name: "no digest",
+			tags: []string{"v1.2.3-rc.1"},
+			want: []outputVar{
+				{"IMAGE_REF", "quay.io/octocat/hello:v1.2.3-rc.1"},
+				{"IMAGE_TAGS", "v1.2.3-rc.1"},
+				{"IMAGE_REF_V1_2_3_RC_1", "quay.io/octocat/hello:v1.2.3-rc.1"},
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got := imageOutputs("", "quay.io/octocat/hello", tc.tags, tc.digest)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Got outputs %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExportOutputs(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output.env")
+	secret := filepath.Join(dir, "secret.env")
+	if err := os.WriteFile(output, []byte("EXISTING=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := Plugin{
+		Build:            Build{Repo: "octocat/hello"},
+		OutputFile:       output,
+		SecretOutputFile: secret,
+	}
+	p.exportOutputs([]string{"latest"}, "sha256:abc")
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "EXISTING=1\n" +
+		"IMAGE_DIGEST=sha256:abc\n" +
+		"IMAGE_REF=octocat/hello@sha256:abc\n" +
+		"IMAGE_TAGS=latest\n" +
+		"IMAGE_REF_LATEST=octocat/hello:latest\n"
+	if string(data) != want {
+		t.Errorf("Got output file:\n%s\nwant:\n%s", data, want)
+	}
+	if _, err := os.Stat(secret); !os.IsNotExist(err) {
+		t.Errorf("The secret output file should not be written")
+	}
+
+	// secret outputs only go to the secret output file
+	p.SecretOutputs = true
+	p.exportOutputs([]string{"latest"}, "sha256:abc")
+	if data, _ := os.ReadFile(secret); len(data) == 0 {
+		t.Errorf("Expected outputs in the secret output file")
+	}
+}
+
+func TestWriteOutputsMultiline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output.env")
+	if err := writeOutputs(path, []outputVar{{"IMAGE_TAGS", "a\nb"}}); err == nil {
+		t.Errorf("Expected an error for a multiline output")
+	}
+}
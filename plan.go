@@ -0,0 +1,423 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// executionPlan describes everything a step would do, resolved from the
+// plugin settings without starting the daemon or building.
+type executionPlan struct {
+	Mode         string       `json:"mode"`
+	Daemon       planDaemon   `json:"daemon"`
+	Logins       []planLogin  `json:"logins"`
+	Build        *planBuild   `json:"build,omitempty"`
+	Images       []planImage  `json:"images,omitempty"`
+	Bake         *planBake    `json:"bake,omitempty"`
+	Test         *planTest    `json:"test,omitempty"`
+	Export       *planExport  `json:"export,omitempty"`
+	Smoke        *planSmoke   `json:"smoke_tests,omitempty"`
+	ContentHash  string       `json:"content_hash,omitempty"`
+	Changes      *planChanges `json:"changes,omitempty"`
+	SourceImage  string       `json:"source_image,omitempty"`
+	Destinations []string     `json:"destinations"`
+	Push         bool         `json:"push"`
+	Signing      *planSigning `json:"signing,omitempty"`
+	Cleanup      bool         `json:"cleanup"`
+	Commands     []string     `json:"commands"`
+}
+
+type planDaemon struct {
+	Mode         string `json:"mode"`
+	Host         string `json:"host,omitempty"`
+	BuildkitHost string `json:"buildkit_host,omitempty"`
+	Mirror       string `json:"mirror,omitempty"`
+	TLS          bool   `json:"tls"`
+}
+
+type planLogin struct {
+	Purpose  string `json:"purpose"`
+	Registry string `json:"registry"`
+	Username string `json:"username,omitempty"`
+	Method   string `json:"method"`
+}
+
+type planBuild struct {
+	Dockerfile string   `json:"dockerfile"`
+	Context    string   `json:"context"`
+	Target     string   `json:"target,omitempty"`
+	Platform   string   `json:"platform,omitempty"`
+	Builder    string   `json:"builder,omitempty"`
+	Args       []string `json:"args,omitempty"`
+	Secrets    []string `json:"secrets,omitempty"`
+	CacheFrom  []string `json:"cache_from,omitempty"`
+	Labels     []string `json:"labels,omitempty"`
+	Pull       bool     `json:"pull"`
+	NoCache    bool     `json:"no_cache"`
+}
+
+type planImage struct {
+	Name         string     `json:"name"`
+	Build        *planBuild `json:"build"`
+	Destinations []string   `json:"destinations"`
+}
+
+type planBake struct {
+	Files   []string `json:"files,omitempty"`
+	Targets []string `json:"targets"`
+}
+
+type planTest struct {
+	Target  string   `json:"target"`
+	Reports []string `json:"reports,omitempty"`
+	Dest    string   `json:"dest,omitempty"`
+}
+
+type planExport struct {
+	Type   string `json:"type"`
+	Dest   string `json:"dest"`
+	Target string `json:"target,omitempty"`
+}
+
+type planSmoke struct {
+	Tests   []string `json:"tests"`
+	Timeout string   `json:"timeout"`
+}
+
+type planChanges struct {
+	Paths       []string `json:"paths"`
+	PathsIgnore []string `json:"paths_ignore,omitempty"`
+}
+
+type planSigning struct {
+	Tool   string `json:"tool"`
+	Key    string `json:"key"`
+	Params string `json:"params,omitempty"`
+}
+
+// plan resolves the plugin settings into an execution plan, prints it as a
+// table and writes it as JSON to the plan file.
+func (p Plugin) plan() error {
+	plan := p.executionPlan()
+	printPlan(os.Stdout, plan)
+
+	if p.PlanFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(p.PlanFile); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("unable to create plan directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(p.PlanFile, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("unable to write plan file: %w", err)
+	}
+	fmt.Printf("Plan written to %s\n", p.PlanFile)
+	return nil
+}
+
+// executionPlan builds the plan. Secret values are masked by the redaction
+// layer, so the plan is safe to publish.
+func (p Plugin) executionPlan() executionPlan {
+	plan := executionPlan{
+		Mode:    "build",
+		Daemon:  p.planDaemon(),
+		Logins:  p.planLogins(),
+		Push:    !p.Dryrun,
+		Cleanup: p.Cleanup,
+	}
+	switch {
+	case p.PushOnly:
+		plan.Mode = "push-only"
+		plan.SourceImage = p.SourceImage
+	case p.Export.Only:
+		plan.Mode = "export"
+		plan.Push = false
+	case p.Dryrun:
+		plan.Mode = "dry-run"
+	}
+
+	build := p.Build
+	addProxyBuildArgs(&build)
+	if p.Daemon.BuildkitHost != "" {
+		build.Builder = remoteBuilderName
+	}
+	if p.Changes.enabled() {
+		plan.Changes = &planChanges{Paths: p.watchedPaths(), PathsIgnore: p.Changes.PathsIgnore}
+	}
+	if build.ContentHash && !p.PushOnly {
+		// the build is skipped at run time when the registry has the image
+		if hash, err := contentHash(build); err == nil {
+			plan.ContentHash = hash
+			build.Labels = append(build.Labels, contentHashLabel+"="+hash)
+			build.Tags = append(build.Tags, contentTag(hash))
+		} else {
+			fmt.Printf("Could not compute the content hash. %s\n", err)
+		}
+	}
+	if !p.PushOnly {
+		for _, img := range build.CacheFrom {
+			plan.Commands = append(plan.Commands, planCommand(commandPull(img)))
+		}
+	}
+
+	switch {
+	case p.Bake.enabled() && !p.PushOnly:
+		// the targets and their tags come from the bake definition
+		definition, err := resolveBake(p.Bake, build.Builder)
+		if err != nil {
+			fmt.Printf("Could not resolve the bake targets, their destinations are not planned. %s\n", err)
+		}
+		plan.Bake = &planBake{Files: p.Bake.Files, Targets: definition.targets()}
+		if definition == nil {
+			plan.Bake.Targets = p.Bake.Targets
+		}
+		for _, target := range definition.targets() {
+			plan.Destinations = append(plan.Destinations, bakeTargetImages(build, definition, target)...)
+		}
+		plan.Commands = append(plan.Commands, planCommand(commandBake(p.Bake, build, definition, "", plan.Push)))
+	case len(p.Images) > 0 && !p.PushOnly:
+		// every image of a multi-image step has its own build and destinations
+		p.Build = build
+		for i := range p.Images {
+			ip := p.imagePlugin(i)
+			image := planImage{Name: ip.imageName, Build: planBuildSettings(ip.Build)}
+			for _, tag := range ip.Build.Tags {
+				image.Destinations = append(image.Destinations, fmt.Sprintf("%s:%s", ip.Build.Repo, tag))
+			}
+			plan.Images = append(plan.Images, image)
+			plan.Destinations = append(plan.Destinations, image.Destinations...)
+			for _, cmd := range ip.imageCommands() {
+				plan.Commands = append(plan.Commands, planCommand(cmd))
+			}
+		}
+	default:
+		if p.Test.enabled() && !p.PushOnly {
+			p.Build = build
+			plan.Test = &planTest{Target: p.Test.Target, Reports: p.Test.Reports}
+			if len(p.Test.Reports) > 0 {
+				plan.Test.Dest = p.Test.dest()
+			}
+			plan.Commands = append(plan.Commands, planCommand(commandBuild(p.testBuild())))
+		}
+		if p.Export.enabled() && !p.PushOnly {
+			plan.Export = &planExport{Type: p.Export.exporter(), Dest: p.Export.Dest, Target: p.Export.Target}
+			plan.Commands = append(plan.Commands, planCommand(commandExport(build, p.Export)))
+			if p.Export.Only {
+				plan.Build = planBuildSettings(build)
+				break
+			}
+		}
+		if !p.PushOnly {
+			plan.Build = planBuildSettings(build)
+			plan.Commands = append(plan.Commands, planCommand(commandBuild(build)))
+		}
+		if p.Smoke.enabled() && !p.PushOnly {
+			plan.Smoke = &planSmoke{Tests: p.Smoke.names(), Timeout: p.Smoke.timeout().String()}
+		}
+		for _, tag := range build.Tags {
+			plan.Destinations = append(plan.Destinations, fmt.Sprintf("%s:%s", build.Repo, tag))
+			if !p.PushOnly {
+				plan.Commands = append(plan.Commands, planCommand(commandTag(build, tag)))
+			}
+			if plan.Push {
+				plan.Commands = append(plan.Commands, planCommand(commandPush(build, tag)))
+			}
+		}
+	}
+
+	if p.shouldSignWithCosign() && plan.Push {
+		signing := &planSigning{Tool: "cosign", Key: "file", Params: p.Cosign.Params}
+		if strings.HasPrefix(p.Cosign.PrivateKey, "-----BEGIN") {
+			signing.Key = "inline"
+		}
+		plan.Signing = signing
+	}
+	return plan
+}
+
+func (p Plugin) planDaemon() planDaemon {
+	daemon := planDaemon{
+		Mode:         "local",
+		BuildkitHost: p.Daemon.BuildkitHost,
+		Mirror:       p.Daemon.Mirror,
+		TLS:          p.Daemon.hasTLS(),
+	}
+	switch {
+	case p.Daemon.usesRemoteDaemon():
+		daemon.Mode = "remote"
+		daemon.Host = p.Daemon.Host
+	case p.Daemon.Disabled:
+		daemon.Mode = "external"
+	}
+	return daemon
+}
+
+func (p Plugin) planLogins() []planLogin {
+	var logins []planLogin
+	for _, connector := range p.baseImageConnectors() {
+		login := planLogin{Purpose: "base-image", Registry: connector.name(), Username: connector.Username, Method: "password"}
+		if connector.Config != "" {
+			login.Method = "docker-config"
+		}
+		logins = append(logins, login)
+	}
+
+	push := planLogin{Purpose: "push", Registry: p.Login.Registry}
+	switch {
+	case p.Login.Password != "":
+		push.Username = p.Login.Username
+		push.Method = "password"
+	case p.Login.AccessToken != "":
+		push.Username = "oauth2accesstoken"
+		push.Method = "access-token"
+	case p.Login.Config != "":
+		push.Method = "docker-config"
+	default:
+		push.Method = "anonymous"
+	}
+	for _, helper := range p.Login.CredHelpers {
+		if registry, name, ok := strings.Cut(helper, "="); ok && registry == p.Login.Registry {
+			push.Method = "credential-helper:" + name
+		}
+	}
+	return append(logins, push)
+}
+
+func planBuildSettings(build Build) *planBuild {
+	args := build.Args
+	if build.IsMultipleBuildArgs {
+		args = build.ArgsNew
+	}
+	plan := &planBuild{
+		Dockerfile: build.Dockerfile,
+		Context:    build.Context,
+		Target:     build.Target,
+		Platform:   build.Platform,
+		Builder:    build.Builder,
+		CacheFrom:  build.CacheFrom,
+		Labels:     build.Labels,
+		Pull:       build.Pull,
+		NoCache:    build.NoCache,
+	}
+	for _, arg := range args {
+		plan.Args = append(plan.Args, secrets.Redact(arg))
+	}
+	for _, key := range build.ArgsEnv {
+		if value := os.Getenv(key); value != "" {
+			plan.Args = append(plan.Args, secrets.Redact(key+"="+value))
+		}
+	}
+	// only the secret ids are part of the plan
+	if id := secretID(build.Secret); id != "" {
+		plan.Secrets = append(plan.Secrets, id)
+	}
+	for _, secret := range append(append([]string{}, build.SecretEnvs...), build.SecretFiles...) {
+		if id, _, ok := strings.Cut(secret, "="); ok && id != "" {
+			plan.Secrets = append(plan.Secrets, id)
+		}
+	}
+	return plan
+}
+
+// secretID returns the id of a --secret argument, e.g. id=foo,src=bar.
+func secretID(secret string) string {
+	for _, field := range strings.Split(secret, ",") {
+		if id, ok := strings.CutPrefix(field, "id="); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+func planCommand(cmd *exec.Cmd) string {
+	return secrets.Redact("docker " + strings.Join(cmd.Args[1:], " "))
+}
+
+// printPlan writes the plan as a readable table.
+func printPlan(out io.Writer, plan executionPlan) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	row := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "%s\t%s\n", key, value)
+		}
+	}
+
+	fmt.Fprintln(w, "Execution plan")
+	row("Mode", plan.Mode)
+	daemon := plan.Daemon.Mode
+	if plan.Daemon.Host != "" {
+		daemon += " (" + plan.Daemon.Host + ")"
+	}
+	if plan.Daemon.TLS {
+		daemon += ", tls"
+	}
+	row("Daemon", daemon)
+	row("BuildKit", plan.Daemon.BuildkitHost)
+	row("Mirror", plan.Daemon.Mirror)
+	for _, login := range plan.Logins {
+		value := fmt.Sprintf("%s via %s", login.Registry, login.Method)
+		if login.Registry == "" {
+			value = "default registry via " + login.Method
+		}
+		if login.Username != "" {
+			value += " as " + login.Username
+		}
+		row("Login ("+login.Purpose+")", value)
+	}
+	if b := plan.Build; b != nil {
+		row("Dockerfile", b.Dockerfile)
+		row("Context", b.Context)
+		row("Target", b.Target)
+		row("Platform", b.Platform)
+		row("Builder", b.Builder)
+		row("Build args", strings.Join(b.Args, ", "))
+		row("Build secrets", strings.Join(b.Secrets, ", "))
+		row("Cache from", strings.Join(b.CacheFrom, ", "))
+		row("Labels", strings.Join(b.Labels, ", "))
+	}
+	for _, image := range plan.Images {
+		row("Image "+image.Name, fmt.Sprintf("%s (%s)", image.Build.Dockerfile, image.Build.Context))
+	}
+	if plan.Bake != nil {
+		row("Bake files", strings.Join(plan.Bake.Files, ", "))
+		row("Bake targets", strings.Join(plan.Bake.Targets, ", "))
+	}
+	if t := plan.Test; t != nil {
+		row("Test target", t.Target)
+		row("Test reports", strings.Join(t.Reports, ", "))
+	}
+	if e := plan.Export; e != nil {
+		row("Export", fmt.Sprintf("%s to %s", e.Type, e.Dest))
+		row("Export target", e.Target)
+	}
+	if plan.Smoke != nil {
+		row("Smoke tests", strings.Join(plan.Smoke.Tests, ", "))
+	}
+	if plan.Changes != nil {
+		row("Watched paths", strings.Join(plan.Changes.Paths, ", "))
+		row("Ignored paths", strings.Join(plan.Changes.PathsIgnore, ", "))
+	}
+	row("Content hash", plan.ContentHash)
+	row("Source image", plan.SourceImage)
+	row("Destinations", strings.Join(plan.Destinations, ", "))
+	row("Push", fmt.Sprint(plan.Push))
+	if plan.Signing != nil {
+		row("Signing", fmt.Sprintf("%s with %s key", plan.Signing.Tool, plan.Signing.Key))
+	}
+	row("Cleanup", fmt.Sprint(plan.Cleanup))
+	for i, cmd := range plan.Commands {
+		row(fmt.Sprintf("Step %d", i+1), cmd)
+	}
+	w.Flush()
+}
@@ -0,0 +1,235 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-plugins/drone-docker/internal/docker"
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
+)
+
+// pushErrorClass categorizes a failed docker push to decide how it is
+// retried.
+type pushErrorClass string
+
+const (
+	pushErrorUnknown      pushErrorClass = "unknown"
+	pushErrorUnauthorized pushErrorClass = "unauthorized"
+	pushErrorDenied       pushErrorClass = "denied"
+	pushErrorRateLimited  pushErrorClass = "rate-limited"
+	pushErrorNetwork      pushErrorClass = "network"
+	pushErrorBlobUpload   pushErrorClass = "blob-upload"
+)
+
+// maxPushBackoff caps the exponential backoff between push attempts.
+const maxPushBackoff = time.Minute
+
+// pushErrorMarkers maps the messages printed by docker push to an error
+// class. The classes are checked in order, so an expired token reported
+// while uploading a blob is handled as an authentication failure. Markers
+// ending with a newline only match at the end of a line.
+var pushErrorMarkers = []struct {
+	class   pushErrorClass
+	markers []string
+}{
+	{pushErrorDenied, []string{
+		"requested access to the resource is denied",
+	}},
+	{pushErrorUnauthorized, []string{
+		"unauthorized",
+		"authentication required",
+		"token has expired",
+		"token is expired",
+		"expired token",
+		"401 unauthorized",
+	}},
+	{pushErrorRateLimited, []string{
+		"toomanyrequests",
+		"too many requests",
+	}},
+	{pushErrorBlobUpload, []string{
+		"blob upload unknown",
+		"blob upload invalid",
+		"blob unknown",
+		"digest invalid",
+		"error uploading layer",
+		"unexpected http status: 5",
+		"500 internal server error",
+	}},
+	{pushErrorNetwork, []string{
+		"connection reset by peer",
+		"connection refused",
+		"broken pipe",
+		"i/o timeout",
+		"tls handshake timeout",
+		"no such host",
+		"net/http: request canceled",
+		"unexpected eof",
+		": eof\n",
+		"502 bad gateway",
+		"503 service unavailable",
+		"504 gateway timeout",
+	}},
+}
+
+// classifyPushError returns the class of a push failure from its output.
+func classifyPushError(output string) pushErrorClass {
+	output = strings.ToLower(strings.ReplaceAll(output, "\r\n", "\n")) + "\n"
+	for _, entry := range pushErrorMarkers {
+		for _, marker := range entry.markers {
+			if strings.Contains(output, marker) {
+				return entry.class
+			}
+		}
+	}
+	return pushErrorUnknown
+}
+
+// transient reports whether a push failing with this class may succeed when
+// simply retried.
+func (c pushErrorClass) transient() bool {
+	return c == pushErrorRateLimited || c == pushErrorNetwork || c == pushErrorBlobUpload
+}
+
+// outputTail is an io.Writer keeping the end of a command output. It is safe
+// for concurrent use by the stdout and stderr copiers of a command.
+type outputTail struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *outputTail) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	const keep = 4096
+	t.buf = append(t.buf, b...)
+	if len(t.buf) > keep {
+		t.buf = t.buf[len(t.buf)-keep:]
+	}
+	return len(b), nil
+}
+
+func (t *outputTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
+
+// sleep waits between push attempts, replaced in tests.
+var sleep = time.Sleep
+
+// push runs a docker push command, retrying it when it fails. Expired
+// credentials are refreshed once through the credential provider and the
+// push retried immediately, whatever the retry budget; transient failures
+// are retried with exponential backoff until the retry budget is spent.
+func (p Plugin) push(cmd *exec.Cmd) error {
+	start := time.Now()
+	err := p.pushWithRetry(cmd)
+
+	image := cmd.Args[len(cmd.Args)-1]
+	var digest string
+	if err == nil && (p.report != nil || p.tracing()) {
+		digest, _ = getDigestAfterPush(image)
+	}
+	p.report.recordPush(image, digest, start, err)
+	repo, tag := splitImageTag(image)
+	p.recordSpan("docker.push", start, err,
+		telemetry.AttrRepo.String(repo),
+		telemetry.AttrTag.String(tag),
+		telemetry.AttrDigest.String(digest),
+		telemetry.AttrRegistry.String(p.Login.Registry),
+		telemetry.AttrRegistryType.String(string(p.Daemon.RegistryType)),
+	)
+	return err
+}
+
+func (p Plugin) pushWithRetry(cmd *exec.Cmd) error {
+	backoff := p.PushBackoff
+	retried := 0
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			cmd = cloneCommand(cmd)
+		}
+		output := &outputTail{}
+		err := runPrefixedCommand(cmd, p.outputPrefix, output)
+		if err == nil {
+			return nil
+		}
+
+		class := classifyPushError(output.String())
+		// the refresh has its own attempt, outside of the retry budget
+		if class == pushErrorUnauthorized && !refreshed {
+			fmt.Printf("Push was rejected as unauthorized, refreshing the credentials for %s\n", p.Login.Registry)
+			if refreshErr := p.refreshCredentials(); refreshErr != nil {
+				return fmt.Errorf("push failed (%s) and the credentials could not be refreshed: %s: %w", class, refreshErr, err)
+			}
+			refreshed = true
+			continue
+		}
+		if retried >= p.PushRetries {
+			return fmt.Errorf("push failed (%s) after %d attempt(s): %w", class, attempt+1, err)
+		}
+		switch {
+		case class.transient():
+			retried++
+			fmt.Printf("Push failed (%s), retrying in %s (attempt %d of %d)\n", class, backoff, retried, p.PushRetries)
+			sleep(backoff)
+			backoff *= 2
+			if backoff > maxPushBackoff {
+				backoff = maxPushBackoff
+			}
+		default:
+			return fmt.Errorf("push failed (%s): %w", class, err)
+		}
+	}
+}
+
+// refreshCredentials mints fresh credentials for the push registry through
+// the credential helper that produced them and logs in again.
+func (p Plugin) refreshCredentials() error {
+	if p.Login.CredentialProvider == "" {
+		return errors.New("no credential provider is available for the registry")
+	}
+	creds, err := docker.GetHelperCredentials(p.Login.CredentialProvider, p.Login.Registry)
+	if err != nil {
+		return err
+	}
+	secrets.Add(creds.Secret)
+
+	start := time.Now()
+	cmd := commandLogin(Login{
+		Registry: p.Login.Registry,
+		Username: creds.Username,
+		Password: creds.Secret,
+	})
+	raw, err := cmd.CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("error authenticating to %s: %s", p.Login.Registry, loginFailureReason(string(raw), err))
+	}
+	p.recordLoginSpan(p.Login.Registry, "refresh", start, err)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Refreshed the credentials for %s\n", p.Login.Registry)
+	return nil
+}
+
+// cloneCommand returns an unstarted copy of cmd so it can be run again.
+func cloneCommand(cmd *exec.Cmd) *exec.Cmd {
+	clone := exec.Command(cmd.Path, cmd.Args[1:]...)
+	clone.Args = cmd.Args
+	clone.Env = cmd.Env
+	clone.Dir = cmd.Dir
+	return clone
+}
+
+// helper to check if args match "docker push"
+func isCommandPush(args []string) bool {
+	return len(args) > 2 && args[1] == "push"
+}
@@ -0,0 +1,149 @@
+package docker
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyPushError(t *testing.T) {
+	tcs := []struct {
+		output string
+		want   pushErrorClass
+	}{
+		{"unauthorized: authentication required", pushErrorUnauthorized},
+		{"denied: Your authorization token has expired. Reauthenticate and try again.", pushErrorUnauthorized},
+		{"toomanyrequests: too many requests, please retry later", pushErrorRateLimited},
+		{"blob upload unknown: blob upload unknown to registry", pushErrorBlobUpload},
+		{"received unexpected HTTP status: 500 Internal Server Error", pushErrorBlobUpload},
+		{"Put https://gcr.io/v2/: read tcp 10.0.0.1:443: connection reset by peer", pushErrorNetwork},
+		{"Head https://myregistry.azurecr.io/v2/: net/http: TLS handshake timeout", pushErrorNetwork},
+		{"received unexpected HTTP status: 429 Too Many Requests", pushErrorRateLimited},
+		{"Put https://gcr.io/v2/octocat/hello/blobs/uploads/: EOF", pushErrorNetwork},
+		{"Put https://gcr.io/v2/octocat/hello/blobs/uploads/: unexpected EOF\r\n", pushErrorNetwork},
+		{"denied: requested access to the resource is denied", pushErrorDenied},
+		{"tag does not exist: octocat/hello:latest", pushErrorUnknown},
+		{"manifest invalid: layer sha256:4291a2ac9e7f84290e4e2a2be5b5b8b4e7a3c4d2f1a6429b0c0d1e2f3a4b5c6d", pushErrorUnknown},
+		{"failed to push octocat/hello:1.429.0: eof marker missing", pushErrorUnknown},
+	}
+	for _, tc := range tcs {
+		if got := classifyPushError(tc.output); got != tc.want {
+			t.Errorf("classifyPushError(%q) = %s, want %s", tc.output, got, tc.want)
+		}
+	}
+}
+
+// fakePush returns a command failing with the given outputs before
+// succeeding, counting its attempts in a file.
+func fakePush(t *testing.T, failures ...string) (*exec.Cmd, func() int) {
+	t.Helper()
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "attempts")
+	script := filepath.Join(dir, "push.sh")
+
+	var body strings.Builder
+	body.WriteString("#!/bin/sh\n")
+	body.WriteString("n=$(cat " + counter + " 2>/dev/null || echo 0)\n")
+	body.WriteString("n=$((n+1))\necho $n > " + counter + "\n")
+	for i, failure := range failures {
+		body.WriteString("if [ $n -eq " + strconv.Itoa(i+1) + " ]; then echo '" + failure + "' >&2; exit 1; fi\n")
+	}
+	body.WriteString("exit 0\n")
+	if err := os.WriteFile(script, []byte(body.String()), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := func() int {
+		data, _ := os.ReadFile(counter)
+		n, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		return n
+	}
+	return exec.Command(script, "push", "octocat/hello:latest"), attempts
+}
+
+func TestPushRetriesTransientFailures(t *testing.T) {
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	defer func() { sleep = time.Sleep }()
+
+	cmd, attempts := fakePush(t, "connection reset by peer", "toomanyrequests: slow down")
+	p := Plugin{PushRetries: 3, PushBackoff: time.Second}
+	if err := p.push(cmd); err != nil {
+		t.Fatalf("Expected the push to succeed, got %s", err)
+	}
+	if got := attempts(); got != 3 {
+		t.Errorf("Got %d attempts, want 3", got)
+	}
+	if len(delays) != 2 || delays[0] != time.Second || delays[1] != 2*time.Second {
+		t.Errorf("Got backoff delays %v, want [1s 2s]", delays)
+	}
+}
+
+func TestPushRetryBudget(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	cmd, attempts := fakePush(t, "i/o timeout", "i/o timeout", "i/o timeout")
+	p := Plugin{PushRetries: 1, PushBackoff: time.Second}
+	err := p.push(cmd)
+	if err == nil {
+		t.Fatal("Expected the push to fail once the retry budget is spent")
+	}
+	if !strings.Contains(err.Error(), "network") {
+		t.Errorf("Expected the error to carry the failure class, got %s", err)
+	}
+	if got := attempts(); got != 2 {
+		t.Errorf("Got %d attempts, want 2", got)
+	}
+}
+
+func TestPushDoesNotRetryUnknownFailures(t *testing.T) {
+	cmd, attempts := fakePush(t, "manifest invalid")
+	p := Plugin{PushRetries: 3, PushBackoff: time.Second}
+	if err := p.push(cmd); err == nil {
+		t.Fatal("Expected the push to fail")
+	}
+	if got := attempts(); got != 1 {
+		t.Errorf("Got %d attempts, want 1", got)
+	}
+}
+
+func TestPushUnauthorizedWithoutProvider(t *testing.T) {
+	cmd, attempts := fakePush(t, "unauthorized: authentication required")
+	p := Plugin{PushRetries: 3, PushBackoff: time.Second}
+	err := p.push(cmd)
+	if err == nil || !strings.Contains(err.Error(), "could not be refreshed") {
+		t.Fatalf("Expected a refresh failure, got %v", err)
+	}
+	if got := attempts(); got != 1 {
+		t.Errorf("Got %d attempts, want 1", got)
+	}
+}
+
+func TestPushDeniedIsFatal(t *testing.T) {
+	cmd, attempts := fakePush(t, "denied: requested access to the resource is denied")
+	p := Plugin{PushRetries: 3, PushBackoff: time.Second}
+	err := p.push(cmd)
+	if err == nil || !strings.Contains(err.Error(), "push failed (denied)") {
+		t.Fatalf("Expected a denied push failure, got %v", err)
+	}
+	if got := attempts(); got != 1 {
+		t.Errorf("Got %d attempts, want 1", got)
+	}
+}
+
+func TestPushRefreshesWithoutRetries(t *testing.T) {
+	cmd, attempts := fakePush(t, "unauthorized: authentication required")
+	p := Plugin{PushRetries: 0}
+	err := p.push(cmd)
+	if err == nil || !strings.Contains(err.Error(), "could not be refreshed") {
+		t.Fatalf("Expected the credentials to be refreshed, got %v", err)
+	}
+	if got := attempts(); got != 1 {
+		t.Errorf("Got %d attempts, want 1", got)
+	}
+}
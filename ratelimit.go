@@ -0,0 +1,341 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-plugins/drone-docker/internal/docker"
+)
+
+// Docker Hub endpoints used to query the pull rate limit. The preview
+// repository is provided by Docker for this purpose; a HEAD request against
+// it does not count towards the limit.
+var (
+	dockerHubAuthURL     = "https://auth.docker.io/token"
+	dockerHubRegistryURL = "https://registry-1.docker.io"
+)
+
+const (
+	dockerHubRateLimitRepo = "ratelimitpreview/test"
+	dockerHubQuotaTimeout  = 5 * time.Second
+)
+
+// rateLimitMarkers are the messages the Docker daemon and BuildKit print when
+// a pull is rejected by the Docker Hub rate limit.
+var rateLimitMarkers = [][]byte{
+	[]byte("toomanyrequests"),
+	[]byte("you have reached your pull rate limit"),
+}
+
+// rateLimitDetector is an io.Writer that watches command output for Docker
+// Hub rate limit errors. It is safe for concurrent use by the stdout and
+// stderr copiers of a command.
+type rateLimitDetector struct {
+	mu       sync.Mutex
+	tail     []byte
+	detected bool
+}
+
+func (d *rateLimitDetector) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data := bytes.ToLower(append(d.tail, b...))
+	for _, marker := range rateLimitMarkers {
+		if bytes.Contains(data, marker) {
+			d.detected = true
+		}
+	}
+	// keep enough of the output to match a marker split across writes
+	const keep = 64
+	if len(data) > keep {
+		data = data[len(data)-keep:]
+	}
+	d.tail = append(d.tail[:0], data...)
+	return len(b), nil
+}
+
+// Detected reports whether a rate limit error was seen.
+func (d *rateLimitDetector) Detected() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.detected
+}
+
+// dockerHubQuota holds the Docker Hub pull rate limit reported by the
+// registry for the current credentials or IP address.
+type dockerHubQuota struct {
+	Limit     int
+	Remaining int
+	Window    time.Duration
+	Source    string
+	Unlimited bool
+}
+
+func (q dockerHubQuota) String() string {
+	if q.Unlimited {
+		return "no pull rate limit applies"
+	}
+	return fmt.Sprintf("%d of %d pulls remaining per %s (source %s)", q.Remaining, q.Limit, q.Window, q.Source)
+}
+
+// fetchDockerHubQuota queries the remaining Docker Hub pull quota through the
+// ratelimit headers. Anonymous quota is returned when no credentials are
+// given.
+func fetchDockerHubQuota(username, password string) (dockerHubQuota, error) {
+	client := &http.Client{Timeout: dockerHubQuotaTimeout}
+
+	params := url.Values{
+		"service": {"registry.docker.io"},
+		"scope":   {fmt.Sprintf("repository:%s:pull", dockerHubRateLimitRepo)},
+	}
+	req, err := http.NewRequest(http.MethodGet, dockerHubAuthURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return dockerHubQuota{}, err
+	}
+	if username != "" && password != "" {
+		req.SetBasicAuth(username, password)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return dockerHubQuota{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return dockerHubQuota{}, fmt.Errorf("token request failed with status %d", res.StatusCode)
+	}
+	var token struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return dockerHubQuota{}, fmt.Errorf("failed to decode token response: %w", err)
+	}
+
+	req, err = http.NewRequest(http.MethodHead, fmt.Sprintf("%s/v2/%s/manifests/latest", dockerHubRegistryURL, dockerHubRateLimitRepo), nil)
+	if err != nil {
+		return dockerHubQuota{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	res, err = client.Do(req)
+	if err != nil {
+		return dockerHubQuota{}, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusTooManyRequests {
+		return dockerHubQuota{}, fmt.Errorf("manifest request failed with status %d", res.StatusCode)
+	}
+	return parseDockerHubQuota(res.Header)
+}
+
+// parseDockerHubQuota parses the ratelimit-limit and ratelimit-remaining
+// headers, formatted as "<count>;w=<window seconds>".
+func parseDockerHubQuota(header http.Header) (dockerHubQuota, error) {
+	limitHeader := header.Get("ratelimit-limit")
+	remainingHeader := header.Get("ratelimit-remaining")
+	if limitHeader == "" && remainingHeader == "" {
+		return dockerHubQuota{Unlimited: true}, nil
+	}
+
+	limit, window, err := parseRateLimitHeader(limitHeader)
+	if err != nil {
+		return dockerHubQuota{}, fmt.Errorf("invalid ratelimit-limit header %q: %w", limitHeader, err)
+	}
+	remaining, _, err := parseRateLimitHeader(remainingHeader)
+	if err != nil {
+		return dockerHubQuota{}, fmt.Errorf("invalid ratelimit-remaining header %q: %w", remainingHeader, err)
+	}
+	return dockerHubQuota{
+		Limit:     limit,
+		Remaining: remaining,
+		Window:    window,
+		Source:    header.Get("docker-ratelimit-source"),
+	}, nil
+}
+
+func parseRateLimitHeader(value string) (int, time.Duration, error) {
+	count, params, _ := strings.Cut(value, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil {
+		return 0, 0, err
+	}
+	var window time.Duration
+	if w, ok := strings.CutPrefix(strings.TrimSpace(params), "w="); ok {
+		if seconds, err := strconv.Atoi(w); err == nil {
+			window = time.Duration(seconds) * time.Second
+		}
+	}
+	return n, window, nil
+}
+
+// dockerHubCredentials returns the Docker Hub credentials known to the
+// plugin, preferring the base image connectors over the push registry.
+func (p Plugin) dockerHubCredentials() (string, string) {
+	for _, connector := range p.baseImageConnectors() {
+		if connector.Config == "" && isDockerHubRegistry(connector.Registry) {
+			return connector.Username, connector.Password
+		}
+	}
+	if p.Login.Password != "" && isDockerHubRegistry(p.Login.Registry) {
+		return p.Login.Username, p.Login.Password
+	}
+	return "", ""
+}
+
+// reportDockerHubQuota logs the remaining Docker Hub pull quota when the
+// build pulls images from Docker Hub. Failures are logged and ignored.
+func (p Plugin) reportDockerHubQuota() {
+	if !p.pullsFromDockerHub() {
+		return
+	}
+	quota, err := fetchDockerHubQuota(p.dockerHubCredentials())
+	if err != nil {
+		fmt.Printf("Could not determine the Docker Hub pull rate limit: %s\n", err)
+		return
+	}
+	fmt.Printf("Docker Hub rate limit: %s\n", quota)
+	if !quota.Unlimited && quota.Remaining == 0 && p.rateLimitMirror() == "" {
+		fmt.Println("\033[33mThe Docker Hub pull quota is exhausted and no mirror is configured; pulls from Docker Hub will fail.\033[0m")
+	}
+}
+
+// pullsFromDockerHub reports whether the Dockerfile or the cache-from images
+// reference Docker Hub.
+func (p Plugin) pullsFromDockerHub() bool {
+	images, _ := dockerfileBaseImages(p.Build.Dockerfile)
+	images = append(images, p.Build.CacheFrom...)
+	for _, image := range images {
+		if isDockerHubImage(image) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitMirror returns the registry used to retry rate limited pulls.
+func (p Plugin) rateLimitMirror() string {
+	if p.Daemon.PullThroughCache != "" {
+		return p.Daemon.PullThroughCache
+	}
+	return p.Daemon.Mirror
+}
+
+// pullThroughMirror pulls a Docker Hub image through the mirror and tags it
+// with its original name so builds resolve it locally.
+func (p Plugin) pullThroughMirror(image string) error {
+	mirror := p.rateLimitMirror()
+	if mirror == "" {
+		return errors.New("no registry mirror or pull-through cache configured")
+	}
+	mirrored := mirrorReference(mirror, image)
+	fmt.Printf("Docker Hub rate limit reached, pulling %s through %s\n", image, mirrored)
+
+	cmds := []*exec.Cmd{
+		commandPull(mirrored),
+		exec.Command(dockerExe, "tag", mirrored, image),
+	}
+	for _, cmd := range cmds {
+		if err := runCommand(cmd); err != nil {
+			return fmt.Errorf("could not pull %s through mirror %s: %w", image, mirror, err)
+		}
+	}
+	return nil
+}
+
+// prefetchBaseImagesThroughMirror pulls every Docker Hub base image of the
+// Dockerfile through the mirror, so a rate limited build can be retried.
+func (p Plugin) prefetchBaseImagesThroughMirror() error {
+	images, err := dockerfileBaseImages(p.Build.Dockerfile)
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if !isDockerHubImage(image) {
+			continue
+		}
+		if err := p.pullThroughMirror(image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isDockerHubRegistry reports whether registry refers to Docker Hub.
+func isDockerHubRegistry(registry string) bool {
+	return docker.NormalizeRegistry(registry) == docker.NormalizeRegistry("docker.io")
+}
+
+// isDockerHubImage reports whether an image reference resolves to Docker Hub.
+func isDockerHubImage(image string) bool {
+	first, _, found := strings.Cut(image, "/")
+	if !found {
+		return true
+	}
+	if first == "localhost" || strings.ContainsAny(first, ".:") {
+		return isDockerHubRegistry(first)
+	}
+	return true
+}
+
+// mirrorReference rewrites a Docker Hub image reference to the mirror. The
+// mirror may include a path, as used by pull-through cache projects.
+func mirrorReference(mirror, image string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(mirror, "https://"), "http://")
+	host = strings.TrimSuffix(host, "/")
+
+	ref := image
+	for _, prefix := range []string{"docker.io/", "index.docker.io/", "registry-1.docker.io/"} {
+		ref = strings.TrimPrefix(ref, prefix)
+	}
+	if !strings.Contains(ref, "/") {
+		ref = "library/" + ref
+	}
+	return host + "/" + ref
+}
+
+// dockerfileBaseImages returns the external images referenced by FROM
+// instructions, skipping build stages, scratch and references using build
+// arguments.
+func dockerfileBaseImages(dockerfile string) ([]string, error) {
+	f, err := os.Open(dockerfile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stages := map[string]bool{}
+	var images []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		image := fields[0]
+		if len(fields) >= 3 && strings.EqualFold(fields[1], "AS") {
+			stages[strings.ToLower(fields[2])] = true
+		}
+		if image == "scratch" || strings.Contains(image, "$") || stages[strings.ToLower(image)] {
+			continue
+		}
+		images = append(images, image)
+	}
+	return images, scanner.Err()
+}
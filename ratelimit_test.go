@@ -0,0 +1,170 @@
+package docker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRateLimitDetector(t *testing.T) {
+	d := &rateLimitDetector{}
+	d.Write([]byte("Step 1/4 : FROM golang:1.22\n"))
+	if d.Detected() {
+		t.Fatalf("unexpected rate limit detection")
+	}
+	// marker split across two writes
+	d.Write([]byte("Error response from daemon: toomany"))
+	d.Write([]byte("requests: You have reached your pull rate limit.\n"))
+	if !d.Detected() {
+		t.Errorf("expected rate limit to be detected")
+	}
+}
+
+func TestParseDockerHubQuota(t *testing.T) {
+	header := http.Header{}
+	header.Set("ratelimit-limit", "100;w=21600")
+	header.Set("ratelimit-remaining", "76;w=21600")
+	header.Set("docker-ratelimit-source", "203.0.113.7")
+
+	got, err := parseDockerHubQuota(header)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := dockerHubQuota{Limit: 100, Remaining: 76, Window: 6 * time.Hour, Source: "203.0.113.7"}
+	if got != want {
+		t.Errorf("Got quota %+v, want %+v", got, want)
+	}
+
+	got, err = parseDockerHubQuota(http.Header{})
+	if err != nil || !got.Unlimited {
+		t.Errorf("expected unlimited quota without headers, got %+v, %v", got, err)
+	}
+
+	header.Set("ratelimit-remaining", "lots")
+	if _, err := parseDockerHubQuota(header); err == nil {
+		t.Errorf("expected an error for an invalid header")
+	}
+}
+
+func TestFetchDockerHubQuota(t *testing.T) {
+	var gotUser string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			gotUser, _, _ = r.BasicAuth()
+			w.Write([]byte(`{"token": "abc"}`))
+		case "/v2/ratelimitpreview/test/manifests/latest":
+			if r.Header.Get("Authorization") != "Bearer abc" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("ratelimit-limit", "200;w=21600")
+			w.Header().Set("ratelimit-remaining", "150;w=21600")
+			w.Header().Set("docker-ratelimit-source", "octocat")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	origAuth, origRegistry := dockerHubAuthURL, dockerHubRegistryURL
+	dockerHubAuthURL, dockerHubRegistryURL = server.URL+"/token", server.URL
+	defer func() { dockerHubAuthURL, dockerHubRegistryURL = origAuth, origRegistry }()
+
+	quota, err := fetchDockerHubQuota("octocat", "secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if quota.Limit != 200 || quota.Remaining != 150 || quota.Source != "octocat" {
+		t.Errorf("unexpected quota %+v", quota)
+	}
+	if gotUser != "octocat" {
+		t.Errorf("expected credentials to be sent, got user %q", gotUser)
+	}
+}
+
+func TestIsDockerHubImage(t *testing.T) {
+	tcs := map[string]bool{
+		"alpine":                           true,
+		"alpine:3.19":                      true,
+		"octocat/hello":                    true,
+		"docker.io/library/golang:1":       true,
+		"index.docker.io/octocat/app":      true,
+		"quay.io/prometheus/node":          false,
+		"localhost/app":                    false,
+		"registry:5000/app":                false,
+		"gcr.io/distroless/static:nonroot": false,
+	}
+	for image, want := range tcs {
+		if got := isDockerHubImage(image); got != want {
+			t.Errorf("isDockerHubImage(%q) = %t, want %t", image, got, want)
+		}
+	}
+}
+
+func TestMirrorReference(t *testing.T) {
+	tcs := []struct {
+		mirror, image, want string
+	}{
+		{"https://mirror.gcr.io", "alpine:3.19", "mirror.gcr.io/library/alpine:3.19"},
+		{"mirror.example.com/", "octocat/hello", "mirror.example.com/octocat/hello"},
+		{"harbor.example.com/dockerhub-proxy", "docker.io/library/golang:1.22", "harbor.example.com/dockerhub-proxy/library/golang:1.22"},
+	}
+	for _, tc := range tcs {
+		if got := mirrorReference(tc.mirror, tc.image); got != tc.want {
+			t.Errorf("mirrorReference(%q, %q) = %q, want %q", tc.mirror, tc.image, got, tc.want)
+		}
+	}
+}
+
+func TestDockerfileBaseImages(t *testing.T) {
+	dockerfile := filepath.Join(t.TempDir(), "Dockerfile")
+	content := `ARG BASE=alpine
+FROM --platform=$BUILDPLATFORM golang:1.22 AS builder
+RUN go build ./...
+FROM ${BASE} AS base
+FROM builder AS test
+FROM scratch
+from gcr.io/distroless/static:nonroot
+COPY --from=builder /app /app
+`
+	if err := os.WriteFile(dockerfile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := dockerfileBaseImages(dockerfile)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []string{"golang:1.22", "gcr.io/distroless/static:nonroot"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got images %v, want %v", got, want)
+	}
+}
+
+func TestIsCommandBuild(t *testing.T) {
+	if !isCommandBuild(commandBuild(Build{}).Args) {
+		t.Errorf("expected docker build to be detected")
+	}
+	if !isCommandBuild(commandBuild(Build{Builder: "remote"}).Args) {
+		t.Errorf("expected docker buildx build to be detected")
+	}
+	if isCommandBuild(commandPull("alpine").Args) {
+		t.Errorf("unexpected build detection for docker pull")
+	}
+}
+
+func TestRetryRateLimitedRemoteBuilder(t *testing.T) {
+	p := Plugin{
+		Daemon: Daemon{Mirror: "https://mirror.example.com"},
+		Build:  Build{Builder: "drone-remote", Dockerfile: "Dockerfile", Pull: true},
+	}
+	buildErr := errors.New("toomanyrequests")
+	if err := p.retryRateLimited(commandBuild(p.Build), buildErr); err != buildErr {
+		t.Errorf("Expected the build error without a retry, got %v", err)
+	}
+}
@@ -0,0 +1,296 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/drone-plugins/drone-docker/internal/docker"
+)
+
+const (
+	// redactedValue replaces every secret in plugin output.
+	redactedValue = "********"
+
+	// minSecretLength is the length below which values are not redacted, to
+	// avoid masking unrelated output.
+	minSecretLength = 4
+
+	// maxPendingOutput bounds the output held back while waiting for the end
+	// of a line.
+	maxPendingOutput = 32 * 1024
+)
+
+var (
+	// credentialPatterns match credential-shaped substrings regardless of
+	// whether the plugin has seen the value.
+	credentialPatterns = []struct {
+		re   *regexp.Regexp
+		repl string
+	}{
+		// user:password@ in URLs, e.g. proxy URLs
+		{regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]+):[^@/\s]+@`), "${1}:" + redactedValue + "@"},
+		// bearer tokens in headers
+		{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]{8,}`), "${1}" + redactedValue},
+	}
+
+	// secretArgKeys are the "_" separated segments of build arg names whose
+	// values are treated as secrets. Whole segments are matched, so that
+	// AUTHOR or AUTH_URL are not taken for credentials.
+	secretArgKeys = []string{"TOKEN", "PASSWORD", "PASSWD", "SECRET", "CREDENTIAL", "CREDENTIALS", "API_KEY", "APIKEY", "PRIVATE_KEY", "AUTH_TOKEN", "BASIC_AUTH"}
+)
+
+// secrets is the redaction layer shared by every command and log line of the
+// plugin.
+var secrets = newRedactor()
+
+// redactor masks known secret values and credential-shaped substrings.
+type redactor struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+func newRedactor() *redactor {
+	return &redactor{}
+}
+
+// Add registers secret values. Short and duplicate values are ignored.
+func (r *redactor) Add(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if len(value) < minSecretLength {
+			continue
+		}
+		var known bool
+		for _, v := range r.values {
+			if v == value {
+				known = true
+				break
+			}
+		}
+		if !known {
+			r.values = append(r.values, value)
+		}
+	}
+	// replace the longest values first so a secret containing another one is
+	// fully masked
+	sort.Slice(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+}
+
+// Redact masks every registered secret and credential-shaped substring in s.
+func (r *redactor) Redact(s string) string {
+	r.mu.RLock()
+	for _, value := range r.values {
+		s = strings.ReplaceAll(s, value, redactedValue)
+	}
+	r.mu.RUnlock()
+	for _, p := range credentialPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// RedactError returns err with its message redacted. The original error
+// remains available through errors.Unwrap.
+func (r *redactor) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err: err, msg: r.Redact(err.Error())}
+}
+
+// Writer returns a writer relaying redacted output to out. Output is held
+// back until the end of a line, so call Flush once writing is done.
+func (r *redactor) Writer(out io.Writer) *redactWriter {
+	return &redactWriter{out: out, r: r}
+}
+
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactWriter redacts output line by line, so that secrets written across
+// several writes are still masked.
+type redactWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+	r   *redactor
+	buf []byte
+}
+
+func (w *redactWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, b...)
+	if i := bytes.LastIndexAny(w.buf, "\r\n"); i >= 0 {
+		line := string(w.buf[:i+1])
+		w.buf = append([]byte(nil), w.buf[i+1:]...)
+		if _, err := io.WriteString(w.out, w.r.Redact(line)); err != nil {
+			return 0, err
+		}
+	}
+	if len(w.buf) > maxPendingOutput {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush writes any output held back.
+func (w *redactWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush()
+}
+
+func (w *redactWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.out, w.r.Redact(string(w.buf)))
+	w.buf = nil
+	return err
+}
+
+// attachOutput relays the output of cmd to stdout and stderr through the
+// redaction layer, also copying it to the extra writers. The returned
+// function flushes held back output and must be called once cmd finished.
+func attachOutput(cmd *exec.Cmd, extra ...io.Writer) (flush func()) {
+	return attachPrefixedOutput(cmd, "", extra...)
+}
+
+// attachPrefixedOutput is attachOutput with every line of the relayed output
+// starting with prefix.
+func attachPrefixedOutput(cmd *exec.Cmd, prefix string, extra ...io.Writer) (flush func()) {
+	stdout := secrets.Writer(prefixLines(os.Stdout, prefix))
+	stderr := secrets.Writer(prefixLines(os.Stderr, prefix))
+	cmd.Stdout = io.MultiWriter(append([]io.Writer{stdout}, extra...)...)
+	cmd.Stderr = io.MultiWriter(append([]io.Writer{stderr}, extra...)...)
+	return func() {
+		stdout.Flush()
+		stderr.Flush()
+	}
+}
+
+// runCommand traces and runs cmd with its output relayed through the
+// redaction layer.
+func runCommand(cmd *exec.Cmd, extra ...io.Writer) error {
+	return runPrefixedCommand(cmd, "", extra...)
+}
+
+// runPrefixedCommand is runCommand with the trace and every line of the
+// output starting with prefix.
+func runPrefixedCommand(cmd *exec.Cmd, prefix string, extra ...io.Writer) error {
+	flush := attachPrefixedOutput(cmd, prefix, extra...)
+	defer flush()
+	tracePrefixed(cmd, prefix)
+	return cmd.Run()
+}
+
+// registerSecrets adds every secret value of the plugin configuration to the
+// redaction layer.
+func (p Plugin) registerSecrets() {
+	secrets.Add(
+		p.Login.Password,
+		p.Login.AccessToken,
+		p.BaseImagePassword,
+		p.Cosign.Password,
+		p.Daemon.TLSKey,
+		p.Build.SSHAgentKey,
+		p.Build.ContextGitToken,
+	)
+	// the cosign key is either PEM content or the path of a key file
+	if strings.HasPrefix(strings.TrimSpace(p.Cosign.PrivateKey), "-----BEGIN") {
+		secrets.Add(p.Cosign.PrivateKey)
+	}
+	registerDockerConfigSecrets(p.Login.Config)
+	for _, connector := range p.BaseImageConnectors {
+		secrets.Add(connector.Password)
+		registerDockerConfigSecrets(connector.Config)
+	}
+
+	// values of build args that look like credentials
+	args := append(append([]string{}, p.Build.Args...), p.Build.ArgsNew...)
+	for _, arg := range args {
+		if key, value, ok := strings.Cut(arg, "="); ok && isSecretArgKey(key) {
+			secrets.Add(value)
+		}
+	}
+	for _, key := range p.Build.ArgsEnv {
+		if isSecretArgKey(key) {
+			secrets.Add(os.Getenv(key))
+		}
+	}
+	// smoke test environment passed with docker run -e
+	for _, command := range p.Smoke.Commands {
+		for key, value := range command.Env {
+			if isSecretArgKey(key) {
+				secrets.Add(value)
+			}
+		}
+	}
+	// environment variables exposed to the build as secrets
+	for _, secret := range p.Build.SecretEnvs {
+		if _, env, ok := strings.Cut(secret, "="); ok && env != "" {
+			secrets.Add(os.Getenv(env))
+		}
+	}
+}
+
+// registerImageSecrets adds the values of the image build args that look
+// like credentials to the redaction layer. It runs once the images of the
+// step, including the compose services, are known.
+func (p Plugin) registerImageSecrets() {
+	for _, image := range p.Images {
+		for key, value := range image.Args {
+			if isSecretArgKey(key) {
+				secrets.Add(value)
+			}
+		}
+	}
+}
+
+// registerDockerConfigSecrets adds the credentials of a docker config JSON to
+// the redaction layer.
+func registerDockerConfigSecrets(config string) {
+	if config == "" {
+		return
+	}
+	var c docker.Config
+	if err := json.Unmarshal([]byte(config), &c); err != nil {
+		return
+	}
+	for _, auth := range c.Auths {
+		secrets.Add(auth.Auth, auth.IdentityToken, auth.RegistryToken)
+		if decoded, err := base64.StdEncoding.DecodeString(auth.Auth); err == nil {
+			if _, password, ok := strings.Cut(string(decoded), ":"); ok {
+				secrets.Add(password)
+			}
+		}
+	}
+}
+
+func isSecretArgKey(key string) bool {
+	key = "_" + strings.ToUpper(key) + "_"
+	for _, s := range secretArgKeys {
+		if strings.Contains(key, "_"+s+"_") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// remoteBuilderName is the buildx builder instance registered for a remote
+// buildkitd endpoint.
+const remoteBuilderName = "drone-remote"
+
+// tlsFiles holds the on-disk locations of the client TLS material, using the
+// file names expected by DOCKER_CERT_PATH.
+type tlsFiles struct {
+	Dir    string
+	CACert string
+	Cert   string
+	Key    string
+}
+
+// usesRemoteDaemon reports whether the plugin talks to a remote Docker host
+// instead of starting a local daemon.
+func (d Daemon) usesRemoteDaemon() bool {
+	return d.Host != ""
+}
+
+// hasTLS reports whether client TLS material was supplied.
+func (d Daemon) hasTLS() bool {
+	return d.TLSCACert != "" || d.TLSCert != "" || d.TLSKey != ""
+}
+
+// setupRemoteDaemon points the docker client at the remote Docker host by
+// exporting DOCKER_HOST, and enables mutual TLS when certificates are given.
+func setupRemoteDaemon(daemon Daemon) error {
+	if !strings.HasPrefix(daemon.Host, "tcp://") && !strings.HasPrefix(daemon.Host, "unix://") {
+		return fmt.Errorf("invalid docker host %s: expected a tcp:// or unix:// address", daemon.Host)
+	}
+	os.Setenv("DOCKER_HOST", daemon.Host)
+
+	if !daemon.hasTLS() {
+		fmt.Printf("Using remote Docker host %s\n", daemon.Host)
+		return nil
+	}
+	files, err := writeTLSFiles(daemon, filepath.Join(os.TempDir(), "drone-docker-tls"))
+	if err != nil {
+		return err
+	}
+	os.Setenv("DOCKER_CERT_PATH", files.Dir)
+	os.Setenv("DOCKER_TLS_VERIFY", "1")
+	fmt.Printf("Using remote Docker host %s with TLS client certificates\n", daemon.Host)
+	return nil
+}
+
+// setupRemoteBuilder registers a buildx builder backed by the remote
+// buildkitd endpoint and waits until it is reachable. It returns the name of
+// the builder instance to pass to docker buildx build.
+func setupRemoteBuilder(daemon Daemon) (string, error) {
+	if !strings.HasPrefix(daemon.BuildkitHost, "tcp://") {
+		return "", fmt.Errorf("invalid buildkit host %s: expected a tcp:// address", daemon.BuildkitHost)
+	}
+
+	var files tlsFiles
+	if daemon.hasTLS() {
+		var err error
+		files, err = writeTLSFiles(daemon, filepath.Join(os.TempDir(), "drone-buildkit-tls"))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	cmds := []*exec.Cmd{
+		commandBuilderCreate(daemon.BuildkitHost, files),
+		commandBuilderBootstrap(),
+	}
+	for _, cmd := range cmds {
+		if err := runCommand(cmd); err != nil {
+			return "", fmt.Errorf("unable to reach buildkit at %s: %w", daemon.BuildkitHost, err)
+		}
+	}
+	return remoteBuilderName, nil
+}
+
+// writeTLSFiles validates the client TLS material and writes it to dir.
+func writeTLSFiles(daemon Daemon, dir string) (tlsFiles, error) {
+	if daemon.TLSCACert == "" || daemon.TLSCert == "" || daemon.TLSKey == "" {
+		return tlsFiles{}, fmt.Errorf("tls requires a CA certificate, client certificate and client key")
+	}
+	if _, err := decodePEMCertificates([]byte(daemon.TLSCACert)); err != nil {
+		return tlsFiles{}, fmt.Errorf("invalid tls CA certificate: %w", err)
+	}
+	if _, err := decodePEMCertificates([]byte(daemon.TLSCert)); err != nil {
+		return tlsFiles{}, fmt.Errorf("invalid tls client certificate: %w", err)
+	}
+	if !isValidPEMKey(daemon.TLSKey) {
+		return tlsFiles{}, fmt.Errorf("invalid tls client key: expected PEM format")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return tlsFiles{}, fmt.Errorf("unable to create tls directory: %s", err)
+	}
+	files := tlsFiles{
+		Dir:    dir,
+		CACert: filepath.Join(dir, "ca.pem"),
+		Cert:   filepath.Join(dir, "cert.pem"),
+		Key:    filepath.Join(dir, "key.pem"),
+	}
+	contents := map[string]string{
+		files.CACert: daemon.TLSCACert,
+		files.Cert:   daemon.TLSCert,
+		files.Key:    daemon.TLSKey,
+	}
+	for path, content := range contents {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			return tlsFiles{}, fmt.Errorf("unable to write %s: %s", path, err)
+		}
+	}
+	return files, nil
+}
+
+// helper function to create the docker buildx create command for a remote
+// buildkitd endpoint.
+func commandBuilderCreate(host string, files tlsFiles) *exec.Cmd {
+	args := []string{
+		"buildx", "create",
+		"--name", remoteBuilderName,
+		"--driver", "remote",
+	}
+	if files.Dir != "" {
+		args = append(args, "--driver-opt", fmt.Sprintf("cacert=%s,cert=%s,key=%s", files.CACert, files.Cert, files.Key))
+	}
+	args = append(args, host)
+	return exec.Command(dockerExe, args...)
+}
+
+// helper function to create the docker buildx inspect command used to poll
+// the remote builder until it is ready.
+func commandBuilderBootstrap() *exec.Cmd {
+	return exec.Command(dockerExe, "buildx", "inspect", "--bootstrap", remoteBuilderName)
+}
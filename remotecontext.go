@@ -0,0 +1,346 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
+)
+
+// Build context kinds.
+const (
+	contextLocal   = "local"   // directory of the workspace
+	contextGit     = "git"     // git repository, with an optional ref and subdirectory
+	contextURL     = "url"     // tarball downloaded over HTTP
+	contextArchive = "archive" // tarball of the workspace
+)
+
+// contextDownloadTimeout bounds the download of a tarball context.
+const contextDownloadTimeout = 10 * time.Minute
+
+// contextKind returns the kind of the build context. Git contexts follow the
+// docker build syntax: git@, git:// and ssh:// URLs, github.com/ paths and
+// URLs ending in .git.
+func contextKind(context string) string {
+	url, _, _ := strings.Cut(context, "#")
+	switch {
+	case strings.HasPrefix(url, "git@"),
+		strings.HasPrefix(url, "git://"),
+		strings.HasPrefix(url, "ssh://"),
+		strings.HasPrefix(url, "github.com/"),
+		isHTTPURL(url) && strings.HasSuffix(url, ".git"):
+		return contextGit
+	case isHTTPURL(url):
+		return contextURL
+	}
+	if info, err := os.Stat(context); err == nil && info.Mode().IsRegular() {
+		return contextArchive
+	}
+	return contextLocal
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+// parseGitContext splits a git context, url#ref:subdir, into the repository
+// URL, the ref and the subdirectory.
+func parseGitContext(context string) (url, ref, dir string) {
+	url, fragment, _ := strings.Cut(context, "#")
+	ref, dir, _ = strings.Cut(fragment, ":")
+	if strings.HasPrefix(url, "github.com/") {
+		url = "https://" + url
+	}
+	return url, ref, dir
+}
+
+// parseChecksum returns the hex encoded sha256 of a checksum, given with or
+// without the sha256: prefix.
+func parseChecksum(checksum string) (string, error) {
+	sum := strings.ToLower(strings.TrimPrefix(checksum, "sha256:"))
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid checksum %s, expected sha256:<hex digest>", checksum)
+	}
+	return sum, nil
+}
+
+// fetchContext fetches the git, tarball or archive context of p.Build into
+// dir and returns the directory of the build context in it.
+func (p Plugin) fetchContext(dir string) (context string, err error) {
+	start := time.Now()
+	kind := contextKind(p.Build.Context)
+	defer func() {
+		p.recordSpan("docker.context", start, err, telemetry.AttrRepo.String(p.Build.Repo))
+	}()
+
+	switch kind {
+	case contextGit:
+		url, ref, subdir := parseGitContext(p.Build.Context)
+		fmt.Printf("Fetching the build context from %s\n", url)
+		repo := filepath.Join(dir, "git")
+		if err := fetchGitContext(repo, url, ref, p.Build.SSHAgentKey, p.Build.ContextGitToken); err != nil {
+			return "", err
+		}
+		return filepath.Join(repo, filepath.FromSlash(subdir)), nil
+	case contextURL:
+		fmt.Printf("Downloading the build context from %s\n", p.Build.Context)
+		archive := filepath.Join(dir, "context.tar")
+		if err := downloadFile(p.Build.Context, archive); err != nil {
+			return "", err
+		}
+		defer os.Remove(archive)
+		return extractContext(archive, filepath.Join(dir, "context"), p.Build.ContextChecksum)
+	default:
+		fmt.Printf("Extracting the build context from %s\n", p.Build.Context)
+		return extractContext(p.Build.Context, filepath.Join(dir, "context"), p.Build.ContextChecksum)
+	}
+}
+
+// fetchGitContext checks out the ref of the repository in repo, the
+// default branch when ref is empty. The repository is fetched with the ssh
+// key or the token when set.
+func fetchGitContext(repo, url, ref, sshKey, token string) error {
+	env := os.Environ()
+	if sshKey != "" {
+		keyFile := repo + ".key"
+		if err := os.WriteFile(keyFile, []byte(sshKey), 0600); err != nil {
+			return fmt.Errorf("unable to write the ssh key: %w", err)
+		}
+		defer os.Remove(keyFile)
+		env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new", keyFile))
+	}
+	if token != "" {
+		// the token is passed in the environment, out of the traced commands
+		credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+		secrets.Add(credentials)
+		env = append(env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+credentials,
+		)
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	for _, args := range [][]string{
+		{"init", "-q", repo},
+		{"-C", repo, "remote", "add", "origin", url},
+		{"-C", repo, "fetch", "-q", "--depth", "1", "origin", ref},
+		{"-C", repo, "checkout", "-q", "FETCH_HEAD"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Env = env
+		trace(cmd)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("unable to fetch the build context from %s: %s", url, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// downloadFile downloads the url to path.
+func downloadFile(url, path string) error {
+	client := &http.Client{Timeout: contextDownloadTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("unable to download the build context: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to download the build context: %s", resp.Status)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to download the build context: %w", err)
+	}
+	return f.Close()
+}
+
+// extractContext verifies the checksum of the tarball, when set, and
+// extracts it, gzip compressed or not, to dest.
+func extractContext(archive, dest, checksum string) (string, error) {
+	if checksum != "" {
+		want, err := parseChecksum(checksum)
+		if err != nil {
+			return "", err
+		}
+		got, err := fileChecksum(archive)
+		if err != nil {
+			return "", err
+		}
+		if got != want {
+			return "", fmt.Errorf("checksum mismatch for the build context: got sha256:%s, want sha256:%s", got, want)
+		}
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return "", fmt.Errorf("unable to open the build context: %w", err)
+	}
+	defer f.Close()
+	buffered := bufio.NewReader(f)
+	var reader io.Reader = buffered
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return "", fmt.Errorf("invalid build context archive: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	if err := extractTar(tar.NewReader(reader), dest); err != nil {
+		return "", fmt.Errorf("invalid build context archive: %w", err)
+	}
+	return dest, nil
+}
+
+// extractTar writes the directories, files and symlinks of the archive to
+// dest, rejecting entries outside of it.
+func extractTar(reader *tar.Reader, dest string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !insideDir(header.Name) {
+			return fmt.Errorf("entry %s is outside of the context", header.Name)
+		}
+		// links extracted earlier are resolved, so that later entries cannot
+		// be written through them out of the context
+		target := filepath.Join(root, filepath.FromSlash(header.Name))
+		parent, err := resolveParent(root, target)
+		if err != nil {
+			return err
+		}
+		if !insideRoot(root, parent) {
+			return fmt.Errorf("entry %s is outside of the context", header.Name)
+		}
+		target = filepath.Join(parent, filepath.Base(target))
+		if header.Typeflag != tar.TypeDir {
+			if err := removeNonDir(target); err != nil {
+				return err
+			}
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeTarFile(reader, target, header.FileInfo().Mode().Perm())
+		case tar.TypeSymlink:
+			// a link out of the context would let later entries escape it
+			if filepath.IsAbs(header.Linkname) || !insideRoot(root, filepath.Join(parent, filepath.FromSlash(header.Linkname))) {
+				return fmt.Errorf("link %s points outside of the context", header.Name)
+			}
+			if err = os.MkdirAll(parent, 0755); err == nil {
+				err = os.Symlink(header.Linkname, target)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// resolveParent returns the parent directory of path with the symlinks of
+// its existing part resolved. The missing part is appended as is.
+func resolveParent(root, path string) (string, error) {
+	dir := filepath.Dir(path)
+	var missing []string
+	for dir != root && insideRoot(root, dir) {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(dir)}, missing...)
+		dir = filepath.Dir(dir)
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{resolved}, missing...)...), nil
+}
+
+// removeNonDir removes the file or link at path so that it is replaced, and
+// not written through, by the archive entry.
+func removeNonDir(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil || info.IsDir() {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// insideDir reports whether the relative path stays inside the directory
+// it is relative to.
+func insideDir(name string) bool {
+	name = filepath.Clean(filepath.FromSlash(name))
+	return !filepath.IsAbs(name) && name != ".." && !strings.HasPrefix(name, ".."+string(filepath.Separator))
+}
+
+// insideRoot reports whether the absolute path is root or below it.
+func insideRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && insideDir(rel)
+}
+
+func writeTarFile(reader io.Reader, path string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, reader); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// fileChecksum returns the hex encoded sha256 of the file.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
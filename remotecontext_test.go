@@ -0,0 +1,246 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContextKind(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "context.tar.gz")
+	os.WriteFile(archive, nil, 0644)
+	tcs := []struct {
+		context string
+		want    string
+	}{
+		{".", contextLocal},
+		{"services/api", contextLocal},
+		{archive, contextArchive},
+		{"https://github.com/octocat/hello-world.git", contextGit},
+		{"https://github.com/octocat/hello-world.git#main:app", contextGit},
+		{"git@github.com:octocat/hello-world.git#v1.0.0", contextGit},
+		{"ssh://git@example.com/octocat/hello-world", contextGit},
+		{"github.com/octocat/hello-world", contextGit},
+		{"https://example.com/context.tar.gz", contextURL},
+	}
+	for _, tc := range tcs {
+		if got := contextKind(tc.context); got != tc.want {
+			t.Errorf("contextKind(%q) = %s, want %s", tc.context, got, tc.want)
+		}
+	}
+}
+
+func TestParseGitContext(t *testing.T) {
+	tcs := []struct {
+		context, url, ref, dir string
+	}{
+		{"https://github.com/octocat/app.git", "https://github.com/octocat/app.git", "", ""},
+		{"https://github.com/octocat/app.git#main", "https://github.com/octocat/app.git", "main", ""},
+		{"git@github.com:octocat/app.git#v1.0.0:services/api", "git@github.com:octocat/app.git", "v1.0.0", "services/api"},
+		{"github.com/octocat/app#:docker", "https://github.com/octocat/app", "", "docker"},
+	}
+	for _, tc := range tcs {
+		url, ref, dir := parseGitContext(tc.context)
+		if url != tc.url || ref != tc.ref || dir != tc.dir {
+			t.Errorf("parseGitContext(%q) = %q, %q, %q, want %q, %q, %q", tc.context, url, ref, dir, tc.url, tc.ref, tc.dir)
+		}
+	}
+}
+
+// testArchive returns a tarball of the entries, gzip compressed when zip is
+// set.
+func testArchive(t *testing.T, zip bool, entries ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		if entry.Typeflag == tar.TypeReg && entry.Size == 0 {
+			entry.Size = int64(len(entry.Name))
+		}
+		if err := tw.WriteHeader(entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Typeflag == tar.TypeReg {
+			tw.Write([]byte(entry.Name))
+		}
+	}
+	tw.Close()
+	if !zip {
+		return buf.Bytes()
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(buf.Bytes())
+	zw.Close()
+	return gz.Bytes()
+}
+
+func TestExtractContext(t *testing.T) {
+	entries := []*tar.Header{
+		{Name: "Dockerfile", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "app/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "app/run.sh", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "app/start", Typeflag: tar.TypeSymlink, Linkname: "run.sh"},
+	}
+	for _, zip := range []bool{false, true} {
+		dir := t.TempDir()
+		data := testArchive(t, zip, entries...)
+		archive := filepath.Join(dir, "context.tar")
+		os.WriteFile(archive, data, 0644)
+		sum := sha256.Sum256(data)
+
+		context, err := extractContext(archive, filepath.Join(dir, "context"), "sha256:"+hex.EncodeToString(sum[:]))
+		if err != nil {
+			t.Fatalf("gzip %v: %s", zip, err)
+		}
+		if data, _ := os.ReadFile(filepath.Join(context, "app", "start")); string(data) != "app/run.sh" {
+			t.Errorf("gzip %v: expected the extracted link to the script, got %q", zip, data)
+		}
+		if info, err := os.Stat(filepath.Join(context, "app", "run.sh")); err != nil || info.Mode().Perm() != 0755 {
+			t.Errorf("gzip %v: expected an executable script, got %v, %v", zip, info, err)
+		}
+	}
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "context.tar")
+	os.WriteFile(archive, testArchive(t, false, entries...), 0644)
+	if _, err := extractContext(archive, filepath.Join(dir, "mismatch"), strings.Repeat("0", 64)); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+
+	for _, entry := range []*tar.Header{
+		{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+	} {
+		os.WriteFile(archive, testArchive(t, false, entry), 0644)
+		if _, err := extractContext(archive, filepath.Join(dir, "escape"), ""); err == nil || !strings.Contains(err.Error(), "outside of the context") {
+			t.Errorf("Expected %s to be rejected, got %v", entry.Name, err)
+		}
+	}
+
+	// each link stays inside the context on its own, the chain escapes it
+	dest := filepath.Join(dir, "chain", "context")
+	os.WriteFile(archive, testArchive(t, false,
+		&tar.Header{Name: "a/b/c/l1", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+		&tar.Header{Name: "a/b/c/l1/l2", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+		&tar.Header{Name: "a/b/c/l1/l2/escaped", Typeflag: tar.TypeReg, Mode: 0644},
+	), 0644)
+	if _, err := extractContext(archive, dest, ""); err == nil || !strings.Contains(err.Error(), "outside of the context") {
+		t.Errorf("Expected the link chain to be rejected, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "chain", "escaped")); err == nil {
+		t.Error("Expected no file written outside of the context")
+	}
+
+	// a file entry replaces a link instead of being written through it
+	os.WriteFile(archive, testArchive(t, false,
+		&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "file"},
+		&tar.Header{Name: "link", Typeflag: tar.TypeReg, Mode: 0644},
+	), 0644)
+	if _, err := extractContext(archive, filepath.Join(dir, "replace"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(filepath.Join(dir, "replace", "link")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("Expected the link to be replaced by a file, got %v, %v", info, err)
+	}
+}
+
+func TestFetchContextURL(t *testing.T) {
+	data := testArchive(t, true, &tar.Header{Name: "Dockerfile", Typeflag: tar.TypeReg, Mode: 0644})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/context.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	sum := sha256.Sum256(data)
+	p := Plugin{Build: Build{Context: server.URL + "/context.tar.gz", ContextChecksum: hex.EncodeToString(sum[:])}}
+	context, err := p.fetchContext(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(context, "Dockerfile")); err != nil {
+		t.Errorf("Expected the Dockerfile in the context, got %s", err)
+	}
+
+	p.Build.Context = server.URL + "/missing.tar.gz"
+	if _, err := p.fetchContext(t.TempDir()); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected a download error, got %v", err)
+	}
+}
+
+func TestFetchGitContext(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	origin := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", origin, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, output)
+		}
+	}
+	git("init", "-q")
+	os.MkdirAll(filepath.Join(origin, "services", "api"), 0755)
+	os.WriteFile(filepath.Join(origin, "services", "api", "Dockerfile"), []byte("FROM scratch\n"), 0644)
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+	git("tag", "v1.0.0")
+
+	repo := filepath.Join(t.TempDir(), "git")
+	if err := fetchGitContext(repo, origin, "v1.0.0", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "services", "api", "Dockerfile")); err != nil {
+		t.Errorf("Expected the Dockerfile in the checkout, got %s", err)
+	}
+	if err := fetchGitContext(filepath.Join(t.TempDir(), "git"), origin, "missing", "", ""); err == nil {
+		t.Error("Expected an error for a missing ref")
+	}
+}
+
+func TestValidateContext(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "context.tar")
+	os.WriteFile(archive, nil, 0644)
+	sum := "sha256:" + strings.Repeat("a", 64)
+	tcs := []struct {
+		name  string
+		build Build
+		err   string
+	}{
+		{name: "git", build: Build{Context: "https://github.com/octocat/app.git#main:api", Dockerfile: "Dockerfile"}},
+		{name: "archive", build: Build{Context: archive, Dockerfile: "Dockerfile", ContextChecksum: sum}},
+		{name: "git subdirectory", build: Build{Context: "https://github.com/octocat/app.git#main:../..", Dockerfile: "Dockerfile"}, err: "outside of the repository"},
+		{name: "git checksum", build: Build{Context: "https://github.com/octocat/app.git", Dockerfile: "Dockerfile", ContextChecksum: sum}, err: "only applies to a tarball context"},
+		{name: "invalid checksum", build: Build{Context: archive, Dockerfile: "Dockerfile", ContextChecksum: "md5:abc"}, err: "invalid checksum md5:abc"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.build.Repo = "octocat/app"
+			err := Plugin{Build: tc.build}.validate().Err()
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("Unexpected error %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Expected an error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
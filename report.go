@@ -0,0 +1,423 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runReport is the machine readable summary of a step, written as JSON to
+// the report file. Every method is safe to call on a nil report, which is
+// used when no report file is configured.
+type runReport struct {
+	mu sync.Mutex
+
+	Repo       string                `json:"repo"`
+	Mode       string                `json:"mode"`
+	StartedAt  time.Time             `json:"started_at"`
+	DurationMS int64                 `json:"duration_ms"`
+	Success    bool                  `json:"success"`
+	Error      string                `json:"error,omitempty"`
+	Daemon     *reportPhase          `json:"daemon,omitempty"`
+	Logins     []reportLogin         `json:"logins"`
+	Contexts   []reportContext       `json:"contexts,omitempty"`
+	Build      *reportBuild          `json:"build,omitempty"`
+	Images     []reportBuild         `json:"images,omitempty"`
+	Tests      *reportTests          `json:"tests,omitempty"`
+	Export     *reportExport         `json:"export,omitempty"`
+	Pushes     []reportPush          `json:"pushes"`
+	Signing    []reportSigning       `json:"signing"`
+	Cleanup    *reportCleanupOutcome `json:"cleanup,omitempty"`
+}
+
+type reportPhase struct {
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+type reportLogin struct {
+	Registry   string `json:"registry"`
+	Purpose    string `json:"purpose"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type reportBuild struct {
+	Name          string  `json:"name,omitempty"`
+	DurationMS    int64   `json:"duration_ms"`
+	Steps         int     `json:"steps"`
+	CachedSteps   int     `json:"cached_steps"`
+	CacheHitRatio float64 `json:"cache_hit_ratio"`
+	ImageSize     int64   `json:"image_size,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+type reportContext struct {
+	Name         string         `json:"name,omitempty"`
+	Context      string         `json:"context"`
+	Size         int64          `json:"size"`
+	Files        int            `json:"files"`
+	LargestFiles []contextEntry `json:"largest_files"`
+	LargestDirs  []contextEntry `json:"largest_dirs"`
+	Warnings     []string       `json:"warnings,omitempty"`
+	DurationMS   int64          `json:"duration_ms"`
+	Error        string         `json:"error,omitempty"`
+}
+
+type reportTests struct {
+	Target     string   `json:"target"`
+	Reports    []string `json:"reports"`
+	Tests      int      `json:"tests"`
+	Failures   int      `json:"failures"`
+	Errors     int      `json:"errors"`
+	Skipped    int      `json:"skipped"`
+	DurationMS int64    `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"`
+}
+
+type reportExport struct {
+	Type       string   `json:"type"`
+	Dest       string   `json:"dest"`
+	Target     string   `json:"target,omitempty"`
+	Files      []string `json:"files"`
+	DurationMS int64    `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"`
+}
+
+type reportPush struct {
+	Image      string `json:"image"`
+	Tag        string `json:"tag"`
+	DurationMS int64  `json:"duration_ms,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type reportSigning struct {
+	Image      string `json:"image"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type reportCleanupOutcome struct {
+	Removed []string `json:"removed"`
+	Pruned  []string `json:"pruned"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// newRunReport returns a report for the plugin, or nil when no report file
+// is configured.
+func (p Plugin) newRunReport() *runReport {
+	if p.ReportFile == "" {
+		return nil
+	}
+	mode := "build"
+	switch {
+	case p.PushOnly:
+		mode = "push-only"
+	case p.Export.Only:
+		mode = "export"
+	case p.Dryrun:
+		mode = "dry-run"
+	}
+	return &runReport{
+		Repo:      p.Build.Repo,
+		Mode:      mode,
+		StartedAt: time.Now(),
+		Logins:    []reportLogin{},
+		Pushes:    []reportPush{},
+		Signing:   []reportSigning{},
+	}
+}
+
+func (r *runReport) recordDaemon(start time.Time, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Daemon = &reportPhase{DurationMS: since(start), Error: errString(err)}
+}
+
+func (r *runReport) recordLogin(registry, purpose string, start time.Time, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Logins = append(r.Logins, reportLogin{
+		Registry:   registry,
+		Purpose:    purpose,
+		Success:    err == nil,
+		Error:      errString(err),
+		DurationMS: since(start),
+	})
+}
+
+// recordBuild records the build of image. The builds of a multi-image step
+// are recorded under the name of the image.
+func (r *runReport) recordBuild(name string, start time.Time, stats *buildStats, image string, err error) {
+	if r == nil {
+		return
+	}
+	build := &reportBuild{Name: name, DurationMS: since(start), Error: errString(err)}
+	if stats != nil {
+		build.Steps, build.CachedSteps = stats.Counts()
+		if build.Steps > 0 {
+			build.CacheHitRatio = float64(build.CachedSteps) / float64(build.Steps)
+		}
+	}
+	if err == nil {
+		build.ImageSize, _ = imageSize(image)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if name != "" {
+		r.Images = append(r.Images, *build)
+		return
+	}
+	r.Build = build
+}
+
+// recordTests records the outcome of the test stage.
+func (r *runReport) recordTests(target string, reports []string, counts testCounts, start time.Time, err error) {
+	if r == nil {
+		return
+	}
+	if reports == nil {
+		reports = []string{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Tests = &reportTests{
+		Target:     target,
+		Reports:    reports,
+		Tests:      counts.Tests,
+		Failures:   counts.Failures,
+		Errors:     counts.Errors,
+		Skipped:    counts.Skipped,
+		DurationMS: since(start),
+		Error:      errString(err),
+	}
+}
+
+// recordContext records the analysis of the build context of an image.
+func (r *runReport) recordContext(name, context string, analysis *contextAnalysis, start time.Time, err error) {
+	if r == nil {
+		return
+	}
+	entry := reportContext{
+		Name:       name,
+		Context:    context,
+		DurationMS: since(start),
+		Error:      errString(err),
+	}
+	// the analysis is missing when the context could not be read
+	if analysis != nil {
+		entry.Size = analysis.Size
+		entry.Files = analysis.Files
+		entry.LargestFiles = analysis.LargestFiles
+		entry.LargestDirs = analysis.LargestDirs
+		entry.Warnings = analysis.Warnings
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Contexts = append(r.Contexts, entry)
+}
+
+// recordExport records the files exported from the output stage.
+func (r *runReport) recordExport(output BuildOutput, start time.Time, files []string, err error) {
+	if r == nil {
+		return
+	}
+	if files == nil {
+		files = []string{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Export = &reportExport{
+		Type:       output.exporter(),
+		Dest:       output.Dest,
+		Target:     output.Target,
+		Files:      files,
+		DurationMS: since(start),
+		Error:      errString(err),
+	}
+}
+
+func (r *runReport) recordPush(image, digest string, start time.Time, err error) {
+	if r == nil {
+		return
+	}
+	push := reportPush{Image: image, Digest: digest, DurationMS: since(start), Error: errString(err)}
+	_, push.Tag = splitImageTag(image)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Pushes = append(r.Pushes, push)
+}
+
+// recordBakePush records an image pushed by docker buildx bake. The push is
+// part of the bake recorded as the build, so it has no duration of its own.
+func (r *runReport) recordBakePush(image, digest string) {
+	if r == nil {
+		return
+	}
+	push := reportPush{Image: image, Digest: digest}
+	_, push.Tag = splitImageTag(image)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Pushes = append(r.Pushes, push)
+}
+
+func (r *runReport) recordSigning(image string, start time.Time, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Signing = append(r.Signing, reportSigning{
+		Image:      image,
+		Success:    err == nil,
+		Error:      errString(err),
+		DurationMS: since(start),
+	})
+}
+
+func (r *runReport) recordCleanup(result cleanupResult) {
+	if r == nil {
+		return
+	}
+	cleanup := &reportCleanupOutcome{Removed: result.Removed, Pruned: result.Pruned}
+	for _, err := range result.Errors {
+		cleanup.Errors = append(cleanup.Errors, err.Error())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Cleanup = cleanup
+}
+
+// finish records the outcome of the step and writes the report to path.
+// Secrets are masked before the report is written.
+func (r *runReport) finish(path string, err error) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	r.DurationMS = since(r.StartedAt)
+	r.Success = err == nil
+	r.Error = errString(err)
+	data, marshalErr := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("unable to create report directory: %w", err)
+		}
+	}
+	return os.WriteFile(path, []byte(secrets.Redact(string(data))+"\n"), 0644)
+}
+
+var (
+	// classic builder: "Step 2/7 : RUN make" and " ---> Using cache"
+	classicStepPattern = regexp.MustCompile(`^Step \d+/\d+ :`)
+	// buildkit: "#7 [build 2/5] RUN make" and "#7 CACHED"
+	buildkitStepPattern   = regexp.MustCompile(`^#(\d+) \[[^\]]*\d+/\d+\]`)
+	buildkitCachedPattern = regexp.MustCompile(`^#(\d+) CACHED`)
+)
+
+// buildStats is an io.Writer counting the build steps and the steps served
+// from the cache in docker build output, for both the classic builder and
+// BuildKit. It is safe for concurrent use by the stdout and stderr copiers.
+type buildStats struct {
+	mu      sync.Mutex
+	partial []byte
+	steps   map[string]bool
+	cached  map[string]bool
+	classic struct{ steps, cached int }
+}
+
+func (s *buildStats) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := append(s.partial, b...)
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		s.partial = data
+		return len(b), nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data[:end]))
+	for scanner.Scan() {
+		s.line(scanner.Text())
+	}
+	s.partial = append([]byte{}, data[end+1:]...)
+	return len(b), nil
+}
+
+func (s *buildStats) line(line string) {
+	if s.steps == nil {
+		s.steps, s.cached = map[string]bool{}, map[string]bool{}
+	}
+	switch {
+	case classicStepPattern.MatchString(line):
+		s.classic.steps++
+	case strings.TrimSpace(line) == "---> Using cache":
+		s.classic.cached++
+	case buildkitStepPattern.MatchString(line):
+		s.steps[buildkitStepPattern.FindStringSubmatch(line)[1]] = true
+	case buildkitCachedPattern.MatchString(line):
+		s.cached[buildkitCachedPattern.FindStringSubmatch(line)[1]] = true
+	}
+}
+
+// Counts returns the number of build steps and of cached steps.
+func (s *buildStats) Counts() (steps, cached int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.partial) > 0 {
+		s.line(string(s.partial))
+		s.partial = nil
+	}
+	if s.classic.steps > 0 {
+		return s.classic.steps, s.classic.cached
+	}
+	for id := range s.cached {
+		if s.steps[id] {
+			cached++
+		}
+	}
+	return len(s.steps), cached
+}
+
+// imageSize returns the size in bytes of a local image.
+func imageSize(image string) (int64, error) {
+	out, err := exec.Command(dockerExe, "image", "inspect", "--format", "{{.Size}}", image).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
+
+func since(start time.Time) int64 {
+	return time.Since(start).Milliseconds()
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,119 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildStats(t *testing.T) {
+	tcs := []struct {
+		name          string
+		output        string
+		steps, cached int
+	}{
+		{
+			name: "classic builder",
+			output: "Step 1/3 : FROM alpine\n ---> a24bb4013296\n" +
+				"Step 2/3 : RUN apk add git\n ---> Using cache\n ---> 1b2c3d\n" +
+				"Step 3/3 : COPY . /src\n ---> 4e5f6a\n",
+			steps:  3,
+			cached: 1,
+		},
+		{
+			name: "buildkit",
+			output: "#5 [1/3] FROM docker.io/library/alpine\n#5 DONE 0.1s\n" +
+				"#6 [2/3] RUN apk add git\n#6 CACHED\n\n" +
+				"#7 [3/3] COPY . /src\n#7 DONE 0.2s\n#8 exporting to image\n",
+			steps:  3,
+			cached: 1,
+		},
+		{
+			name:   "no steps",
+			output: "unknown output",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			stats := &buildStats{}
+			// split writes across line boundaries like a pipe would
+			for i := 0; i < len(tc.output); i += 7 {
+				end := i + 7
+				if end > len(tc.output) {
+					end = len(tc.output)
+				}
+				stats.Write([]byte(tc.output[i:end]))
+			}
+			steps, cached := stats.Counts()
+			if steps != tc.steps || cached != tc.cached {
+				t.Errorf("Got %d steps and %d cached, want %d and %d", steps, cached, tc.steps, tc.cached)
+			}
+		})
+	}
+}
+
+func TestRunReportNil(t *testing.T) {
+	var r *runReport
+	r.recordDaemon(time.Now(), nil)
+	r.recordLogin("quay.io", "push", time.Now(), nil)
+	r.recordSigning("octocat/hello@sha256:abc", time.Now(), nil)
+	r.recordBakePush("octocat/hello:latest", "sha256:abc")
+	r.recordCleanup(cleanupResult{})
+	if err := r.finish("report.json", nil); err != nil {
+		t.Errorf("A nil report should not be written, got %s", err)
+	}
+	if (Plugin{}).newRunReport() != nil {
+		t.Errorf("Expected no report without a report file")
+	}
+}
+
+func TestRunReportFinish(t *testing.T) {
+	orig := secrets
+	secrets = newRedactor()
+	defer func() { secrets = orig }()
+	secrets.Add("hunter22")
+
+	path := filepath.Join(t.TempDir(), "reports", "run.json")
+	p := Plugin{ReportFile: path, Build: Build{Repo: "octocat/hello"}}
+	r := p.newRunReport()
+	r.recordDaemon(time.Now(), nil)
+	r.recordLogin("quay.io", "push", time.Now(), errors.New("bad password hunter22"))
+	r.recordSigning("octocat/hello@sha256:abc", time.Now(), nil)
+	r.recordBakePush("octocat/hello:latest", "sha256:abc")
+	r.recordCleanup(cleanupResult{Removed: []string{"abc123"}, Pruned: []string{"system"}})
+
+	if err := r.finish(path, errors.New("login failed")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter22") {
+		t.Errorf("Report leaks a secret: %s", data)
+	}
+
+	var report map[string]interface{}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Invalid report JSON: %s", err)
+	}
+	if report["success"] != false || report["error"] != "login failed" || report["mode"] != "build" {
+		t.Errorf("Unexpected report outcome: %s", data)
+	}
+	for _, key := range []string{"daemon", "logins", "pushes", "signing", "cleanup"} {
+		if _, ok := report[key]; !ok {
+			t.Errorf("Report is missing %s: %s", key, data)
+		}
+	}
+	pushes, _ := report["pushes"].([]interface{})
+	if len(pushes) != 1 {
+		t.Fatalf("Expected the bake push in the report: %s", data)
+	}
+	if push := pushes[0].(map[string]interface{}); push["tag"] != "latest" || push["duration_ms"] != nil {
+		t.Errorf("Expected the bake push without a duration, got %v", push)
+	}
+}
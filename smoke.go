@@ -0,0 +1,316 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
+)
+
+// defaultSmokeTimeout bounds each smoke test command and the healthcheck
+// wait when no timeout is configured.
+const defaultSmokeTimeout = time.Minute
+
+type (
+	// SmokeTests defines the checks run against the built image before it
+	// is pushed.
+	SmokeTests struct {
+		Commands    []SmokeCommand `json:"commands" yaml:"commands"`       // Commands run in the image
+		Files       []SmokeFile    `json:"files" yaml:"files"`             // Files checked in the image
+		Healthcheck bool           `json:"healthcheck" yaml:"healthcheck"` // Wait for the image HEALTHCHECK to report healthy
+		Timeout     string         `json:"timeout" yaml:"timeout"`         // Timeout of each command and of the healthcheck
+	}
+
+	// SmokeCommand is a command run in a container of the image, and its
+	// expected outcome.
+	SmokeCommand struct {
+		Name           string            `json:"name" yaml:"name"`                       // Name shown in the results
+		Command        []string          `json:"command" yaml:"command"`                 // Entrypoint and arguments, the image default when empty
+		Env            map[string]string `json:"env" yaml:"env"`                         // Environment variables
+		ExitCode       int               `json:"exit_code" yaml:"exit_code"`             // Expected exit code
+		Stdout         []string          `json:"stdout" yaml:"stdout"`                   // Patterns the output must match
+		StdoutExcludes []string          `json:"stdout_excludes" yaml:"stdout_excludes"` // Patterns the output must not match
+	}
+
+	// SmokeFile is a file expected in the image.
+	SmokeFile struct {
+		Path   string `json:"path" yaml:"path"`     // Absolute path of the file
+		Absent bool   `json:"absent" yaml:"absent"` // The file must not exist
+		Mode   string `json:"mode" yaml:"mode"`     // Expected permissions in octal, e.g. 0755
+		UID    *int   `json:"uid" yaml:"uid"`       // Expected owner
+		GID    *int   `json:"gid" yaml:"gid"`       // Expected group
+	}
+)
+
+// smokeResult is the outcome of a smoke test, as shown on the card.
+type smokeResult struct {
+	Name   string `json:"Name"`
+	Passed bool   `json:"Passed"`
+	Result string `json:"Result"`
+}
+
+// ParseSmokeTests parses the JSON smoke tests definition.
+func ParseSmokeTests(data string) (SmokeTests, error) {
+	var tests SmokeTests
+	if strings.TrimSpace(data) == "" {
+		return tests, nil
+	}
+	if err := json.Unmarshal([]byte(data), &tests); err != nil {
+		return tests, fmt.Errorf("invalid smoke tests: %w", err)
+	}
+	return tests, nil
+}
+
+// enabled reports whether the image is smoke tested before the push.
+func (s SmokeTests) enabled() bool {
+	return len(s.Commands) > 0 || len(s.Files) > 0 || s.Healthcheck
+}
+
+// timeout returns the timeout of each command and of the healthcheck.
+func (s SmokeTests) timeout() time.Duration {
+	if d, err := time.ParseDuration(s.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultSmokeTimeout
+}
+
+// names returns the names of the smoke tests, in the order they run.
+func (s SmokeTests) names() []string {
+	var names []string
+	for _, command := range s.Commands {
+		names = append(names, command.name())
+	}
+	for _, file := range s.Files {
+		names = append(names, file.Path)
+	}
+	if s.Healthcheck {
+		names = append(names, "healthcheck")
+	}
+	return names
+}
+
+// name returns the name of the command shown in the results.
+func (c SmokeCommand) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	if len(c.Command) == 0 {
+		return "default command"
+	}
+	return strings.Join(c.Command, " ")
+}
+
+// runSmokeTests runs every smoke test against image and returns the results
+// and an error listing the failed tests.
+func (p Plugin) runSmokeTests(image string) ([]smokeResult, error) {
+	start := time.Now()
+	fmt.Printf("Running the smoke tests of %s\n", image)
+
+	var results []smokeResult
+	for i, command := range p.Smoke.Commands {
+		name := fmt.Sprintf("%s-smoke-%d", image, i)
+		results = append(results, smokeResult{Name: command.name()}.check(
+			runSmokeCommand(image, name, command, p.Smoke.timeout())))
+	}
+	if len(p.Smoke.Files) > 0 {
+		results = append(results, checkSmokeFiles(image, p.Smoke.Files)...)
+	}
+	if p.Smoke.Healthcheck {
+		results = append(results, smokeResult{Name: "healthcheck"}.check(
+			waitHealthy(image, image+"-health", p.Smoke.timeout())))
+	}
+
+	var failed []string
+	for _, result := range results {
+		status := "✅"
+		if !result.Passed {
+			status = "❌"
+			failed = append(failed, result.Name)
+		}
+		fmt.Printf("%s %s: %s\n", status, result.Name, result.Result)
+	}
+	var err error
+	if len(failed) > 0 {
+		err = fmt.Errorf("smoke tests failed: %s", strings.Join(failed, ", "))
+	}
+	p.recordSpan("docker.smoke", start, err, telemetry.AttrImage.String(image))
+	return results, err
+}
+
+// check sets the outcome of the test from err.
+func (r smokeResult) check(err error) smokeResult {
+	r.Passed = err == nil
+	r.Result = "passed"
+	if err != nil {
+		r.Result = secrets.Redact(err.Error())
+	}
+	return r
+}
+
+// runSmokeCommand runs the command in a container of image named name and
+// checks its exit code and output.
+func runSmokeCommand(image, name string, command SmokeCommand, timeout time.Duration) error {
+	args := []string{"run", "--rm", "--name", name}
+	keys := make([]string, 0, len(command.Env))
+	for key := range command.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "-e", key+"="+command.Env[key])
+	}
+	if len(command.Command) > 0 {
+		args = append(args, "--entrypoint", command.Command[0], image)
+		args = append(args, command.Command[1:]...)
+	} else {
+		args = append(args, image)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, dockerExe, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	trace(cmd)
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		exec.Command(dockerExe, "rm", "-f", name).Run()
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return err
+	}
+	if exitCode != command.ExitCode {
+		return fmt.Errorf("exit code %d, expected %d: %s", exitCode, command.ExitCode, lastLine(stderr.String()))
+	}
+	for _, pattern := range command.Stdout {
+		if re, err := regexp.Compile(pattern); err != nil || !re.MatchString(stdout.String()) {
+			return fmt.Errorf("output does not match %q", pattern)
+		}
+	}
+	for _, pattern := range command.StdoutExcludes {
+		if re, err := regexp.Compile(pattern); err != nil || re.MatchString(stdout.String()) {
+			return fmt.Errorf("output matches %q", pattern)
+		}
+	}
+	return nil
+}
+
+// lastLine returns the last non-empty line of s.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
+
+// checkSmokeFiles checks the files of image, copied out of a container
+// created from the image.
+func checkSmokeFiles(image string, files []SmokeFile) []smokeResult {
+	var results []smokeResult
+	output, err := exec.Command(dockerExe, "create", image, "true").Output()
+	if err != nil {
+		for _, file := range files {
+			results = append(results, smokeResult{Name: file.Path}.check(fmt.Errorf("unable to create a container: %w", err)))
+		}
+		return results
+	}
+	container := strings.TrimSpace(string(output))
+	defer exec.Command(dockerExe, "rm", container).Run()
+
+	for _, file := range files {
+		header, err := statContainerFile(container, file.Path)
+		results = append(results, smokeResult{Name: file.Path}.check(file.check(header, err)))
+	}
+	return results
+}
+
+// statContainerFile returns the tar header of the file at path in the
+// container, or nil when the file does not exist.
+func statContainerFile(container, path string) (*tar.Header, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(dockerExe, "cp", container+":"+path, "-")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stderr.String(), "No such") || strings.Contains(stderr.String(), "Could not find") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to copy %s: %s", path, lastLine(stderr.String()))
+	}
+	header, err := tar.NewReader(&stdout).Next()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", path, err)
+	}
+	return header, nil
+}
+
+// check verifies the file described by header, nil when it does not exist.
+func (f SmokeFile) check(header *tar.Header, err error) error {
+	switch {
+	case err != nil:
+		return err
+	case f.Absent && header != nil:
+		return fmt.Errorf("exists")
+	case f.Absent:
+		return nil
+	case header == nil:
+		return fmt.Errorf("does not exist")
+	}
+	if f.Mode != "" {
+		mode, _ := strconv.ParseUint(f.Mode, 8, 32)
+		if actual := uint64(header.Mode) & 07777; actual != mode {
+			return fmt.Errorf("mode %04o, expected %04o", actual, mode)
+		}
+	}
+	if f.UID != nil && header.Uid != *f.UID {
+		return fmt.Errorf("owned by uid %d, expected %d", header.Uid, *f.UID)
+	}
+	if f.GID != nil && header.Gid != *f.GID {
+		return fmt.Errorf("owned by gid %d, expected %d", header.Gid, *f.GID)
+	}
+	return nil
+}
+
+// waitHealthy starts a container of image named name and waits until its
+// HEALTHCHECK reports healthy.
+func waitHealthy(image, name string, timeout time.Duration) error {
+	if err := exec.Command(dockerExe, "run", "-d", "--name", name, image).Run(); err != nil {
+		return fmt.Errorf("unable to start a container: %w", err)
+	}
+	defer exec.Command(dockerExe, "rm", "-f", name).Run()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		output, err := exec.Command(dockerExe, "inspect", "--format", "{{if .State.Health}}{{.State.Health.Status}}{{else}}none{{end}}", name).Output()
+		if err != nil {
+			return fmt.Errorf("unable to inspect the container: %w", err)
+		}
+		switch status := strings.TrimSpace(string(output)); status {
+		case "healthy":
+			return nil
+		case "unhealthy":
+			return fmt.Errorf("unhealthy")
+		case "none":
+			return fmt.Errorf("the image has no HEALTHCHECK")
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not healthy after %s", timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,140 @@
+package docker
+
+import (
+	"archive/tar"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSmokeTests(t *testing.T) {
+	tests, err := ParseSmokeTests(`{
+		"commands": [{"name": "version", "command": ["app", "--version"], "stdout": ["^v1\\."]}],
+		"files": [{"path": "/usr/bin/app", "mode": "0755", "uid": 0}],
+		"healthcheck": true,
+		"timeout": "30s"
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tests.enabled() || tests.timeout() != 30*time.Second {
+		t.Errorf("Expected enabled smoke tests with a 30s timeout, got %+v", tests)
+	}
+	want := []string{"version", "/usr/bin/app", "healthcheck"}
+	if got := tests.names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected tests %v, got %v", want, got)
+	}
+	if tests.Files[0].UID == nil || *tests.Files[0].UID != 0 {
+		t.Errorf("Expected uid 0, got %v", tests.Files[0].UID)
+	}
+
+	if tests, err := ParseSmokeTests(""); err != nil || tests.enabled() {
+		t.Errorf("Expected no smoke tests, got %+v, %v", tests, err)
+	}
+	if _, err := ParseSmokeTests("[]"); err == nil {
+		t.Error("Expected an error for an invalid definition")
+	}
+	if got := (SmokeTests{}).timeout(); got != defaultSmokeTimeout {
+		t.Errorf("Expected the default timeout, got %s", got)
+	}
+}
+
+func TestSmokeCommandName(t *testing.T) {
+	tcs := []struct {
+		command SmokeCommand
+		want    string
+	}{
+		{SmokeCommand{Name: "version", Command: []string{"app", "--version"}}, "version"},
+		{SmokeCommand{Command: []string{"app", "--version"}}, "app --version"},
+		{SmokeCommand{}, "default command"},
+	}
+	for _, tc := range tcs {
+		if got := tc.command.name(); got != tc.want {
+			t.Errorf("name() = %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestSmokeFileCheck(t *testing.T) {
+	root := 0
+	other := 1000
+	header := &tar.Header{Name: "app", Mode: 0100755, Uid: 0, Gid: 0}
+	tcs := []struct {
+		name   string
+		file   SmokeFile
+		header *tar.Header
+		err    error
+		want   string
+	}{
+		{name: "exists", file: SmokeFile{Path: "/app"}, header: header},
+		{name: "missing", file: SmokeFile{Path: "/app"}, want: "does not exist"},
+		{name: "absent", file: SmokeFile{Path: "/app", Absent: true}},
+		{name: "not absent", file: SmokeFile{Path: "/app", Absent: true}, header: header, want: "exists"},
+		{name: "mode", file: SmokeFile{Path: "/app", Mode: "755", UID: &root, GID: &root}, header: header},
+		{name: "wrong mode", file: SmokeFile{Path: "/app", Mode: "0644"}, header: header, want: "mode 0755, expected 0644"},
+		{name: "wrong owner", file: SmokeFile{Path: "/app", UID: &other}, header: header, want: "owned by uid 0, expected 1000"},
+		{name: "wrong group", file: SmokeFile{Path: "/app", GID: &other}, header: header, want: "owned by gid 0, expected 1000"},
+		{name: "copy error", file: SmokeFile{Path: "/app"}, err: errors.New("unable to copy /app"), want: "unable to copy"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.file.check(tc.header, tc.err)
+			if tc.want == "" {
+				if err != nil {
+					t.Errorf("Unexpected error %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestExecutionPlanSmoke(t *testing.T) {
+	p := Plugin{
+		Smoke: SmokeTests{Files: []SmokeFile{{Path: "/app"}}, Healthcheck: true},
+		Build: Build{Dockerfile: "Dockerfile", Context: ".", TempTag: "abc123", Repo: "octocat/app", Tags: []string{"latest"}},
+	}
+	plan := p.executionPlan()
+	if plan.Smoke == nil || !reflect.DeepEqual(plan.Smoke.Tests, []string{"/app", "healthcheck"}) || plan.Smoke.Timeout != "1m0s" {
+		t.Errorf("Expected the smoke tests in the plan, got %+v", plan.Smoke)
+	}
+}
+
+func TestValidateSmoke(t *testing.T) {
+	tcs := []struct {
+		name   string
+		smoke  SmokeTests
+		plugin Plugin
+		err    string
+	}{
+		{name: "valid", smoke: SmokeTests{Commands: []SmokeCommand{{Stdout: []string{"^ok$"}}}, Files: []SmokeFile{{Path: "/app", Mode: "0755"}}}},
+		{name: "invalid pattern", smoke: SmokeTests{Commands: []SmokeCommand{{StdoutExcludes: []string{"(error"}}}}, err: "invalid pattern (error"},
+		{name: "invalid timeout", smoke: SmokeTests{Healthcheck: true, Timeout: "soon"}, err: "invalid timeout soon"},
+		{name: "relative path", smoke: SmokeTests{Files: []SmokeFile{{Path: "app"}}}, err: "must be an absolute path"},
+		{name: "invalid mode", smoke: SmokeTests{Files: []SmokeFile{{Path: "/app", Mode: "rwx"}}}, err: "invalid mode rwx"},
+		{name: "push only", smoke: SmokeTests{Healthcheck: true}, plugin: Plugin{PushOnly: true}, err: "cannot be combined with push_only"},
+		{name: "output only", smoke: SmokeTests{Healthcheck: true}, plugin: Plugin{Export: BuildOutput{Dest: "dist", Only: true}}, err: "cannot be combined with output_only"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			p := tc.plugin
+			p.Smoke = tc.smoke
+			p.Build.Repo = "octocat/app"
+			err := p.validate().Err()
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("Unexpected error %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Expected an error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
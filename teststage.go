@@ -0,0 +1,257 @@
+package docker
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
+)
+
+// defaultTestReportsDest is the workspace directory the test reports are
+// copied to when none is configured.
+const defaultTestReportsDest = "test-reports"
+
+// TestStage defines the build stage running the tests of the image and the
+// JUnit reports it produces.
+type TestStage struct {
+	Target  string   // Build stage running the tests
+	Reports []string // Report files of the stage, glob patterns such as /reports/*.xml
+	Dest    string   // Workspace directory the reports are copied to
+}
+
+// enabled reports whether the tests run before the image build.
+func (t TestStage) enabled() bool {
+	return t.Target != ""
+}
+
+// dest returns the directory the reports are copied to.
+func (t TestStage) dest() string {
+	if t.Dest == "" {
+		return defaultTestReportsDest
+	}
+	return t.Dest
+}
+
+// testCounts sums the test cases of JUnit reports.
+type testCounts struct {
+	Tests    int
+	Failures int
+	Errors   int
+	Skipped  int
+}
+
+func (c *testCounts) add(other testCounts) {
+	c.Tests += other.Tests
+	c.Failures += other.Failures
+	c.Errors += other.Errors
+	c.Skipped += other.Skipped
+}
+
+// failed returns the number of failed test cases.
+func (c testCounts) failed() int {
+	return c.Failures + c.Errors
+}
+
+// testBuild returns the build of the test stage, tagged apart from the
+// image.
+func (p Plugin) testBuild() Build {
+	build := p.Build
+	build.Target = p.Test.Target
+	build.TempTag = p.Build.TempTag + "-test"
+	return build
+}
+
+// runTests builds the test stage, copies its reports to the workspace and
+// fails when the build fails or a report has failed test cases.
+func (p Plugin) runTests() error {
+	start := time.Now()
+	build := p.testBuild()
+	fmt.Printf("Running the tests of stage %s\n", p.Test.Target)
+
+	err := runCommand(commandBuild(build))
+	if err != nil {
+		err = fmt.Errorf("tests failed: %w", err)
+	}
+	var reports []string
+	var counts testCounts
+	if err == nil && len(p.Test.Reports) > 0 {
+		reports, err = copyTestReports(build.TempTag, p.Test.Reports, p.Test.dest())
+		if err == nil {
+			counts, err = parseTestReports(reports)
+		}
+		if err == nil && counts.failed() > 0 {
+			err = fmt.Errorf("tests failed: %d of %d test(s) failed", counts.failed(), counts.Tests)
+		}
+	}
+	commandRmi(build.TempTag).Run()
+
+	p.report.recordTests(p.Test.Target, reports, counts, start, err)
+	p.recordSpan("docker.test", start, err, telemetry.AttrRepo.String(p.Build.Repo))
+	if err != nil {
+		return err
+	}
+	if len(reports) > 0 {
+		fmt.Printf("%d test(s) passed, %d skipped, reports copied to %s\n", counts.Tests-counts.Skipped, counts.Skipped, p.Test.dest())
+	}
+	return nil
+}
+
+// copyTestReports copies the files of image matching the patterns to dest,
+// using a container created from the image. It returns the copied files.
+func copyTestReports(image string, patterns []string, dest string) ([]string, error) {
+	output, err := exec.Command(dockerExe, "create", image, "true").Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the test container: %w", err)
+	}
+	container := strings.TrimSpace(string(output))
+	defer exec.Command(dockerExe, "rm", container).Run()
+
+	staging, err := os.MkdirTemp("", "drone-docker-reports")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create the test reports directory: %w", err)
+	}
+
+	var reports []string
+	for i, pattern := range patterns {
+		dir := globDir(pattern)
+		local := filepath.Join(staging, fmt.Sprint(i))
+		cmd := exec.Command(dockerExe, "cp", container+":"+dir+"/.", local)
+		trace(cmd)
+		if err := cmd.Run(); err != nil {
+			fmt.Printf("Could not copy the test reports of %s. %s\n", pattern, err)
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(pattern, dir), "/")
+		copied, err := copyMatchingFiles(local, rel, dest)
+		if err != nil {
+			return nil, fmt.Errorf("unable to copy the test reports of %s: %w", pattern, err)
+		}
+		reports = append(reports, copied...)
+	}
+	if len(reports) == 0 {
+		return nil, fmt.Errorf("no test report matches %s", strings.Join(patterns, ", "))
+	}
+	return reports, nil
+}
+
+// copyMatchingFiles copies the files of dir matching the pattern to dest,
+// keeping their path relative to dir so that reports of the same name in
+// different directories are all kept. It returns the copied files.
+func copyMatchingFiles(dir, pattern, dest string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+	if err != nil {
+		return nil, err
+	}
+	var copied []string
+	for _, match := range matches {
+		rel, err := filepath.Rel(dir, match)
+		if err != nil {
+			return nil, err
+		}
+		report := filepath.Join(dest, rel)
+		if err := os.MkdirAll(filepath.Dir(report), 0755); err != nil {
+			return nil, err
+		}
+		if err := copyFile(match, report); err != nil {
+			return nil, err
+		}
+		copied = append(copied, report)
+	}
+	return copied, nil
+}
+
+// globDir returns the directory of the pattern preceding its first glob
+// element, e.g. /reports for /reports/*/junit.xml.
+func globDir(pattern string) string {
+	dir := path.Dir(pattern)
+	for strings.ContainsAny(dir, "*?[") {
+		dir = path.Dir(dir)
+	}
+	return dir
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// junitSuite is a JUnit testsuite or testsuites element.
+type junitSuite struct {
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+	Cases    []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Failure *struct{} `xml:"failure"`
+	Error   *struct{} `xml:"error"`
+	Skipped *struct{} `xml:"skipped"`
+}
+
+// counts sums the test cases of the suite and its nested suites. The
+// attributes of the suite are used when it lists no test case.
+func (s junitSuite) counts() testCounts {
+	if len(s.Suites) == 0 && len(s.Cases) == 0 {
+		return testCounts{Tests: s.Tests, Failures: s.Failures, Errors: s.Errors, Skipped: s.Skipped}
+	}
+	var counts testCounts
+	for _, suite := range s.Suites {
+		counts.add(suite.counts())
+	}
+	for _, c := range s.Cases {
+		counts.Tests++
+		switch {
+		case c.Failure != nil:
+			counts.Failures++
+		case c.Error != nil:
+			counts.Errors++
+		case c.Skipped != nil:
+			counts.Skipped++
+		}
+	}
+	return counts
+}
+
+// parseTestReports sums the test cases of the JUnit reports.
+func parseTestReports(reports []string) (testCounts, error) {
+	var counts testCounts
+	for _, report := range reports {
+		data, err := os.ReadFile(report)
+		if err != nil {
+			return counts, err
+		}
+		var suite junitSuite
+		if err := xml.Unmarshal(data, &suite); err != nil && !errors.Is(err, io.EOF) {
+			return counts, fmt.Errorf("invalid JUnit report %s: %w", filepath.Base(report), err)
+		}
+		counts.add(suite.counts())
+	}
+	return counts, nil
+}
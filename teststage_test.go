@@ -0,0 +1,143 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseTestReports(t *testing.T) {
+	dir := t.TempDir()
+	reports := map[string]string{
+		"suites.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites tests="4" failures="1">
+  <testsuite name="api" tests="3" failures="1">
+    <testcase name="get"/>
+    <testcase name="put"><failure message="expected 200"/></testcase>
+    <testcase name="delete"><skipped/></testcase>
+  </testsuite>
+  <testsuite name="db" tests="1">
+    <testcase name="migrate"><error message="timeout"/></testcase>
+  </testsuite>
+</testsuites>`,
+		"suite.xml": `<testsuite name="cli" tests="5" failures="0" errors="0" skipped="2"></testsuite>`,
+		"empty.xml": ``,
+	}
+	var paths []string
+	for name, content := range reports {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	counts, err := parseTestReports(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testCounts{Tests: 9, Failures: 1, Errors: 1, Skipped: 3}
+	if counts != want {
+		t.Errorf("Got counts %+v, want %+v", counts, want)
+	}
+	if counts.failed() != 2 {
+		t.Errorf("Got %d failed tests, want 2", counts.failed())
+	}
+
+	invalid := filepath.Join(dir, "invalid.xml")
+	os.WriteFile(invalid, []byte("<testsuite><testcase>"), 0644)
+	if _, err := parseTestReports([]string{invalid}); err == nil {
+		t.Errorf("Expected an error for an invalid report")
+	}
+}
+
+func TestGlobDir(t *testing.T) {
+	tcs := []struct {
+		pattern, want string
+	}{
+		{"/reports/*.xml", "/reports"},
+		{"/reports/junit.xml", "/reports"},
+		{"/app/*/reports/*.xml", "/app"},
+		{"/junit-[0-9].xml", "/"},
+	}
+	for _, tc := range tcs {
+		if got := globDir(tc.pattern); got != tc.want {
+			t.Errorf("globDir(%q) = %q, want %q", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestExecutionPlanTest(t *testing.T) {
+	p := Plugin{
+		Test: TestStage{Target: "test", Reports: []string{"/reports/*.xml"}},
+		Build: Build{
+			Dockerfile: "Dockerfile",
+			Context:    ".",
+			TempTag:    "abc123",
+			Target:     "prod",
+			Repo:       "octocat/app",
+			Tags:       []string{"latest"},
+		},
+	}
+	plan := p.executionPlan()
+	if plan.Test == nil || plan.Test.Dest != "test-reports" {
+		t.Fatalf("Expected a test stage copying reports to test-reports, got %+v", plan.Test)
+	}
+	if !strings.Contains(plan.Commands[0], "-t abc123-test") || !strings.Contains(plan.Commands[0], "--target test") {
+		t.Errorf("Expected the test stage to be built first, got %v", plan.Commands)
+	}
+	if !strings.Contains(plan.Commands[1], "--target prod") {
+		t.Errorf("Expected the image to be built after the tests, got %v", plan.Commands)
+	}
+}
+
+func TestValidateTest(t *testing.T) {
+	tcs := []struct {
+		name string
+		test TestStage
+		err  string
+	}{
+		{name: "valid", test: TestStage{Target: "test", Reports: []string{"/reports/*.xml"}}},
+		{name: "reports without target", test: TestStage{Reports: []string{"/reports/*.xml"}}, err: "test_target (PLUGIN_TEST_TARGET): is required"},
+		{name: "relative pattern", test: TestStage{Target: "test", Reports: []string{"reports/*.xml"}}, err: "must be an absolute path"},
+		{name: "invalid pattern", test: TestStage{Target: "test", Reports: []string{"/reports/[.xml"}}, err: "invalid pattern"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			p := Plugin{Test: tc.test, Build: Build{Repo: "octocat/app"}}
+			err := p.validate().Err()
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("Unexpected error %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Expected an error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestCopyMatchingFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"api/junit.xml", "web/junit.xml", "web/coverage.out"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(name), 0644)
+	}
+	dest := t.TempDir()
+	copied, err := copyMatchingFiles(dir, "*/junit.xml", dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copied) != 2 {
+		t.Fatalf("Expected 2 reports, got %v", copied)
+	}
+	for _, name := range []string{"api/junit.xml", "web/junit.xml"} {
+		if data, _ := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name))); string(data) != name {
+			t.Errorf("Expected report %s to be kept, got %q", name, data)
+		}
+	}
+}
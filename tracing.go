@@ -0,0 +1,67 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
+)
+
+// startStepSpan starts the span covering the whole step, continuing the
+// trace of the parent process when one is propagated through the
+// environment.
+func (p *Plugin) startStepSpan() oteltrace.Span {
+	_, p.span = telemetry.Start(telemetry.FromEnv(), "drone-docker",
+		telemetry.AttrRepo.String(p.Build.Repo),
+		telemetry.AttrRegistry.String(p.Login.Registry),
+		telemetry.AttrRegistryType.String(string(p.Daemon.RegistryType)),
+		attribute.StringSlice("docker.tags", p.Build.Tags),
+	)
+	return p.span
+}
+
+// tracing reports whether the phases of the step are exported as spans.
+func (p Plugin) tracing() bool {
+	return p.span != nil && p.span.IsRecording()
+}
+
+// recordSpan records a phase of the step that started at start and just
+// ended with err as a child of the step span.
+func (p Plugin) recordSpan(name string, start time.Time, err error, attrs ...attribute.KeyValue) {
+	if !p.tracing() {
+		return
+	}
+	ctx := oteltrace.ContextWithSpan(context.Background(), p.span)
+	telemetry.Record(ctx, name, start, secrets.RedactError(err), attrs...)
+}
+
+// recordLoginSpan records a registry login as a span.
+func (p Plugin) recordLoginSpan(registry, purpose string, start time.Time, err error) {
+	p.recordSpan("docker.login", start, err,
+		telemetry.AttrRegistry.String(registry),
+		telemetry.AttrRegistryType.String(string(p.Daemon.RegistryType)),
+		telemetry.AttrPurpose.String(purpose),
+	)
+}
+
+// recordTagSpan records the tagging of image, a repo:tag reference, as a
+// span.
+func (p Plugin) recordTagSpan(image string, start time.Time, err error) {
+	repo, tag := splitImageTag(image)
+	p.recordSpan("docker.tag", start, err,
+		telemetry.AttrRepo.String(repo),
+		telemetry.AttrTag.String(tag),
+	)
+}
+
+// splitImageTag splits a repo:tag reference, ignoring a registry port.
+func splitImageTag(image string) (repo, tag string) {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, ""
+}
@@ -0,0 +1,97 @@
+package docker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-plugins/drone-docker/internal/telemetry"
+	"github.com/drone-plugins/drone-docker/internal/telemetry/telemetrytest"
+)
+
+func TestTracingSpans(t *testing.T) {
+	orig := secrets
+	secrets = newRedactor()
+	defer func() { secrets = orig }()
+	secrets.Add("hunter22")
+
+	collector := telemetrytest.NewCollector(t)
+	shutdown := telemetry.Setup("drone-docker")
+
+	p := Plugin{
+		Login:  Login{Registry: "registry:5000"},
+		Build:  Build{Repo: "registry:5000/octocat/hello", Tags: []string{"latest"}},
+		Daemon: Daemon{RegistryType: "ECR"},
+	}
+	span := p.startStepSpan()
+	if !p.tracing() {
+		t.Fatal("Expected the step to be traced")
+	}
+	p.recordLoginSpan(p.Login.Registry, "push", time.Now(), errors.New("bad password hunter22"))
+	p.recordTagSpan("registry:5000/octocat/hello:latest", time.Now(), nil)
+	telemetry.End(span, nil)
+	shutdown()
+
+	step, ok := collector.Span("drone-docker")
+	if !ok {
+		t.Fatalf("Missing step span in %v", collector.Spans())
+	}
+	if step.Attributes["docker.repo"] != "registry:5000/octocat/hello" || step.Attributes["docker.registry.type"] != "ECR" {
+		t.Errorf("Unexpected step attributes %v", step.Attributes)
+	}
+
+	login, ok := collector.Span("docker.login")
+	if !ok || login.ParentSpanID != step.SpanID {
+		t.Fatalf("Expected a login span child of the step span, got %v", collector.Spans())
+	}
+	if login.Attributes["docker.registry"] != "registry:5000" || login.Attributes["docker.login.purpose"] != "push" {
+		t.Errorf("Unexpected login attributes %v", login.Attributes)
+	}
+	if login.StatusCode != 2 || strings.Contains(login.StatusMsg, "hunter22") {
+		t.Errorf("Expected a redacted login error, got %q", login.StatusMsg)
+	}
+
+	tag, ok := collector.Span("docker.tag")
+	if !ok || tag.Attributes["docker.repo"] != "registry:5000/octocat/hello" || tag.Attributes["docker.tag"] != "latest" {
+		t.Errorf("Unexpected tag span %v", tag)
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	collector := telemetrytest.NewCollector(t)
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	shutdown := telemetry.Setup("drone-docker")
+
+	p := Plugin{}
+	if p.tracing() {
+		t.Errorf("A plugin without step span should not be traced")
+	}
+	span := p.startStepSpan()
+	if p.tracing() {
+		t.Errorf("Tracing should be disabled without an OTLP endpoint")
+	}
+	p.recordSpan("docker.build", time.Now(), nil)
+	telemetry.End(span, nil)
+	shutdown()
+	if spans := collector.Spans(); len(spans) != 0 {
+		t.Errorf("Expected no exported span, got %v", spans)
+	}
+}
+
+func TestSplitImageTag(t *testing.T) {
+	tcs := []struct {
+		image, repo, tag string
+	}{
+		{"octocat/hello:1.0", "octocat/hello", "1.0"},
+		{"registry:5000/octocat/hello:latest", "registry:5000/octocat/hello", "latest"},
+		{"registry:5000/octocat/hello", "registry:5000/octocat/hello", ""},
+		{"hello", "hello", ""},
+	}
+	for _, tc := range tcs {
+		repo, tag := splitImageTag(tc.image)
+		if repo != tc.repo || tag != tc.tag {
+			t.Errorf("splitImageTag(%q) = %q, %q, want %q, %q", tc.image, repo, tag, tc.repo, tc.tag)
+		}
+	}
+}
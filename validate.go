@@ -0,0 +1,414 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// issueSeverity tells whether a configuration issue stops the step.
+type issueSeverity string
+
+const (
+	severityError   issueSeverity = "error"
+	severityWarning issueSeverity = "warning"
+)
+
+// configIssue is a problem found in the plugin configuration, reported with
+// the setting and environment variable it comes from.
+type configIssue struct {
+	Severity issueSeverity
+	Setting  string
+	EnvVar   string
+	Message  string
+}
+
+func (i configIssue) String() string {
+	return fmt.Sprintf("%s (%s): %s", i.Setting, i.EnvVar, i.Message)
+}
+
+// configIssues collects every issue found by the validation pass.
+type configIssues []configIssue
+
+func (issues *configIssues) errorf(setting, envVar, format string, args ...interface{}) {
+	*issues = append(*issues, configIssue{severityError, setting, envVar, fmt.Sprintf(format, args...)})
+}
+
+func (issues *configIssues) warnf(setting, envVar, format string, args ...interface{}) {
+	*issues = append(*issues, configIssue{severityWarning, setting, envVar, fmt.Sprintf(format, args...)})
+}
+
+// Warnings returns the issues that do not stop the step.
+func (issues configIssues) Warnings() []configIssue {
+	return issues.filter(severityWarning)
+}
+
+// Err returns a single error listing every configuration error, or nil when
+// there is none.
+func (issues configIssues) Err() error {
+	errs := issues.filter(severityError)
+	if len(errs) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration, %d error(s):", len(errs))
+	for _, issue := range errs {
+		fmt.Fprintf(&b, "\n  - %s", issue)
+	}
+	return fmt.Errorf("%s", b.String())
+}
+
+func (issues configIssues) filter(severity issueSeverity) []configIssue {
+	var out []configIssue
+	for _, issue := range issues {
+		if issue.Severity == severity {
+			out = append(out, issue)
+		}
+	}
+	return out
+}
+
+// validate checks the whole configuration before any side effect, so every
+// problem is reported at once instead of failing on the first one midway
+// through the step.
+func (p Plugin) validate() configIssues {
+	var issues configIssues
+
+	if p.PushOnly && p.Dryrun {
+		issues.errorf("push_only", "PLUGIN_PUSH_ONLY", "cannot be combined with dry_run")
+	}
+	if p.SourceImage != "" && !p.PushOnly {
+		issues.warnf("source_image", "PLUGIN_SOURCE_IMAGE", "is only used with push_only and is ignored")
+	}
+	if p.Build.Repo == "" && len(p.Build.Tags) > 0 && len(p.Images) == 0 && !p.Bake.enabled() && !p.Export.Only {
+		issues.errorf("repo", "PLUGIN_REPO", "cannot be empty")
+	}
+
+	p.validateLogin(&issues)
+	p.validateBuild(&issues)
+	p.validateImages(&issues)
+	p.validateBake(&issues)
+	p.validateExport(&issues)
+	p.validateTest(&issues)
+	p.validateSmoke(&issues)
+	p.validateChanges(&issues)
+	p.validateDaemon(&issues)
+	p.validateCosign(&issues)
+
+	if p.PushRetries < 0 {
+		issues.errorf("push_retries", "PLUGIN_PUSH_RETRIES", "cannot be negative")
+	}
+	if p.PushBackoff < 0 {
+		issues.errorf("push_backoff", "PLUGIN_PUSH_BACKOFF", "cannot be negative")
+	}
+	if p.SecretOutputs && p.SecretOutputFile == "" {
+		issues.warnf("secret_outputs", "PLUGIN_SECRET_OUTPUTS", "has no effect without HARNESS_OUTPUT_SECRET_FILE, no outputs are written")
+	}
+	if len(p.PruneFilters) > 0 && !p.PruneCache {
+		issues.warnf("prune_filters", "PLUGIN_PRUNE_FILTERS", "is ignored unless prune_cache is enabled")
+	}
+	if p.PruneCache && p.sharedDaemon() {
+		issues.warnf("prune_cache", "PLUGIN_PRUNE_CACHE", "is ignored with daemon_host or daemon_off, a shared daemon is never pruned")
+	}
+	return issues
+}
+
+func (p Plugin) validateImages(issues *configIssues) {
+	if p.Concurrency < 0 {
+		issues.errorf("concurrency", "PLUGIN_CONCURRENCY", "cannot be negative")
+	}
+	if len(p.Images) == 0 {
+		return
+	}
+	if p.PushOnly {
+		issues.errorf("images", "PLUGIN_IMAGES", "cannot be combined with push_only")
+		return
+	}
+	names := map[string]bool{}
+	for i := range p.Images {
+		ip := p.imagePlugin(i)
+		if names[ip.imageName] {
+			issues.errorf("images", "PLUGIN_IMAGES", "image name %s is used more than once", ip.imageName)
+		}
+		names[ip.imageName] = true
+		if ip.Build.Repo == "" {
+			issues.errorf("images", "PLUGIN_IMAGES", "image %s has no repo", ip.imageName)
+		}
+		if ip.Build.Dockerfile != "" && !fileExists(ip.Build.Dockerfile) {
+			issues.errorf("images", "PLUGIN_IMAGES", "dockerfile %s of image %s not found", ip.Build.Dockerfile, ip.imageName)
+		}
+	}
+}
+
+func (p Plugin) validateBake(issues *configIssues) {
+	if !p.Bake.enabled() {
+		return
+	}
+	if p.PushOnly {
+		issues.errorf("bake_files", "PLUGIN_BAKE_FILES", "cannot be combined with push_only")
+	}
+	if len(p.Images) > 0 {
+		issues.errorf("bake_files", "PLUGIN_BAKE_FILES", "cannot be combined with images")
+	}
+	for _, file := range p.Bake.Files {
+		if !fileExists(file) {
+			issues.errorf("bake_files", "PLUGIN_BAKE_FILES", "%s not found", file)
+		}
+	}
+	for _, override := range p.Bake.Set {
+		if key, _, ok := strings.Cut(override, "="); !ok || !strings.Contains(key, ".") {
+			issues.errorf("bake_set", "PLUGIN_BAKE_SET", "invalid entry %q, expected target.key=value", override)
+		}
+	}
+}
+
+func (p Plugin) validateExport(issues *configIssues) {
+	if !p.Export.enabled() {
+		if p.Export.Only || p.Export.Type != "" || p.Export.Target != "" {
+			issues.errorf("output_dest", "PLUGIN_OUTPUT_DEST", "is required to export build outputs")
+		}
+		return
+	}
+	if t := p.Export.Type; t != "" && t != exportLocal && t != exportTar {
+		issues.errorf("output_type", "PLUGIN_OUTPUT_TYPE", "invalid type %s, expected local or tar", t)
+	}
+	if p.PushOnly {
+		issues.errorf("output_dest", "PLUGIN_OUTPUT_DEST", "cannot be combined with push_only")
+	}
+	if len(p.Images) > 0 || p.Bake.enabled() {
+		issues.errorf("output_dest", "PLUGIN_OUTPUT_DEST", "cannot be combined with images, compose_file or bake")
+	}
+}
+
+func (p Plugin) validateTest(issues *configIssues) {
+	if !p.Test.enabled() {
+		if len(p.Test.Reports) > 0 {
+			issues.errorf("test_target", "PLUGIN_TEST_TARGET", "is required to collect test reports")
+		}
+		return
+	}
+	if p.PushOnly {
+		issues.errorf("test_target", "PLUGIN_TEST_TARGET", "cannot be combined with push_only")
+	}
+	if len(p.Images) > 0 || p.Bake.enabled() {
+		issues.errorf("test_target", "PLUGIN_TEST_TARGET", "cannot be combined with images, compose_file or bake")
+	}
+	for _, pattern := range p.Test.Reports {
+		if !path.IsAbs(pattern) {
+			issues.errorf("test_reports", "PLUGIN_TEST_REPORTS", "pattern %s must be an absolute path in the test stage", pattern)
+		} else if _, err := path.Match(pattern, ""); err != nil {
+			issues.errorf("test_reports", "PLUGIN_TEST_REPORTS", "invalid pattern %s", pattern)
+		}
+	}
+}
+
+func (p Plugin) validateSmoke(issues *configIssues) {
+	if !p.Smoke.enabled() {
+		return
+	}
+	if p.PushOnly {
+		issues.errorf("smoke_tests", "PLUGIN_SMOKE_TESTS", "cannot be combined with push_only")
+	}
+	if len(p.Images) > 0 || p.Bake.enabled() {
+		issues.errorf("smoke_tests", "PLUGIN_SMOKE_TESTS", "cannot be combined with images, compose_file or bake")
+	}
+	if p.Export.Only {
+		issues.errorf("smoke_tests", "PLUGIN_SMOKE_TESTS", "cannot be combined with output_only, no image is built")
+	}
+	if p.Smoke.Timeout != "" {
+		if d, err := time.ParseDuration(p.Smoke.Timeout); err != nil || d <= 0 {
+			issues.errorf("smoke_tests", "PLUGIN_SMOKE_TESTS", "invalid timeout %s", p.Smoke.Timeout)
+		}
+	}
+	for _, command := range p.Smoke.Commands {
+		for _, pattern := range append(append([]string{}, command.Stdout...), command.StdoutExcludes...) {
+			if _, err := regexp.Compile(pattern); err != nil {
+				issues.errorf("smoke_tests", "PLUGIN_SMOKE_TESTS", "command %s: invalid pattern %s", command.name(), pattern)
+			}
+		}
+	}
+	for _, file := range p.Smoke.Files {
+		if !path.IsAbs(file.Path) {
+			issues.errorf("smoke_tests", "PLUGIN_SMOKE_TESTS", "file %s must be an absolute path in the image", file.Path)
+		}
+		if file.Mode != "" {
+			if _, err := strconv.ParseUint(file.Mode, 8, 32); err != nil {
+				issues.errorf("smoke_tests", "PLUGIN_SMOKE_TESTS", "file %s: invalid mode %s, expected octal permissions such as 0755", file.Path, file.Mode)
+			}
+		}
+		if file.Absent && (file.Mode != "" || file.UID != nil || file.GID != nil) {
+			issues.warnf("smoke_tests", "PLUGIN_SMOKE_TESTS", "file %s: mode, uid and gid are ignored for an absent file", file.Path)
+		}
+	}
+}
+
+func (p Plugin) validateChanges(issues *configIssues) {
+	for _, pattern := range p.Changes.Paths {
+		if _, err := parseDockerignore(pattern); err != nil {
+			issues.errorf("paths", "PLUGIN_PATHS", "invalid pattern %s", pattern)
+		}
+	}
+	for _, pattern := range p.Changes.PathsIgnore {
+		if _, err := parseDockerignore(pattern); err != nil {
+			issues.errorf("paths_ignore", "PLUGIN_PATHS_IGNORE", "invalid pattern %s", pattern)
+		}
+	}
+}
+
+func (p Plugin) validateContext(issues *configIssues, kind string) {
+	if kind != contextLocal && (len(p.Images) > 0 || p.Bake.enabled()) {
+		issues.errorf("context", "PLUGIN_CONTEXT", "a %s context cannot be combined with images, compose_file or bake", kind)
+	}
+	if kind == contextGit {
+		if _, _, dir := parseGitContext(p.Build.Context); !insideDir(dir) {
+			issues.errorf("context", "PLUGIN_CONTEXT", "subdirectory %s is outside of the repository", dir)
+		}
+	} else if p.Build.ContextGitToken != "" {
+		issues.warnf("context_git_token", "PLUGIN_CONTEXT_GIT_TOKEN", "is only used with a git context and is ignored")
+	}
+	if p.Build.ContextChecksum != "" {
+		if kind != contextURL && kind != contextArchive {
+			issues.errorf("context_checksum", "PLUGIN_CONTEXT_CHECKSUM", "only applies to a tarball context, pin a git context to a commit instead")
+		} else if _, err := parseChecksum(p.Build.ContextChecksum); err != nil {
+			issues.errorf("context_checksum", "PLUGIN_CONTEXT_CHECKSUM", "%s", err)
+		}
+	}
+	if kind == contextURL && strings.HasPrefix(p.Build.Context, "http://") && p.Build.ContextChecksum == "" {
+		issues.warnf("context", "PLUGIN_CONTEXT", "is downloaded over plain http without a checksum")
+	}
+}
+
+func (p Plugin) validateLogin(issues *configIssues) {
+	if p.Login.Password != "" && p.Login.Username == "" {
+		issues.errorf("username", "PLUGIN_USERNAME", "is required when a password is set")
+	}
+	if p.Login.Config != "" && !json.Valid([]byte(p.Login.Config)) {
+		issues.errorf("config", "PLUGIN_CONFIG", "is not valid JSON")
+	}
+	for _, helper := range p.Login.CredHelpers {
+		if registry, name, ok := strings.Cut(helper, "="); !ok || registry == "" || name == "" {
+			issues.errorf("cred_helpers", "PLUGIN_CRED_HELPERS", "invalid entry %q, expected registry=helper", helper)
+		}
+	}
+
+	if p.BaseImageRegistry != "" {
+		connector := p.baseImageConnectors()[0]
+		if err := connector.validate(); err != nil {
+			issues.errorf("base_image_registry", "PLUGIN_BASE_IMAGE_REGISTRY", "%s", err)
+		}
+	} else if p.BaseImageUsername != "" || p.BaseImagePassword != "" {
+		issues.warnf("base_image_registry", "PLUGIN_BASE_IMAGE_REGISTRY", "is empty, the base image username and password are ignored")
+	}
+	for i, connector := range p.BaseImageConnectors {
+		if err := connector.validate(); err != nil {
+			issues.errorf("base_image_connectors", "PLUGIN_BASE_IMAGE_CONNECTORS", "connector %d (%s): %s", i+1, connector.name(), err)
+		}
+	}
+}
+
+func (p Plugin) validateBuild(issues *configIssues) {
+	if p.PushOnly {
+		if p.Build.ContentHash {
+			issues.warnf("content_hash", "PLUGIN_CONTENT_HASH", "is ignored with push_only")
+		}
+		return
+	}
+	if _, err := p.ContextCheck.limit(); err != nil {
+		issues.errorf("context_size_limit", "PLUGIN_CONTEXT_SIZE_LIMIT", "invalid size %s, expected a size such as 500MB", p.ContextCheck.SizeLimit)
+	}
+	if p.ContextCheck.enabled() && p.Bake.enabled() {
+		issues.warnf("context_analysis", "PLUGIN_CONTEXT_ANALYSIS", "is ignored with bake, the contexts of the targets are not known")
+	}
+	if p.Build.ContentHash {
+		if len(p.Images) > 0 || p.Bake.enabled() {
+			issues.errorf("content_hash", "PLUGIN_CONTENT_HASH", "cannot be combined with images, compose_file or bake")
+		}
+		if p.Export.Only {
+			issues.errorf("content_hash", "PLUGIN_CONTENT_HASH", "cannot be combined with output_only, no image is pushed")
+		}
+	}
+	kind := contextKind(p.Build.Context)
+	p.validateContext(issues, kind)
+	if p.Build.Dockerfile != "" && len(p.Images) == 0 && !p.Bake.enabled() && kind == contextLocal {
+		if _, err := os.Stat(p.Build.Dockerfile); err != nil {
+			issues.errorf("dockerfile", "PLUGIN_DOCKERFILE", "%s not found", p.Build.Dockerfile)
+		}
+	}
+	for _, secret := range p.Build.SecretEnvs {
+		if _, err := getSecretStringCmdArg(secret); err != nil {
+			issues.errorf("secrets_from_env", "PLUGIN_SECRETS_FROM_ENV", "invalid entry %q, expected id=ENV_VAR", secret)
+		} else if id, env, _ := strings.Cut(secret, "="); os.Getenv(env) == "" {
+			issues.warnf("secrets_from_env", "PLUGIN_SECRETS_FROM_ENV", "environment variable %s of secret %s is empty", env, id)
+		}
+	}
+	for _, secret := range p.Build.SecretFiles {
+		if _, err := getSecretFileCmdArg(secret); err != nil {
+			issues.errorf("secrets_from_file", "PLUGIN_SECRETS_FROM_FILE", "invalid entry %q, expected id=/path/to/file", secret)
+		} else if _, path, _ := strings.Cut(secret, "="); !fileExists(path) {
+			issues.errorf("secrets_from_file", "PLUGIN_SECRETS_FROM_FILE", "file %s not found", path)
+		}
+	}
+	for _, key := range p.Build.ArgsEnv {
+		if _, ok := os.LookupEnv(key); !ok {
+			issues.warnf("build_args_from_env", "PLUGIN_BUILD_ARGS_FROM_ENV", "environment variable %s is not set", key)
+		}
+	}
+	if p.Build.Squash && !p.Daemon.Experimental {
+		issues.warnf("squash", "PLUGIN_SQUASH", "requires the daemon experimental flag and is ignored")
+	}
+}
+
+func (p Plugin) validateDaemon(issues *configIssues) {
+	if p.Daemon.Host != "" && !strings.HasPrefix(p.Daemon.Host, "tcp://") && !strings.HasPrefix(p.Daemon.Host, "unix://") {
+		issues.errorf("daemon_host", "PLUGIN_DAEMON_HOST", "invalid address %s, expected tcp:// or unix://", p.Daemon.Host)
+	}
+	if p.Daemon.BuildkitHost != "" && !strings.HasPrefix(p.Daemon.BuildkitHost, "tcp://") {
+		issues.errorf("buildkit_host", "PLUGIN_BUILDKIT_HOST", "invalid address %s, expected tcp://", p.Daemon.BuildkitHost)
+	}
+	if p.Daemon.hasTLS() {
+		if p.Daemon.TLSCACert == "" || p.Daemon.TLSCert == "" || p.Daemon.TLSKey == "" {
+			issues.errorf("tls_cacert", "PLUGIN_TLS_CACERT", "tls requires a CA certificate, client certificate and client key")
+		}
+		if p.Daemon.Host == "" && p.Daemon.BuildkitHost == "" {
+			issues.warnf("tls_cacert", "PLUGIN_TLS_CACERT", "is ignored without daemon_host or buildkit_host")
+		}
+	}
+}
+
+func (p Plugin) validateCosign(issues *configIssues) {
+	if !p.shouldSignWithCosign() {
+		return
+	}
+	if strings.Contains(p.Cosign.Params, "--oidc") || strings.Contains(p.Cosign.Params, "--identity-token") {
+		issues.errorf("cosign_params", "PLUGIN_COSIGN_PARAMS", "keyless signing is not supported, use a private key")
+	}
+	if strings.HasPrefix(p.Cosign.PrivateKey, "-----BEGIN") {
+		if !isValidPEMKey(p.Cosign.PrivateKey) {
+			issues.errorf("cosign_private_key", "PLUGIN_COSIGN_PRIVATE_KEY", "invalid private key, expected PEM format")
+		} else if isEncryptedPEMKey(p.Cosign.PrivateKey) && p.Cosign.Password == "" {
+			issues.errorf("cosign_password", "PLUGIN_COSIGN_PASSWORD", "is required for an encrypted private key")
+		}
+	}
+	if p.Dryrun {
+		issues.warnf("cosign_private_key", "PLUGIN_COSIGN_PRIVATE_KEY", "signing is skipped with dry_run")
+	}
+}
+
+// reportConfigIssues prints the configuration warnings and returns the
+// aggregated configuration error.
+func reportConfigIssues(issues configIssues) error {
+	for _, issue := range issues.Warnings() {
+		fmt.Printf("\033[33mWarning: %s\033[0m\n", secrets.Redact(issue.String()))
+	}
+	return issues.Err()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,130 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	if err := os.WriteFile(dockerfile, []byte("FROM alpine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	valid := Plugin{
+		Build: Build{Dockerfile: dockerfile, Repo: "octocat/hello", Tags: []string{"latest"}},
+	}
+
+	tcs := []struct {
+		name     string
+		mutate   func(p *Plugin)
+		errors   []string
+		warnings []string
+	}{
+		{
+			name:   "valid",
+			mutate: func(p *Plugin) {},
+		},
+		{
+			name: "every error is reported at once",
+			mutate: func(p *Plugin) {
+				p.PushOnly = true
+				p.Dryrun = true
+				p.Build.Repo = ""
+				p.Login.Password = "secret"
+				p.PushRetries = -1
+			},
+			errors: []string{"PLUGIN_PUSH_ONLY", "PLUGIN_REPO", "PLUGIN_USERNAME", "PLUGIN_PUSH_RETRIES"},
+		},
+		{
+			name: "invalid build secrets",
+			mutate: func(p *Plugin) {
+				p.Build.SecretEnvs = []string{"token"}
+				p.Build.SecretFiles = []string{"npmrc=" + filepath.Join(dir, "missing")}
+			},
+			errors: []string{"PLUGIN_SECRETS_FROM_ENV", "PLUGIN_SECRETS_FROM_FILE"},
+		},
+		{
+			name: "base image connector without username",
+			mutate: func(p *Plugin) {
+				p.BaseImageConnectors = []BaseImageConnector{{Registry: "quay.io", Password: "secret"}}
+			},
+			errors: []string{"PLUGIN_BASE_IMAGE_CONNECTORS"},
+		},
+		{
+			name: "missing dockerfile",
+			mutate: func(p *Plugin) {
+				p.Build.Dockerfile = filepath.Join(dir, "Dockerfile.missing")
+			},
+			errors: []string{"PLUGIN_DOCKERFILE"},
+		},
+		{
+			name: "invalid remote endpoints",
+			mutate: func(p *Plugin) {
+				p.Daemon.Host = "docker:2376"
+				p.Daemon.BuildkitHost = "unix:///run/buildkit.sock"
+				p.Daemon.TLSCert = "cert"
+			},
+			errors: []string{"PLUGIN_DAEMON_HOST", "PLUGIN_BUILDKIT_HOST", "PLUGIN_TLS_CACERT"},
+		},
+		{
+			name: "keyless signing",
+			mutate: func(p *Plugin) {
+				p.Cosign = CosignConfig{PrivateKey: "cosign.key", Params: "--oidc-issuer https://example.com"}
+			},
+			errors: []string{"PLUGIN_COSIGN_PARAMS"},
+		},
+		{
+			name: "warnings do not fail",
+			mutate: func(p *Plugin) {
+				p.Build.Squash = true
+				p.PruneFilters = []string{"until=24h"}
+				p.SourceImage = "octocat/hello:dev"
+				p.SecretOutputs = true
+			},
+			warnings: []string{"PLUGIN_SOURCE_IMAGE", "PLUGIN_SQUASH", "PLUGIN_SECRET_OUTPUTS", "PLUGIN_PRUNE_FILTERS"},
+		},
+		{
+			name: "push only skips build checks",
+			mutate: func(p *Plugin) {
+				p.PushOnly = true
+				p.Build.Dockerfile = filepath.Join(dir, "Dockerfile.missing")
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			p := valid
+			tc.mutate(&p)
+			issues := p.validate()
+
+			err := issues.Err()
+			if len(tc.errors) == 0 && err != nil {
+				t.Errorf("Unexpected error %s", err)
+			}
+			if len(tc.errors) > 0 {
+				if err == nil {
+					t.Fatalf("Expected errors for %v", tc.errors)
+				}
+				for _, env := range tc.errors {
+					if !strings.Contains(err.Error(), env) {
+						t.Errorf("Expected error for %s, got %s", env, err)
+					}
+				}
+			}
+
+			warnings := issues.Warnings()
+			if len(warnings) != len(tc.warnings) {
+				t.Errorf("Got warnings %v, want %v", warnings, tc.warnings)
+			}
+			for i, env := range tc.warnings {
+				if i < len(warnings) && warnings[i].EnvVar != env {
+					t.Errorf("Got warning for %s, want %s", warnings[i].EnvVar, env)
+				}
+			}
+		})
+	}
+}